@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/grafana/sobek"
+)
+
+// Body types of RequestParams.BodyType. An object body without bodyType is
+// sent as JSON; string, binary and file bodies are sent as they are, with the
+// Content-Type of their bodyType if one is given.
+const (
+	bodyTypeJSON      = "json"
+	bodyTypeForm      = "form"      // application/x-www-form-urlencoded
+	bodyTypeMultipart = "multipart" // multipart/form-data, streamed
+)
+
+var bodyTypes = []string{bodyTypeJSON, bodyTypeForm, bodyTypeMultipart}
+
+var bodyContentTypes = map[string]string{
+	bodyTypeJSON: "application/json",
+	bodyTypeForm: "application/x-www-form-urlencoded",
+}
+
+// requestBody is the encoded form of a request body other than a plain
+// string. It is opened anew for every attempt and redirect: JSON, form and
+// binary bodies are encoded once per request, while file and multipart
+// bodies are streamed so that large files are never held in memory.
+type requestBody struct {
+	contentType string
+	data        []byte
+	path        string // file body, size bytes long
+	size        int64
+	parts       []multipartPart
+	boundary    string // of multipart bodies, the same for every attempt and redirect
+}
+
+// multipartPart is a form field, or a file part when file is set.
+type multipartPart struct {
+	name, value string
+	file        bool
+	path        string // file content is read from path, or taken from data
+	data        []byte
+	filename    string
+	contentType string
+}
+
+// encodeBody validates the body options and encodes the body into r.body. A
+// plain string body without bodyType is left to buildRequest.
+func (r *RequestParams) encodeBody() error {
+	bodyType := strings.ToLower(strings.TrimSpace(r.BodyType))
+	if bodyType != "" && !slices.Contains(bodyTypes, bodyType) {
+		return fmt.Errorf("unsupported bodyType %q (want one of %s)", r.BodyType, strings.Join(bodyTypes, ", "))
+	}
+	hasBody := r.Body != "" || r.bodyBytes != nil || r.bodyValue != nil
+	if r.BodyFile != "" {
+		if hasBody {
+			return fmt.Errorf("body and bodyFile cannot be combined")
+		}
+		if bodyType == bodyTypeMultipart {
+			return fmt.Errorf("bodyType multipart needs an object body")
+		}
+		fi, err := os.Stat(r.BodyFile)
+		if err != nil {
+			return fmt.Errorf("bodyFile: %w", err)
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("bodyFile %s is not a regular file", r.BodyFile)
+		}
+		r.body = &requestBody{contentType: bodyContentTypes[bodyType], path: r.BodyFile, size: fi.Size()}
+		return nil
+	}
+	if r.bodyValue == nil {
+		if bodyType == bodyTypeMultipart && hasBody {
+			return fmt.Errorf("bodyType multipart needs an object body")
+		}
+		switch {
+		case r.bodyBytes != nil:
+			r.body = &requestBody{contentType: bodyContentTypes[bodyType], data: r.bodyBytes}
+		case r.Body != "" && bodyType != "":
+			r.body = &requestBody{contentType: bodyContentTypes[bodyType], data: []byte(r.Body)}
+		}
+		return nil
+	}
+
+	switch bodyType {
+	case "", bodyTypeJSON:
+		data, err := json.Marshal(r.bodyValue)
+		if err != nil {
+			return fmt.Errorf("json body: %w", err)
+		}
+		r.body = &requestBody{contentType: bodyContentTypes[bodyTypeJSON], data: data}
+	case bodyTypeForm:
+		fields, err := bodyFields(r.bodyValue)
+		if err != nil {
+			return err
+		}
+		values := url.Values{}
+		for _, name := range sortedKeys(fields) {
+			for _, v := range asList(fields[name]) {
+				s, err := formValue(name, v)
+				if err != nil {
+					return err
+				}
+				values.Add(name, s)
+			}
+		}
+		r.body = &requestBody{contentType: bodyContentTypes[bodyTypeForm], data: []byte(values.Encode())}
+	case bodyTypeMultipart:
+		fields, err := bodyFields(r.bodyValue)
+		if err != nil {
+			return err
+		}
+		b := &requestBody{boundary: multipart.NewWriter(io.Discard).Boundary()}
+		b.contentType = "multipart/form-data; boundary=" + b.boundary
+		for _, name := range sortedKeys(fields) {
+			for _, v := range asList(fields[name]) {
+				part, err := decodeMultipartPart(name, v)
+				if err != nil {
+					return err
+				}
+				b.parts = append(b.parts, part)
+			}
+		}
+		r.body = b
+	}
+	return nil
+}
+
+// bodyFields returns the fields of a form or multipart body object.
+func bodyFields(v any) (map[string]any, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("form and multipart bodies must be objects, got %T", v)
+	}
+	return m, nil
+}
+
+// asList returns the elements of an array value, or v alone.
+func asList(v any) []any {
+	if l, ok := v.([]any); ok {
+		return l
+	}
+	return []any{v}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formValue returns a scalar field value as a string; null is an empty value.
+func formValue(name string, v any) (string, error) {
+	switch v.(type) {
+	case nil:
+		return "", nil
+	case map[string]any, []any:
+		return "", fmt.Errorf("form field %q: nested values are not supported", name)
+	}
+	s, _ := asString(v)
+	return s, nil
+}
+
+// decodeMultipartPart decodes a field value; an object with path or data is a
+// file part: { path | data, filename, contentType }.
+func decodeMultipartPart(name string, v any) (multipartPart, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		s, err := formValue(name, v)
+		return multipartPart{name: name, value: s}, err
+	}
+	p := multipartPart{name: name, file: true}
+	if v, ok := m["path"]; ok && v != nil {
+		p.path, _ = asString(v)
+	}
+	if v, ok := m["data"]; ok && v != nil {
+		p.data, ok = asBytes(v)
+		if !ok {
+			return p, fmt.Errorf("multipart field %q: data must be a string or bytes, got %T", name, v)
+		}
+	}
+	if v, ok := m["filename"]; ok && v != nil {
+		p.filename, _ = asString(v)
+	}
+	if v, ok := m["contentType"]; ok && v != nil {
+		p.contentType, _ = asString(v)
+	}
+	switch {
+	case p.path == "" && p.data == nil:
+		return p, fmt.Errorf("multipart field %q: file part needs path or data", name)
+	case p.path != "" && p.data != nil:
+		return p, fmt.Errorf("multipart field %q: file part has both path and data", name)
+	case p.path != "":
+		if _, err := os.Stat(p.path); err != nil {
+			return p, fmt.Errorf("multipart field %q: %w", name, err)
+		}
+		if p.filename == "" {
+			p.filename = filepath.Base(p.path)
+		}
+	}
+	if p.filename == "" {
+		p.filename = name
+	}
+	if p.contentType == "" {
+		p.contentType = "application/octet-stream"
+	}
+	return p, nil
+}
+
+// asBinary returns the bytes of an ArrayBuffer or Uint8Array body. sobek
+// exports a Uint8Array as the []byte of its view; other typed arrays export as
+// slices of their element type and are not binary bodies.
+func asBinary(v any) ([]byte, bool) {
+	if _, ok := v.(string); ok {
+		return nil, false
+	}
+	return asBytes(v)
+}
+
+// asBytes converts a string, byte slice (an exported Uint8Array) or JS ArrayBuffer to bytes.
+func asBytes(v any) ([]byte, bool) {
+	switch b := v.(type) {
+	case []byte:
+		return b, true
+	case string:
+		return []byte(b), true
+	case sobek.ArrayBuffer:
+		return b.Bytes(), true
+	default:
+		return nil, false
+	}
+}
+
+// open returns a reader of the body. Multipart bodies are written by a
+// goroutine into a pipe, which stops when the reader is closed (the
+// transport closes request bodies on every outcome).
+func (b *requestBody) open() (io.ReadCloser, error) {
+	switch {
+	case b.path != "":
+		return os.Open(b.path)
+	case b.parts == nil:
+		return io.NopCloser(bytes.NewReader(b.data)), nil
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	_ = mw.SetBoundary(b.boundary)
+	go func() {
+		pw.CloseWithError(b.writeParts(mw))
+	}()
+	return pr, nil
+}
+
+// length returns the Content-Length of the body, -1 (unknown) for multipart,
+// which is streamed as it is written.
+func (b *requestBody) length() int64 {
+	switch {
+	case b.path != "":
+		return b.size
+	case b.parts == nil:
+		return int64(len(b.data))
+	}
+	return -1
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (b *requestBody) writeParts(mw *multipart.Writer) error {
+	for _, p := range b.parts {
+		if !p.file {
+			if err := mw.WriteField(p.name, p.value); err != nil {
+				return err
+			}
+			continue
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(p.name), quoteEscaper.Replace(p.filename)))
+		h.Set("Content-Type", p.contentType)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if p.path == "" {
+			if _, err := w.Write(p.data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyFile(w, p.path); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafana/sobek"
+)
+
+// bodyEcho answers with the request's Content-Type and body; /redirect
+// redirects with 307 so the body must be sent again.
+func bodyEcho(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/upload", http.StatusTemporaryRedirect)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var parts []string
+			for name, values := range r.MultipartForm.Value {
+				parts = append(parts, name+"="+strings.Join(values, ","))
+			}
+			for name, files := range r.MultipartForm.File {
+				for _, fh := range files {
+					f, _ := fh.Open()
+					data, _ := io.ReadAll(f)
+					f.Close()
+					parts = append(parts, fmt.Sprintf("%s:%s:%s:%s", name, fh.Filename, fh.Header.Get("Content-Type"), data))
+				}
+			}
+			io.WriteString(w, "multipart "+strings.Join(parts, " "))
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		io.WriteString(w, r.Header.Get("Content-Type")+" "+string(data))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Given an object body without bodyType, with bodyType json and with bodyType form
+// When the requests are sent
+// Then the body is encoded and Content-Type set accordingly, unless a header overrides it
+func TestBody_GivenObject_WhenJSONOrForm_ThenEncodedWithContentType(t *testing.T) {
+	t.Parallel()
+	ts := bodyEcho(t)
+	c := newHealthClient()
+	obj := map[string]any{"name": "a b", "tags": []any{"x", "y"}, "n": int64(2)}
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "method": "POST", "body": obj})
+	ct, payload, _ := strings.Cut(string(resp.Body), " ")
+	var got map[string]any
+	if ct != "application/json" || json.Unmarshal([]byte(payload), &got) != nil || got["name"] != "a b" {
+		t.Fatalf("json body=%q", resp.Body)
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": ts.URL, "method": "POST", "bodyType": "form", "body": obj})
+	if string(resp.Body) != "application/x-www-form-urlencoded n=2&name=a+b&tags=x&tags=y" {
+		t.Fatalf("form body=%q", resp.Body)
+	}
+
+	resp = doRequest(t, c, map[string]any{
+		"url": ts.URL, "method": "POST", "bodyType": "json", "body": `{"raw":true}`,
+		"http": map[string]any{"headers": map[string]any{"Content-Type": "application/vnd.api+json"}},
+	})
+	if string(resp.Body) != `application/vnd.api+json {"raw":true}` {
+		t.Fatalf("string json body=%q", resp.Body)
+	}
+}
+
+// Given a multipart body with a field, a file from disk and a file from bytes
+// When it is sent to a URL that redirects with 307
+// Then the streamed body arrives intact at the final URL
+func TestBody_GivenMultipart_WhenRedirected_ThenFieldsAndFilesArrive(t *testing.T) {
+	t.Parallel()
+	ts := bodyEcho(t)
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(path, []byte("a,b\n1,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{
+		"url": ts.URL + "/redirect", "method": "POST", "bodyType": "multipart",
+		"body": map[string]any{
+			"title":  "q3",
+			"report": map[string]any{"path": path, "contentType": "text/csv"},
+			"avatar": map[string]any{"data": []byte{0x89, 'P', 'N', 'G'}, "filename": "me.png"},
+		},
+		"http": map[string]any{"followRedirects": true},
+	})
+	body := string(resp.Body)
+	for _, want := range []string{"title=q3", "report:report.csv:text/csv:a,b\n1,2\n", "avatar:me.png:application/octet-stream:\x89PNG"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %q in %q (status %d, err %s)", want, body, resp.Status, resp.Error)
+		}
+	}
+}
+
+// Given invalid structured bodies
+// When requests are sent
+// Then they fail before anything is sent
+func TestBody_GivenInvalid_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	cases := []struct {
+		params map[string]any
+		want   string
+	}{
+		{map[string]any{"bodyType": "xml", "body": "<a/>"}, "unsupported bodyType"},
+		{map[string]any{"bodyType": "multipart", "body": "a=b"}, "needs an object body"},
+		{map[string]any{"bodyType": "form", "body": map[string]any{"a": map[string]any{"b": "c"}}}, `form field "a"`},
+		{map[string]any{"bodyType": "multipart", "body": map[string]any{"f": map[string]any{"filename": "x"}}}, "needs path or data"},
+		{map[string]any{"bodyType": "multipart", "body": map[string]any{"f": map[string]any{"path": "/nonexistent/file"}}}, `multipart field "f"`},
+		{map[string]any{"bodyFile": "/nonexistent/file"}, "bodyFile"},
+	}
+	for _, tc := range cases {
+		tc.params["url"] = "http://example.invalid/"
+		tc.params["method"] = "POST"
+		if resp := doRequest(t, c, tc.params); !strings.Contains(resp.Error, tc.want) {
+			t.Fatalf("%v: error=%q want %q", tc.params["body"], resp.Error, tc.want)
+		}
+	}
+}
+
+// bodyDigest answers with the Content-Length, Transfer-Encoding and sha256 of the request body.
+func bodyDigest(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := sha256.New()
+		io.Copy(h, r.Body)
+		fmt.Fprintf(w, "%d %s %s", r.ContentLength, strings.Join(r.TransferEncoding, ","), hex.EncodeToString(h.Sum(nil)))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Given bodies that are not valid UTF-8, as a Uint8Array and as an ArrayBuffer
+// When they are sent
+// Then the bytes arrive unchanged
+func TestBody_GivenBinary_WhenSent_ThenBytesIntact(t *testing.T) {
+	t.Parallel()
+	ts := bodyDigest(t)
+	c := newHealthClient()
+	data := []byte{0x00, 0xff, 0xfe, 0x80, 'a', 0xc3}
+	want := fmt.Sprintf("%d  %s", len(data), digest(data))
+	for _, body := range []any{data, sobek.New().NewArrayBuffer(data)} {
+		if resp := doRequest(t, c, map[string]any{"url": ts.URL, "method": "PUT", "body": body}); string(resp.Body) != want {
+			t.Fatalf("%T: got %q want %q", body, resp.Body, want)
+		}
+	}
+}
+
+// Given request params built by a JS runtime, with a Uint8Array (a view into a
+// larger buffer) as the body and as the data of a multipart file part
+// When they are sent
+// Then the bytes of the view arrive unchanged, and the multipart body is chunked
+func TestBody_GivenJSUint8Array_WhenSent_ThenBytesIntact(t *testing.T) {
+	t.Parallel()
+	ts := bodyDigest(t)
+	c := newHealthClient()
+	vm := sobek.New()
+	_ = vm.Set("url", ts.URL)
+	v, err := vm.RunString(`({url: url, method: "PUT", body: new Uint8Array([1, 0, 255, 254, 128, 97, 195, 2]).subarray(1, 7)})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{0x00, 0xff, 0xfe, 0x80, 'a', 0xc3}
+	if resp := doRequest(t, c, v.Export().(map[string]any)); string(resp.Body) != fmt.Sprintf("%d  %s", len(data), digest(data)) {
+		t.Fatalf("body: got %q err=%s", resp.Body, resp.Error)
+	}
+
+	v, err = vm.RunString(`({url: url, method: "POST", bodyType: "multipart", body: {file: {data: new Uint8Array([0, 255]), filename: "a.bin"}}})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := doRequest(t, c, v.Export().(map[string]any)); !strings.HasPrefix(string(resp.Body), "-1 chunked ") {
+		t.Fatalf("multipart: got %q err=%s", resp.Body, resp.Error)
+	}
+}
+
+// Given a multi-megabyte bodyFile
+// When it is sent with and without http.chunked
+// Then it arrives intact, with a Content-Length or chunked
+func TestBody_GivenBodyFile_WhenSent_ThenStreamedWithLengthOrChunked(t *testing.T) {
+	t.Parallel()
+	ts := bodyDigest(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256<<10) // 4 MiB
+	path := filepath.Join(t.TempDir(), "upload.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := newHealthClient()
+	params := map[string]any{"url": ts.URL, "method": "POST", "bodyFile": path}
+	if resp := doRequest(t, c, params); string(resp.Body) != fmt.Sprintf("%d  %s", len(data), digest(data)) {
+		t.Fatalf("plain: %q err=%s", resp.Body, resp.Error)
+	}
+	params["http"] = map[string]any{"chunked": true}
+	if resp := doRequest(t, c, params); string(resp.Body) != "-1 chunked "+digest(data) {
+		t.Fatalf("chunked: %q err=%s", resp.Body, resp.Error)
+	}
+
+	params["body"] = "x"
+	if resp := doRequest(t, c, params); !strings.Contains(resp.Error, "cannot be combined") {
+		t.Fatalf("body with bodyFile: %q", resp.Error)
+	}
+}
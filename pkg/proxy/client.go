@@ -1,13 +1,14 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"go.k6.io/k6/js/modules"
 )
 
 // HTTPOptions defines HTTP-specific options for requests
@@ -15,10 +16,12 @@ type HTTPOptions struct {
 	Timeout             string            `json:"timeout"`
 	InsecureSkipVerify  bool              `json:"insecureSkipVerify"`
 	DisableHTTP2        bool              `json:"disableHTTP2"`
+	Protocol            string            `json:"protocol" js:"protocol"` // "auto" (default), "h3" or "h2c"; see http_client.go
 	AutoReferer         bool              `json:"autoReferer"`
 	RandomReferer       bool              `json:"randomReferer"`
 	FollowRedirects     bool              `json:"followRedirects"`
 	AcceptGzip          bool              `json:"acceptGzip"`
+	AcceptEncoding      []string          `json:"acceptEncoding" js:"acceptEncoding"` // codings to advertise and decode: "br", "zstd", "gzip", "deflate"; overrides acceptGzip
 	Headers             map[string]string `json:"headers"`
 	RandomUserAgent     bool              `json:"randomUserAgent"`
 	UserAgentListPath   string            `json:"userAgentListPath"`
@@ -26,6 +29,30 @@ type HTTPOptions struct {
 	SkipDecompress      bool              `json:"skipDecompress"`
 	RandomPathWithQuery bool              `json:"randomPathWithQuery"`
 	RandomPath          bool              `json:"randomPath"`
+	Cookies             string            `json:"cookies" js:"cookies"` // cookie jar: "none" (default), "vu", "proxy" or "shared"
+	Chunked             bool              `json:"chunked" js:"chunked"` // send request bodies with chunked transfer encoding
+
+	// Redirects, when followRedirects is set; see redirect.go.
+	MaxRedirects      int    `json:"maxRedirects" js:"maxRedirects"`           // 0 = 10, as net/http
+	RedirectCrossHost string `json:"redirectCrossHost" js:"redirectCrossHost"` // "allow" (default) or "deny" redirects to another host
+	RedirectDowngrade string `json:"redirectDowngrade" js:"redirectDowngrade"` // "allow" (default) or "deny" https to http redirects
+	RedirectProxy     string `json:"redirectProxy" js:"redirectProxy"`         // "same" (default) or "rotate" the proxy per hop
+	RedirectReferer   string `json:"redirectReferer" js:"redirectReferer"`     // "keep" (default), "hop" or "none"
+
+	// Response body handling; see response.go.
+	ResponseType string `json:"responseType" js:"responseType"` // "binary" (default), "text", "json" or "none"
+	MaxBodyBytes int64  `json:"maxBodyBytes" js:"maxBodyBytes"` // keep at most this many bytes, 0 = all
+	BodyHash     string `json:"bodyHash" js:"bodyHash"`         // digest of the whole body: "sha256", "sha512", "sha1" or "md5"
+	DrainLimit   int64  `json:"drainLimit" js:"drainLimit"`     // bytes of an unkept body read to reuse the connection; 0 = 1 MiB, < 0 = none
+
+	// Target retries through the same proxy; see retry.go.
+	Retries            int      `json:"retries" js:"retries"`                       // 0 disables
+	RetryStatuses      []int    `json:"retryStatuses" js:"retryStatuses"`           // default 429, 502, 503, 504
+	RetryErrors        []string `json:"retryErrors" js:"retryErrors"`               // error classes, default target_reset, target_timeout
+	RetryBackoff       string   `json:"retryBackoff" js:"retryBackoff"`             // base backoff (full jitter, doubles per retry)
+	RetryMaxBackoff    string   `json:"retryMaxBackoff" js:"retryMaxBackoff"`       // backoff cap
+	MaxRetryAfter      string   `json:"maxRetryAfter" js:"maxRetryAfter"`           // longest Retry-After honoured; longer ones are not retried
+	RetryNonIdempotent bool     `json:"retryNonIdempotent" js:"retryNonIdempotent"` // also retry POST and PATCH
 
 	// Presence flags (not serialized). True when user explicitly supplied the value in request/script.
 	DiscardBodyProvided    bool `json:"-"`
@@ -36,7 +63,35 @@ type HTTPOptions struct {
 type ProxyOptions struct {
 	URL      string `json:"url"`
 	ListPath string `json:"listPath"`
+	Pool     string `json:"pool" js:"pool"` // named pool from loadProxyList(path, {name}); empty = the unnamed pool
 	Disable  bool   `json:"disable"`
+
+	// DefaultScheme is used for list entries without a scheme (host:port:user:pass etc.)
+	DefaultScheme string `json:"defaultScheme" js:"defaultScheme"`
+
+	// Filter restricts list rotation to entries with matching metadata
+	Filter *ProxyFilter `json:"filter,omitempty" js:"filter"`
+
+	// Failover through another proxy of the list (ignored for a pinned URL)
+	MaxRetries      int      `json:"maxRetries" js:"maxRetries"`
+	RetryOn         []string `json:"retryOn" js:"retryOn"`                 // error classes and/or status codes; empty = proxy-attributable classes
+	RetryBackoff    string   `json:"retryBackoff" js:"retryBackoff"`       // base backoff between attempts, jittered
+	RetryMaxBackoff string   `json:"retryMaxBackoff" js:"retryMaxBackoff"` // cap for the exponential backoff
+	RetryBudget     string   `json:"retryBudget" js:"retryBudget"`         // overall deadline for all attempts
+
+	// Per-proxy limits (list metadata maxInFlight/maxRps override them)
+	MaxInFlight int     `json:"maxInFlight" js:"maxInFlight"` // concurrent requests per proxy; 0 = unlimited
+	MaxRps      float64 `json:"maxRps" js:"maxRps"`           // requests per second per proxy; 0 = unlimited
+	LimitWait   string  `json:"limitWait" js:"limitWait"`     // how long to wait for a free proxy when all are saturated; empty fails at once
+
+	// Session rotation of templated proxy URLs ({session}, see session.go)
+	SessionRotation string `json:"sessionRotation" js:"sessionRotation"` // iteration (default), request, count or failure
+	SessionRequests int    `json:"sessionRequests" js:"sessionRequests"` // requests per session with sessionRotation "count"
+
+	// What to do when no proxy of the pool is available (see exhausted.go)
+	OnExhausted   string `json:"onExhausted" js:"onExhausted"`     // error (default), wait, direct or abort
+	ExhaustedWait string `json:"exhaustedWait" js:"exhaustedWait"` // how long "wait" waits for a proxy to recover; default 30s
+	AbortAfter    string `json:"abortAfter" js:"abortAfter"`       // how long the pool must stay exhausted before "abort" aborts the test
 }
 
 // ApplyDefaults fills zero-values from a default HTTPOptions in a predictable way.
@@ -46,6 +101,9 @@ func (o *HTTPOptions) ApplyDefaults(def HTTPOptions) {
 	if o.Timeout == "" && def.Timeout != "" {
 		o.Timeout = def.Timeout
 	}
+	if o.Protocol == "" {
+		o.Protocol = def.Protocol
+	}
 
 	// Booleans without presence tracking: only adopt default when it's true and current is false.
 	if !o.InsecureSkipVerify && def.InsecureSkipVerify {
@@ -63,9 +121,15 @@ func (o *HTTPOptions) ApplyDefaults(def HTTPOptions) {
 	if !o.AcceptGzip && def.AcceptGzip {
 		o.AcceptGzip = true
 	}
+	if len(o.AcceptEncoding) == 0 {
+		o.AcceptEncoding = def.AcceptEncoding
+	}
 	if !o.RandomUserAgent && def.RandomUserAgent {
 		o.RandomUserAgent = true
 	}
+	if !o.Chunked && def.Chunked {
+		o.Chunked = true
+	}
 
 	// Booleans with presence tracking: use defaults only when not explicitly provided by the user.
 	if !o.DiscardBodyProvided {
@@ -87,6 +151,58 @@ func (o *HTTPOptions) ApplyDefaults(def HTTPOptions) {
 		}
 	}
 
+	if o.Cookies == "" {
+		o.Cookies = def.Cookies
+	}
+	if o.MaxRedirects == 0 {
+		o.MaxRedirects = def.MaxRedirects
+	}
+	if o.RedirectCrossHost == "" {
+		o.RedirectCrossHost = def.RedirectCrossHost
+	}
+	if o.RedirectDowngrade == "" {
+		o.RedirectDowngrade = def.RedirectDowngrade
+	}
+	if o.RedirectProxy == "" {
+		o.RedirectProxy = def.RedirectProxy
+	}
+	if o.RedirectReferer == "" {
+		o.RedirectReferer = def.RedirectReferer
+	}
+	if o.ResponseType == "" {
+		o.ResponseType = def.ResponseType
+	}
+	if o.MaxBodyBytes == 0 {
+		o.MaxBodyBytes = def.MaxBodyBytes
+	}
+	if o.BodyHash == "" {
+		o.BodyHash = def.BodyHash
+	}
+	if o.DrainLimit == 0 {
+		o.DrainLimit = def.DrainLimit
+	}
+	if o.Retries == 0 {
+		o.Retries = def.Retries
+	}
+	if len(o.RetryStatuses) == 0 {
+		o.RetryStatuses = def.RetryStatuses
+	}
+	if len(o.RetryErrors) == 0 {
+		o.RetryErrors = def.RetryErrors
+	}
+	if o.RetryBackoff == "" {
+		o.RetryBackoff = def.RetryBackoff
+	}
+	if o.RetryMaxBackoff == "" {
+		o.RetryMaxBackoff = def.RetryMaxBackoff
+	}
+	if o.MaxRetryAfter == "" {
+		o.MaxRetryAfter = def.MaxRetryAfter
+	}
+	if !o.RetryNonIdempotent && def.RetryNonIdempotent {
+		o.RetryNonIdempotent = true
+	}
+
 	// Paths
 	if o.UserAgentListPath == "" && def.UserAgentListPath != "" {
 		o.UserAgentListPath = def.UserAgentListPath
@@ -113,18 +229,72 @@ func (o *ProxyOptions) ApplyDefaults(def ProxyOptions) {
 	if o.ListPath == "" && def.ListPath != "" {
 		o.ListPath = def.ListPath
 	}
+	if o.Pool == "" {
+		o.Pool = def.Pool
+	}
 	if !o.Disable {
 		o.Disable = def.Disable
 	}
+	if o.DefaultScheme == "" {
+		o.DefaultScheme = def.DefaultScheme
+	}
+	if o.Filter.empty() && !def.Filter.empty() {
+		o.Filter = def.Filter
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = def.MaxRetries
+	}
+	if len(o.RetryOn) == 0 && len(def.RetryOn) > 0 {
+		o.RetryOn = def.RetryOn
+	}
+	if o.RetryBackoff == "" {
+		o.RetryBackoff = def.RetryBackoff
+	}
+	if o.RetryMaxBackoff == "" {
+		o.RetryMaxBackoff = def.RetryMaxBackoff
+	}
+	if o.RetryBudget == "" {
+		o.RetryBudget = def.RetryBudget
+	}
+	if o.MaxInFlight == 0 {
+		o.MaxInFlight = def.MaxInFlight
+	}
+	if o.MaxRps == 0 {
+		o.MaxRps = def.MaxRps
+	}
+	if o.LimitWait == "" {
+		o.LimitWait = def.LimitWait
+	}
+	if o.SessionRotation == "" {
+		o.SessionRotation = def.SessionRotation
+	}
+	if o.SessionRequests == 0 {
+		o.SessionRequests = def.SessionRequests
+	}
+	if o.OnExhausted == "" {
+		o.OnExhausted = def.OnExhausted
+	}
+	if o.ExhaustedWait == "" {
+		o.ExhaustedWait = def.ExhaustedWait
+	}
+	if o.AbortAfter == "" {
+		o.AbortAfter = def.AbortAfter
+	}
 }
 
 // RequestParams defines the input parameters for each request (with nested HTTP/Proxy options)
 type RequestParams struct {
-	URL    string       `json:"url"`
-	Method string       `json:"method"`
-	Body   string       `json:"body"`
-	HTTP   HTTPOptions  `json:"http"`
-	Proxy  ProxyOptions `json:"proxy"`
+	URL      string       `json:"url"`
+	Method   string       `json:"method"`
+	Body     string       `json:"body"`
+	BodyType string       `json:"bodyType" js:"bodyType"` // "json", "form" or "multipart"; see body.go
+	BodyFile string       `json:"bodyFile" js:"bodyFile"` // file streamed as the body
+	HTTP     HTTPOptions  `json:"http"`
+	Proxy    ProxyOptions `json:"proxy"`
+
+	bodyValue any          // object or array body, encoded by bodyType
+	bodyBytes []byte       // ArrayBuffer or typed array body
+	body      *requestBody // encoded bodyValue, or Body with the Content-Type of bodyType
 }
 
 // Response defines the output returned to JS
@@ -132,25 +302,68 @@ type Response struct {
 	Status int    `json:"status"`
 	Body   []byte `json:"body"`
 	Error  string `json:"error,omitempty"`
+
+	Text      string `json:"text,omitempty"`                   // responseType "text"
+	JSON      any    `json:"json,omitempty"`                   // responseType "json"
+	Truncated bool   `json:"truncated,omitempty"`              // the body was longer than maxBodyBytes, or than drainLimit
+	BodyHash  string `json:"bodyHash,omitempty" js:"bodyHash"` // hex digest of http.bodyHash
+	BodySize  int64  `json:"bodySize" js:"bodySize"`           // body bytes read, kept or not, after decoding; 0 for a discarded encoded body
+
+	ContentEncoding string `json:"contentEncoding,omitempty" js:"contentEncoding"` // content coding the body was decoded from
+	EncodedSize     int64  `json:"encodedSize,omitempty" js:"encodedSize"`         // body bytes read before decoding, or drained undecoded; 0 when the Transport decoded gzip
+
+	ConnReused bool          `json:"connReused,omitempty" js:"connReused"` // the request went over a pooled connection
+	Redirects  []RedirectHop `json:"redirects,omitempty"`                  // every redirect response, in order
+
+	ErrorClass   ErrorClass    `json:"errorClass,omitempty" js:"errorClass"`
+	Proxy        *ProxyInfo    `json:"proxy,omitempty"`
+	Attempts     int           `json:"attempts,omitempty"` // target retries and proxy failovers included
+	Tries        []AttemptInfo `json:"tries,omitempty"`    // every attempt, when there was more than one
+	ProxiesTried []string      `json:"proxiesTried,omitempty" js:"proxiesTried"`
+
+	retryAfter string // Retry-After of the response, for target retries
+}
+
+// ProxyInfo describes the proxy that served the final attempt of a request.
+type ProxyInfo struct {
+	URL      string   `json:"url"`
+	Identity string   `json:"identity,omitempty"` // expanded URL of a template
+	Pool     string   `json:"pool"`
+	Country  string   `json:"country,omitempty"`
+	Provider string   `json:"provider,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	ExitIP   string   `json:"exitIp,omitempty" js:"exitIp"` // set once probeExitIPs found it
 }
 
 // Client implements the k6/x/sockshttp module
 type Client struct {
-	clients        sync.Map     // map[string]*http.Client
-	badProxies     sync.Map     // map[string]time.Time
-	proxyListVal   atomic.Value // holds []string
-	proxyRR        atomic.Uint64
-	proxyListPath  string
-	proxyListMTime time.Time
-	badProxyTTL    time.Duration
-	defaultHTTP    HTTPOptions
-	defaultProxy   ProxyOptions
+	clients       sync.Map  // map[string]*http.Client
+	clientKeys    sync.Map  // map[string]*clientKeySet, keys of clients by proxy URL
+	pool          proxyPool // unnamed pool
+	pools         sync.Map  // map[string]*proxyPool, named pools
+	proxyStats    sync.Map  // map[string]*proxyStats
+	proxyLimiters sync.Map  // map[string]*proxyLimiter
+	health        atomic.Pointer[clientHealth]
+	defaultHTTP   HTTPOptions
+	defaultProxy  ProxyOptions
+	sessions      sessionStore // sticky sessions of Go callers; VUs keep their own
+	cookies       cookieJar    // "vu" cookie jar of Go callers; VUs keep their own
+	sharedCookies cookieJar    // "shared" cookie jar
+	proxyCookies  sync.Map     // map[string]*cookieJar, "proxy" cookie jars by identity
+	loadedStates  sync.Map     // map[string]*stateLoad, state files applied by LoadProxyState
+	exitIPs       sync.Map     // map[string]string, exit IP per proxy URL found by probeExitIPs
+
+	// list watcher: polls loaded list files and counts snapshot swaps for metrics
+	listMu      sync.Mutex // guards the UA and referer path/mtime fields
+	listWatch   listWatcher
+	listReloads listReloads
 
 	// user-agent list cache (atomic/modern fields)
-	uaListVal   atomic.Value // holds []string
-	uaRand      *rand.Rand
-	uaListPath  string
-	uaListMTime time.Time
+	uaListVal    atomic.Value // holds []string
+	uaLoadedPath atomic.Value // holds string, the path of uaListVal for lock-free checks
+	uaRand       *rand.Rand
+	uaListPath   string
+	uaListMTime  time.Time
 
 	// referer
 	refererListVal   atomic.Value
@@ -162,14 +375,28 @@ type Client struct {
 	pathRand *rand.Rand
 }
 
-// New returns a new Client instance
-func New() modules.Instance {
-	return &Client{
-		badProxyTTL:     5 * time.Minute,
+// NewClient returns a new Client with the default list paths and health policy.
+func NewClient() *Client {
+	c := &Client{
 		uaListPath:      "./user_agents.txt",
 		refererListPath: "./referer.txt",
-		proxyListPath:   "./proxies.txt",
 	}
+	c.pool.path.Store("./proxies.txt")
+	c.SetHealthPolicy(HealthPolicy{})
+	return c
+}
+
+// ensureList loads the list of a pooled request the first time it is used; a
+// loaded list is kept current by the list watcher (files) or the background
+// refresh (remote sources). It reports whether the request uses the pool: the
+// list implied by randomPath is optional, and without it requests go direct,
+// as they always did, unless onExhausted says otherwise.
+func (c *Client) ensureList(pl *proxyPool, o ProxyOptions, implied bool) bool {
+	if !c.proxyListLoaded(pl, o.ListPath) {
+		_, _ = c.LoadProxyListWithOptions(o.ListPath, ProxyListOptions{DefaultScheme: o.DefaultScheme})
+	}
+	l := pl.snapshot()
+	return !implied || o.OnExhausted != "" || (l != nil && len(l.entries) > 0)
 }
 
 func (c *Client) parseRequest(raw any) (RequestParams, error) {
@@ -192,8 +419,25 @@ func (c *Client) parseRequest(raw any) (RequestParams, error) {
 		}
 	}
 	if v, ok := m["body"]; ok {
+		switch v.(type) {
+		case map[string]any, []any:
+			r.bodyValue = v
+		default:
+			if b, ok := asBinary(v); ok {
+				r.bodyBytes = b
+			} else if s, ok := asString(v); ok {
+				r.Body = s
+			}
+		}
+	}
+	if v, ok := m["bodyType"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			r.BodyType = s
+		}
+	}
+	if v, ok := m["bodyFile"]; ok && v != nil {
 		if s, ok := asString(v); ok {
-			r.Body = s
+			r.BodyFile = s
 		}
 	}
 	// nested decode (single path)
@@ -219,6 +463,12 @@ func (c *Client) parseRequest(raw any) (RequestParams, error) {
 
 // Request performs an HTTP request via SOCKS or HTTP proxy
 func (c *Client) Request(raw any) (any, error) {
+	return c.request(raw, c.callerContext())
+}
+
+// request performs Request on behalf of vc, which scopes the sticky sessions
+// of templated proxy URLs.
+func (c *Client) request(raw any, vc *vuContext) (any, error) {
 	params, err := c.parseRequest(raw)
 	if err != nil {
 		return nil, err
@@ -230,6 +480,7 @@ func (c *Client) Request(raw any) (any, error) {
 	if params.Proxy.Disable {
 		params.Proxy.URL = ""
 		params.Proxy.ListPath = ""
+		params.Proxy.Pool = ""
 	}
 
 	if params.HTTP.RandomUserAgent && params.HTTP.UserAgentListPath == "" {
@@ -240,26 +491,33 @@ func (c *Client) Request(raw any) (any, error) {
 		params.HTTP.UserAgentListPath = c.refererListPath
 	}
 
+	// randomPath implies the unnamed pool's list, ./proxies.txt by default
+	implicitList := false
 	if (params.HTTP.RandomPath || params.HTTP.RandomPathWithQuery) && params.Proxy.ListPath == "" {
-		params.Proxy.ListPath = c.proxyListPath
+		params.Proxy.ListPath = c.pool.currentPath()
+		implicitList = true
 	}
 
-	if params.Proxy.URL == "" && params.Proxy.ListPath != "" {
-		_ = c.LoadProxyList(params.Proxy.ListPath)
-		params.Proxy.URL = c.GetNextProxy()
+	// an explicit pool is used as it is (filled by loadProxyList or the pool
+	// control API); listPath is ignored for it
+	named := params.Proxy.Pool != ""
+	pl := c.poolFor(params.Proxy.Pool, false)
+	if pl == nil {
+		return Response{Error: fmt.Sprintf("unknown proxy pool %q", params.Proxy.Pool)}, nil
 	}
-	// if proxy unhealthy, bail early
-	if params.Proxy.URL != "" {
-		if t, bad := c.badProxies.Load(params.Proxy.URL); bad {
-			if expireAt, ok := t.(time.Time); ok && time.Now().Before(expireAt) {
-				return Response{Error: fmt.Sprintf("proxy marked as unhealthy: %s", params.Proxy.URL)}, nil
-			}
-			c.badProxies.Delete(params.Proxy.URL)
-		}
+
+	pooled := params.Proxy.URL == "" && (named || params.Proxy.ListPath != "")
+	if pooled && !named {
+		pooled = c.ensureList(pl, params.Proxy, implicitList)
+	} else if params.Proxy.URL != "" && !c.proxyAvailable(pl, params.Proxy.URL) {
+		// pinned proxy is unhealthy, bail early. Proxies picked from a pool are
+		// admitted by the pick itself; admitting them here again would spend a
+		// second half-open trial.
+		return Response{Error: fmt.Sprintf("proxy marked as unhealthy: %s", params.Proxy.URL)}, nil
 	}
 
 	// Load UA list if configured for this request
-	if params.HTTP.RandomUserAgent && params.HTTP.UserAgentListPath != "" {
+	if params.HTTP.RandomUserAgent && params.HTTP.UserAgentListPath != "" && !c.userAgentsLoaded(params.HTTP.UserAgentListPath) {
 		_ = c.LoadUserAgents(params.HTTP.UserAgentListPath)
 	}
 
@@ -268,40 +526,250 @@ func (c *Client) Request(raw any) (any, error) {
 		timeout = d
 	}
 
-	client, err := c.getClientWithOpts(
-		params.Proxy.URL,
+	sessions, err := params.Proxy.sessionPolicy()
+	if err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	exhaustion, err := params.Proxy.exhaustionPolicy()
+	if err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	cookies, err := params.HTTP.cookieMode()
+	if err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	retries, err := params.HTTP.retryPolicy()
+	if err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	protocol, err := params.HTTP.protocol()
+	if err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	if protocol == protocolH2C && !strings.HasPrefix(strings.ToLower(params.URL), "http://") {
+		return Response{Error: "http.protocol h2c needs an http:// URL"}, nil
+	}
+	if _, err := params.HTTP.acceptEncoding(); err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	if _, err := params.HTTP.responseMode(); err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	redirects, err := params.HTTP.redirectPolicy()
+	if err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	if err := params.encodeBody(); err != nil {
+		return Response{Error: err.Error()}, nil
+	}
+	policy := params.Proxy.failoverPolicy()
+	ctx := context.Background()
+	if policy.budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.budget)
+		defer cancel()
+	}
+
+	var (
+		resp  Response
+		tried []string
+		tries []AttemptInfo
+		hop   hopPicker
+	)
+	if pooled && redirects.rotate {
+		hop = func(used []string) (*ProxyEntry, string, func()) {
+			e, release, _ := c.acquireProxy(ctx, pl, params.Proxy, used, 0)
+			switch {
+			case e == nil:
+				return nil, "", release
+			case isProxyTemplate(e.URL):
+				identity, done := vc.expand(c, pl, e, params.Proxy, sessions)
+				return e, identity, func() { done(false); release() }
+			}
+			return e, e.URL, release
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		var entry *ProxyEntry
+		release := func() {}
+		if pooled {
+			var (
+				saturated bool
+				wait      time.Duration
+			)
+			if attempt == 0 && exhaustion.mode == exhaustedWait {
+				wait = exhaustion.wait
+			}
+			entry, release, saturated = c.acquireProxy(ctx, pl, params.Proxy, tried, wait)
+			if entry == nil && attempt > 0 {
+				break // pool exhausted for this request; keep the last failure
+			}
+			if entry == nil && saturated {
+				resp = Response{Error: "no proxy available: all candidates are at their maxInFlight/maxRps limit", ErrorClass: ErrClassPoolSaturated}
+				break
+			}
+			if entry == nil {
+				since := pl.markExhausted(params.Proxy.Filter, time.Now())
+				if exhaustion.mode != exhaustedDirect {
+					resp = exhaustedResponse(pl, params.Proxy.Filter)
+					if exhaustion.mode == exhaustedAbort && since >= exhaustion.abortAfter {
+						return resp, fmt.Errorf("%w: %s for %s", ErrPoolExhausted, resp.Error, since.Round(time.Millisecond))
+					}
+					break
+				}
+			} else {
+				pl.markAvailable(params.Proxy.Filter)
+			}
+		} else if params.Proxy.URL != "" {
+			entry = &ProxyEntry{URL: params.Proxy.URL}
+		}
+		identity, done := "", func(bool) {}
+		if entry != nil {
+			identity = entry.URL
+			if isProxyTemplate(entry.URL) {
+				identity, done = vc.expand(c, pl, entry, params.Proxy, sessions)
+			}
+		}
+		var proxyURL string
+		if entry != nil {
+			proxyURL = entry.URL
+		}
+		jar := c.cookieJarFor(vc, cookies, identity)
+		for retry := 0; ; retry++ {
+			start := time.Now()
+			resp = c.attempt(ctx, pl, params, entry, identity, jar, hop, timeout)
+			tries = append(tries, attemptInfo(&resp, proxyURL, time.Since(start)))
+			if retry >= retries.retries {
+				break
+			}
+			reason := retries.reason(params.Method, &resp)
+			if reason == "" {
+				break
+			}
+			d, ok := retries.wait(ctx, retry, &resp, time.Now())
+			if !ok {
+				break
+			}
+			tries[len(tries)-1].followedBy("retry", reason, d)
+			if !sleepCtx(ctx, d) {
+				break
+			}
+		}
+		release()
+		done(resp.ErrorClass != "" && c.healthFor(pl).counts(resp.ErrorClass))
+		if entry != nil {
+			tried = append(tried, entry.URL)
+		}
+
+		if !pooled || attempt >= policy.maxRetries || !c.shouldFailover(pl, policy, &resp) {
+			break
+		}
+		d := policy.delay(attempt)
+		tries[len(tries)-1].followedBy("failover", failoverReason(&resp), d)
+		if !sleepCtx(ctx, d) {
+			break
+		}
+	}
+	if len(tries) > 0 {
+		resp.Attempts = len(tries)
+	}
+	if len(tries) > 1 {
+		resp.Tries = tries
+	}
+	if len(tried) > 1 {
+		resp.ProxiesTried = tried
+	}
+	return resp, nil
+}
+
+// attempt sends one request through the proxy entry (nil for direct) as
+// identity, the entry's URL or its expansion, and records the outcome against
+// the identity's health in pl. Stats are kept per entry. jar, when not nil,
+// holds the cookies of the request; hop, when not nil, picks the proxy of
+// each redirect hop.
+func (c *Client) attempt(ctx context.Context, pl *proxyPool, params RequestParams, entry *ProxyEntry, identity string, jar *cookieJar, hop hopPicker, timeout time.Duration) Response {
+	var proxyURL string
+	if entry != nil {
+		proxyURL = entry.URL
+	}
+	protocol, _ := params.HTTP.protocol() // validated by request
+	client, err := c.clientFor(
+		identity,
+		proxyURL,
 		timeout,
 		params.HTTP.InsecureSkipVerify,
 		params.HTTP.DisableHTTP2,
 		params.HTTP.FollowRedirects,
 		params.HTTP.SkipDecompress,
+		protocol,
 	)
 	if err != nil {
-		if params.Proxy.URL != "" {
-			c.markBadProxy(params.Proxy.URL)
+		c.recordProxyFailure(pl, identity, ErrClassProxyConfig)
+		c.statsFor(proxyURL).failure(ErrClassProxyConfig, err)
+		return Response{Error: err.Error(), ErrorClass: ErrClassProxyConfig, Proxy: c.proxyInfo(pl, entry, identity)}
+	}
+	// the cached client is shared by every caller: the jar and the redirect
+	// policy of this request go on a copy
+	redirects, _ := params.HTTP.redirectPolicy() // validated by request
+	chain := &redirectChain{proxy: identity}
+	perRequest := *client
+	perRequest.CheckRedirect = redirects.check(chain)
+	if jar != nil {
+		perRequest.Jar = jar
+	}
+	var hops *hopTransport
+	if hop != nil && redirects.follow {
+		hops = &hopTransport{
+			c: c, pl: pl, first: client.Transport, identity: identity, pick: hop, chain: chain,
+			used: []string{proxyURL},
+			newHop: func(identity, statsURL string) (*http.Client, error) {
+				return c.clientFor(identity, statsURL, timeout, params.HTTP.InsecureSkipVerify, params.HTTP.DisableHTTP2, params.HTTP.FollowRedirects, params.HTTP.SkipDecompress, protocol)
+			},
 		}
-		return Response{Error: err.Error()}, nil
+		perRequest.Transport = hops
 	}
+	client = &perRequest
 
 	req, err := c.buildRequest(params)
 	if err != nil {
-		return Response{Error: err.Error()}, nil
+		return Response{Error: err.Error()}
 	}
 
-	resp, err := c.executeRequestWithOpts(client, req, params.Proxy.URL, params.HTTP)
+	resp, err := c.executeRequestWithOpts(client, req.WithContext(ctx), proxyURL, params.HTTP)
+	hopFailed := hops != nil && hops.done()
 	if err != nil {
-		if params.Proxy.URL != "" {
-			c.markBadProxy(params.Proxy.URL)
-		}
-		return Response{Error: err.Error()}, nil
+		return Response{Error: err.Error(), Proxy: c.proxyInfo(pl, entry, identity)}
+	}
+	switch {
+	case resp.ErrorClass == "":
+		c.recordProxySuccess(pl, identity)
+	case !hopFailed:
+		// a failed hop was recorded against the proxy of that hop
+		c.recordProxyFailure(pl, identity, resp.ErrorClass)
+	}
+	resp.Proxy = c.proxyInfo(pl, entry, identity)
+	resp.Redirects = chain.result()
+	return *resp
+}
+
+func (c *Client) proxyInfo(pl *proxyPool, e *ProxyEntry, identity string) *ProxyInfo {
+	if e == nil {
+		return nil
+	}
+	info := &ProxyInfo{URL: e.URL, Pool: pl.label(), Country: e.Country, Provider: e.Provider, Tags: e.Tags, ExitIP: c.exitIP(identity)}
+	if identity != e.URL {
+		info.Identity = identity
 	}
-	return *resp, nil
+	return info
 }
 
 func (c *Client) DefaultConfig() (any, error) {
 	return map[string]any{
-		"http":  c.defaultHTTP,
-		"proxy": c.defaultProxy,
+		"http":   c.defaultHTTP,
+		"proxy":  c.defaultProxy,
+		"health": c.healthPolicy(),
+		// "0s" when disabled
+		"listReloadInterval": c.listReloadInterval().String(),
 	}, nil
 }
 
@@ -315,24 +783,6 @@ func (c *Client) Preview(raw any) (any, error) {
 	return params, nil
 }
 
-func (c *Client) Exports() modules.Exports {
-	return modules.Exports{
-		Named: map[string]any{
-			"request":                c.Request,
-			"loadProxyList":          c.LoadProxyList,
-			"loadUserAgents":         c.LoadUserAgents,
-			"configure":              c.Configure,
-			"defaultConfig":          c.DefaultConfig,
-			"preview":                c.Preview,
-			"getRandomUserAgent":     c.GetRandomUserAgent,
-			"loadReferers":           c.LoadReferers,
-			"getRandomReferer":       c.GetRandomReferer,
-			"getRandomPath":          c.GetRandomPath,
-			"getRandomPathWithQuery": c.GetRandomPathWithQuery,
-		},
-	}
-}
-
 // Configure sets default HTTP and Proxy options
 func (c *Client) Configure(raw any) (any, error) {
 	m, err := asMap(raw)
@@ -347,6 +797,22 @@ func (c *Client) Configure(raw any) (any, error) {
 	if pv, ok := m["proxy"]; ok {
 		if pm, ok := pv.(map[string]any); ok {
 			decodeProxyOptions(pm, &c.defaultProxy)
+			if hv, ok := pm["health"]; ok {
+				if hm, ok := hv.(map[string]any); ok {
+					policy := c.healthPolicy()
+					decodeHealthPolicy(hm, &policy)
+					c.SetHealthPolicy(policy)
+				}
+			}
+		}
+	}
+	if v, ok := m["listReloadInterval"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid listReloadInterval %q", s)
+			}
+			c.SetListReloadInterval(d)
 		}
 	}
 	return map[string]string{"status": "ok"}, nil
@@ -3,6 +3,9 @@ package proxy
 import (
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -98,3 +101,35 @@ func TestClientCache_DifferentTimeouts(t *testing.T) {
 		t.Fatalf("expected different *http.Client instances for different timeouts")
 	}
 }
+
+// Given the option structs scripts pass in
+// When their tags are inspected
+// Then every field named for JS has a js tag equal to its JSON name, except the
+// original HTTP and proxy options, which predate the js tags
+func TestOptions_GivenJSONTags_WhenInspected_ThenJSTagsMatch(t *testing.T) {
+	t.Parallel()
+	untagged := []string{
+		"HTTPOptions.Timeout", "HTTPOptions.InsecureSkipVerify", "HTTPOptions.DisableHTTP2", "HTTPOptions.AutoReferer",
+		"HTTPOptions.RandomReferer", "HTTPOptions.FollowRedirects", "HTTPOptions.AcceptGzip", "HTTPOptions.Headers",
+		"HTTPOptions.RandomUserAgent", "HTTPOptions.UserAgentListPath", "HTTPOptions.DiscardBody", "HTTPOptions.SkipDecompress",
+		"HTTPOptions.RandomPathWithQuery", "HTTPOptions.RandomPath",
+		"ProxyOptions.URL", "ProxyOptions.ListPath", "ProxyOptions.Disable",
+	}
+	for _, v := range []any{HTTPOptions{}, ProxyOptions{}, ProxyFilter{}, ProxyListOptions{}, HealthPolicy{}, ExitIPProbeOptions{}, ExportProxiesOptions{}} {
+		typ := reflect.TypeOf(v)
+		for i := range typ.NumField() {
+			f := typ.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			js, ok := f.Tag.Lookup("js")
+			if !ok && slices.Contains(untagged, typ.Name()+"."+f.Name) {
+				continue
+			}
+			if js != name {
+				t.Errorf("%s.%s: js tag %q, want %q", typ.Name(), f.Name, js, name)
+			}
+		}
+	}
+}
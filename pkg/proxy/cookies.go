@@ -0,0 +1,481 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cookie jar modes of http.cookies. HTTP clients are cached per clientKey and
+// shared by every caller, so the jar is not part of the client: it is chosen
+// per request and attached to a shallow copy of the cached client.
+const (
+	cookiesNone   = "none"   // no jar, Set-Cookie is ignored (default)
+	cookiesVU     = "vu"     // one jar per VU
+	cookiesProxy  = "proxy"  // one jar per proxy identity; direct requests share the "direct" jar
+	cookiesShared = "shared" // one jar for the whole test
+)
+
+var cookieModes = []string{cookiesNone, cookiesVU, cookiesProxy, cookiesShared}
+
+// directCookieJar names the proxy-mode jar of requests sent without a proxy.
+const directCookieJar = "direct"
+
+// cookieMode returns the validated http.cookies mode.
+func (o HTTPOptions) cookieMode() (string, error) {
+	mode := strings.ToLower(o.Cookies)
+	if mode == "" {
+		return cookiesNone, nil
+	}
+	if !slices.Contains(cookieModes, mode) {
+		return "", fmt.Errorf("unsupported http.cookies %q (want one of %s)", o.Cookies, strings.Join(cookieModes, ", "))
+	}
+	return mode, nil
+}
+
+// Cookie is a stored cookie as seen from JS.
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Expires  string `json:"expires,omitempty"`            // RFC 3339; empty for session cookies
+	MaxAge   int    `json:"maxAge,omitempty" js:"maxAge"` // setCookie only: lifetime in seconds
+	Secure   bool   `json:"secure,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty" js:"httpOnly"`
+	HostOnly bool   `json:"hostOnly,omitempty" js:"hostOnly"` // sent to Domain only, not to its subdomains
+}
+
+// CookieScope selects the jar of the cookie functions.
+type CookieScope struct {
+	Jar   string `json:"jar"`   // "vu", "proxy" or "shared"; default http.cookies of configure(), else "vu"
+	Proxy string `json:"proxy"` // proxy identity of a "proxy" jar; "direct" for requests without proxy
+}
+
+// cookieJar is an RFC 6265 cookie store implementing http.CookieJar. Unlike
+// net/http/cookiejar, it can list its content for export.
+type cookieJar struct {
+	mu      sync.Mutex
+	entries map[string]*jarEntry // by domain;path;name
+	seq     uint64
+}
+
+type jarEntry struct {
+	name, value  string
+	domain, path string
+	hostOnly     bool
+	secure       bool
+	httpOnly     bool
+	expires      time.Time // zero for session cookies
+	seq          uint64    // creation order, for the Cookie header order
+}
+
+func (e *jarEntry) key() string { return e.domain + ";" + e.path + ";" + e.name }
+
+func (e *jarEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// matches reports whether e is sent to host/path over a connection that is secure or not.
+func (e *jarEntry) matches(host, path string, secure bool) bool {
+	if e.secure && !secure {
+		return false
+	}
+	if e.hostOnly && host != e.domain || !e.hostOnly && !domainMatch(host, e.domain) {
+		return false
+	}
+	return pathMatch(path, e.path)
+}
+
+func (e *jarEntry) cookie() Cookie {
+	ck := Cookie{Name: e.name, Value: e.value, Domain: e.domain, Path: e.path, Secure: e.secure, HttpOnly: e.httpOnly, HostOnly: e.hostOnly}
+	if !e.expires.IsZero() {
+		ck.Expires = e.expires.UTC().Format(time.RFC3339)
+	}
+	return ck
+}
+
+// jarHost returns the host cookies of u are stored under.
+func jarHost(u *url.URL) string {
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+func isSecureScheme(u *url.URL) bool {
+	return u.Scheme == "https" || u.Scheme == "wss"
+}
+
+func domainMatch(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+func pathMatch(reqPath, cookiePath string) bool {
+	if reqPath == cookiePath {
+		return true
+	}
+	return strings.HasPrefix(reqPath, cookiePath) &&
+		(strings.HasSuffix(cookiePath, "/") || reqPath[len(cookiePath)] == '/')
+}
+
+// defaultCookiePath is the directory of the request path (RFC 6265 5.1.4).
+func defaultCookiePath(p string) string {
+	i := strings.LastIndex(p, "/")
+	if !strings.HasPrefix(p, "/") || i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
+
+// SetCookies implements http.CookieJar.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host := jarHost(u)
+	if host == "" {
+		return
+	}
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		e := &jarEntry{name: c.Name, value: c.Value, path: c.Path, secure: c.Secure, httpOnly: c.HttpOnly}
+		if d := strings.TrimPrefix(strings.ToLower(c.Domain), "."); d == "" || d == host {
+			e.domain, e.hostOnly = host, d == ""
+		} else {
+			// a cookie for a parent domain, never for a public suffix such as co.uk
+			if !domainMatch(host, d) {
+				continue
+			}
+			if ps, _ := publicsuffix.PublicSuffix(d); ps == d {
+				continue
+			}
+			e.domain = d
+		}
+		if !strings.HasPrefix(e.path, "/") {
+			e.path = defaultCookiePath(u.Path)
+		}
+		switch {
+		case c.MaxAge < 0:
+			e.expires = now
+		case c.MaxAge > 0:
+			e.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			e.expires = c.Expires
+		}
+		j.store(e, now)
+	}
+}
+
+// store adds or replaces e, or deletes the cookie when e is already expired. Callers hold j.mu.
+func (j *cookieJar) store(e *jarEntry, now time.Time) {
+	if j.entries == nil {
+		j.entries = map[string]*jarEntry{}
+	}
+	k := e.key()
+	if e.expired(now) {
+		delete(j.entries, k)
+		return
+	}
+	if old, ok := j.entries[k]; ok {
+		e.seq = old.seq
+	} else {
+		j.seq++
+		e.seq = j.seq
+	}
+	j.entries[k] = e
+}
+
+// Cookies implements http.CookieJar.
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	var out []*http.Cookie
+	for _, e := range j.matching(u) {
+		out = append(out, &http.Cookie{Name: e.name, Value: e.value})
+	}
+	return out
+}
+
+// matching returns the live cookies sent to u, longest path first then
+// oldest first; every cookie when u is nil.
+func (j *cookieJar) matching(u *url.URL) []*jarEntry {
+	var host, path string
+	var secure bool
+	if u != nil {
+		host, path, secure = jarHost(u), u.EscapedPath(), isSecureScheme(u)
+		if path == "" {
+			path = "/"
+		}
+	}
+	now := time.Now()
+	j.mu.Lock()
+	var out []*jarEntry
+	for k, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, k)
+			continue
+		}
+		if u == nil || e.matches(host, path, secure) {
+			cp := *e
+			out = append(out, &cp)
+		}
+	}
+	j.mu.Unlock()
+
+	sort.Slice(out, func(a, b int) bool {
+		if len(out[a].path) != len(out[b].path) {
+			return len(out[a].path) > len(out[b].path)
+		}
+		return out[a].seq < out[b].seq
+	})
+	return out
+}
+
+func (j *cookieJar) clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = nil
+}
+
+// writeNetscape writes the jar in the Netscape cookies.txt format used by
+// curl and browser extensions. Session cookies are written with expiry 0.
+func (j *cookieJar) writeNetscape() ([]byte, int) {
+	entries := j.matching(nil)
+	sort.Slice(entries, func(a, b int) bool { return entries[a].key() < entries[b].key() })
+	var buf bytes.Buffer
+	buf.WriteString("# Netscape HTTP Cookie File\n")
+	for _, e := range entries {
+		domain, sub := e.domain, "FALSE"
+		if !e.hostOnly {
+			domain, sub = "."+e.domain, "TRUE"
+		}
+		if e.httpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !e.expires.IsZero() {
+			expires = e.expires.Unix()
+		}
+		secure := "FALSE"
+		if e.secure {
+			secure = "TRUE"
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, sub, e.path, secure, expires, e.name, e.value)
+	}
+	return buf.Bytes(), len(entries)
+}
+
+// readNetscape adds the cookies of a Netscape cookies.txt file, skipping
+// expired ones, and returns how many were added.
+func (j *cookieJar) readNetscape(data []byte) (int, error) {
+	now := time.Now()
+	var entries []*jarEntry
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimRight(s.Text(), "\r")
+		e := &jarEntry{}
+		if rest, ok := strings.CutPrefix(text, "#HttpOnly_"); ok {
+			text, e.httpOnly = rest, true
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		f := strings.Split(text, "\t")
+		if len(f) == 6 {
+			f = append(f, "") // empty value
+		}
+		if len(f) != 7 {
+			return 0, fmt.Errorf("line %d: want 7 tab-separated fields, got %d", line, len(f))
+		}
+		expires, err := strconv.ParseInt(f[4], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: invalid expiry %q", line, f[4])
+		}
+		e.domain = strings.ToLower(f[0])
+		e.hostOnly = !strings.HasPrefix(e.domain, ".") && !strings.EqualFold(f[1], "TRUE")
+		e.domain = strings.TrimPrefix(e.domain, ".")
+		e.path, e.secure, e.name, e.value = f[2], strings.EqualFold(f[3], "TRUE"), f[5], f[6]
+		if e.domain == "" || e.name == "" {
+			return 0, fmt.Errorf("line %d: missing domain or name", line)
+		}
+		if !strings.HasPrefix(e.path, "/") {
+			e.path = "/"
+		}
+		if expires > 0 {
+			if e.expires = time.Unix(expires, 0); e.expired(now) {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range entries {
+		j.store(e, now)
+	}
+	return len(entries), nil
+}
+
+// cookieJarFor returns the jar of a request sent by vc through identity ("" for direct), nil in mode none.
+func (c *Client) cookieJarFor(vc *vuContext, mode, identity string) *cookieJar {
+	switch mode {
+	case cookiesVU:
+		return vc.cookies
+	case cookiesShared:
+		return &c.sharedCookies
+	case cookiesProxy:
+		if identity == "" {
+			identity = directCookieJar
+		}
+		v, _ := c.proxyCookies.LoadOrStore(identity, &cookieJar{})
+		return v.(*cookieJar)
+	}
+	return nil
+}
+
+// scopeMode returns the jar mode of a cookie function.
+func (c *Client) scopeMode(scope CookieScope) (string, error) {
+	mode := strings.ToLower(scope.Jar)
+	if mode == "" {
+		mode = strings.ToLower(c.defaultHTTP.Cookies)
+	}
+	if mode == "" || mode == cookiesNone {
+		return cookiesVU, nil
+	}
+	if !slices.Contains(cookieModes, mode) {
+		return "", fmt.Errorf("unsupported cookie jar %q (want vu, proxy or shared)", scope.Jar)
+	}
+	return mode, nil
+}
+
+// scopeJar returns the jar selected by scope.
+func (c *Client) scopeJar(vc *vuContext, scope CookieScope) (*cookieJar, error) {
+	mode, err := c.scopeMode(scope)
+	if err != nil {
+		return nil, err
+	}
+	if mode == cookiesProxy && scope.Proxy == "" {
+		return nil, errors.New(`the "proxy" cookie jar needs proxy (a proxy URL or "direct")`)
+	}
+	return c.cookieJarFor(vc, mode, scope.Proxy), nil
+}
+
+// callerContext is the vuContext of Go callers, which share one session store and jar.
+func (c *Client) callerContext() *vuContext {
+	return &vuContext{iteration: -1, sessions: &c.sessions, cookies: &c.cookies}
+}
+
+func (c *Client) getCookies(vc *vuContext, rawURL string, scope CookieScope) ([]Cookie, error) {
+	jar, err := c.scopeJar(vc, scope)
+	if err != nil {
+		return nil, err
+	}
+	var u *url.URL
+	if rawURL != "" {
+		if u, err = url.Parse(rawURL); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid cookie URL %q", rawURL)
+		}
+	}
+	out := []Cookie{}
+	for _, e := range jar.matching(u) {
+		out = append(out, e.cookie())
+	}
+	return out, nil
+}
+
+func (c *Client) setCookie(vc *vuContext, rawURL string, ck Cookie, scope CookieScope) error {
+	jar, err := c.scopeJar(vc, scope)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid cookie URL %q", rawURL)
+	}
+	if ck.Name == "" {
+		return errors.New("cookie name is required")
+	}
+	hc := &http.Cookie{Name: ck.Name, Value: ck.Value, Domain: ck.Domain, Path: ck.Path, MaxAge: ck.MaxAge, Secure: ck.Secure, HttpOnly: ck.HttpOnly}
+	if ck.Expires != "" {
+		if hc.Expires, err = time.Parse(time.RFC3339, ck.Expires); err != nil {
+			return fmt.Errorf("invalid cookie expires %q", ck.Expires)
+		}
+	}
+	jar.SetCookies(u, []*http.Cookie{hc})
+	return nil
+}
+
+func (c *Client) clearCookies(vc *vuContext, scope CookieScope) error {
+	mode, err := c.scopeMode(scope)
+	if err != nil {
+		return err
+	}
+	if mode == cookiesProxy && scope.Proxy == "" {
+		c.proxyCookies.Clear()
+		return nil
+	}
+	c.cookieJarFor(vc, mode, scope.Proxy).clear()
+	return nil
+}
+
+func (c *Client) exportCookies(vc *vuContext, path string, scope CookieScope) (int, error) {
+	jar, err := c.scopeJar(vc, scope)
+	if err != nil {
+		return 0, err
+	}
+	data, n := jar.writeNetscape()
+	return n, writeFileAtomic(path, data)
+}
+
+func (c *Client) importCookies(vc *vuContext, path string, scope CookieScope) (int, error) {
+	jar, err := c.scopeJar(vc, scope)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cookies: %w", err)
+	}
+	n, err := jar.readNetscape(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return n, nil
+}
+
+// GetCookies returns the cookies of the selected jar that a request to rawURL
+// would send, or every cookie when rawURL is empty.
+func (c *Client) GetCookies(rawURL string, scope CookieScope) ([]Cookie, error) {
+	return c.getCookies(c.callerContext(), rawURL, scope)
+}
+
+// SetCookie stores ck as if rawURL had sent it in a Set-Cookie header.
+func (c *Client) SetCookie(rawURL string, ck Cookie, scope CookieScope) error {
+	return c.setCookie(c.callerContext(), rawURL, ck, scope)
+}
+
+// ClearCookies empties the selected jar; in "proxy" mode without proxy, every proxy jar.
+func (c *Client) ClearCookies(scope CookieScope) error {
+	return c.clearCookies(c.callerContext(), scope)
+}
+
+// ExportCookies writes the selected jar to path in the Netscape cookies.txt format.
+func (c *Client) ExportCookies(path string, scope CookieScope) (int, error) {
+	return c.exportCookies(c.callerContext(), path, scope)
+}
+
+// ImportCookies adds the cookies of a Netscape cookies.txt file to the selected jar.
+func (c *Client) ImportCookies(path string, scope CookieScope) (int, error) {
+	return c.importCookies(c.callerContext(), path, scope)
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func cookieNames(j *cookieJar, rawURL string) string {
+	u, _ := url.Parse(rawURL)
+	var names []string
+	for _, c := range j.Cookies(u) {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Given cookies set by a host with various attributes
+// When cookies are looked up for other URLs
+// Then domain, path, secure and expiry rules of RFC 6265 apply
+func TestCookieJar_GivenAttributes_WhenLookedUp_ThenRFC6265Rules(t *testing.T) {
+	t.Parallel()
+	j := &cookieJar{}
+	u, _ := url.Parse("https://www.shop.example.com/account/login")
+	j.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "1", Domain: ".shop.example.com", Path: "/"},
+		{Name: "suffix", Value: "1", Domain: "com"},
+		{Name: "foreign", Value: "1", Domain: "other.example"},
+		{Name: "secure", Value: "1", Path: "/", Secure: true},
+		{Name: "gone", Value: "1", Path: "/", MaxAge: -1},
+		{Name: "old", Value: "1", Path: "/", Expires: time.Now().Add(-time.Hour)},
+	})
+
+	if got := cookieNames(j, "https://www.shop.example.com/account/orders"); got != "host,domain,secure" {
+		t.Fatalf("same host: %s", got)
+	}
+	if got := cookieNames(j, "http://api.shop.example.com/"); got != "domain" {
+		t.Fatalf("sibling over http: %s", got)
+	}
+	if got := cookieNames(j, "https://www.shop.example.com/accounts"); got != "domain,secure" {
+		t.Fatalf("path prefix is not a path match: %s", got)
+	}
+
+	j.SetCookies(u, []*http.Cookie{{Name: "host", Value: "1", MaxAge: -1}})
+	if got := cookieNames(j, "https://www.shop.example.com/account/"); got != "domain,secure" {
+		t.Fatalf("after delete: %s", got)
+	}
+}
+
+// cookieTarget sets a cookie on /login, redirects /hop through a cookie-setting
+// redirect, and echoes the Cookie header on every other path.
+func cookieTarget(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: r.URL.Query().Get("v"), Path: "/"})
+		case "/hop":
+			http.SetCookie(w, &http.Cookie{Name: "hop", Value: "1", Path: "/"})
+			http.Redirect(w, r, "/echo", http.StatusFound)
+			return
+		}
+		io.WriteString(w, r.Header.Get("Cookie"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Given http.cookies "none" and "vu"
+// When a VU logs in and then calls a page
+// Then the session cookie is only sent back with a jar, also across redirects
+func TestCookies_GivenVUJar_WhenLoggedIn_ThenCookieSentBack(t *testing.T) {
+	t.Parallel()
+	ts := cookieTarget(t)
+	c := newHealthClient()
+	vc := &vuContext{id: 1, iteration: -1, sessions: &sessionStore{}, cookies: &cookieJar{}}
+	send := func(path, mode string) Response {
+		t.Helper()
+		v, err := c.request(map[string]any{"url": ts.URL + path, "http": map[string]any{"cookies": mode, "followRedirects": true}}, vc)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		return v.(Response)
+	}
+
+	send("/login?v=a", "none")
+	if body := string(send("/echo", "none").Body); body != "" {
+		t.Fatalf("mode none sent %q", body)
+	}
+	send("/login?v=a", "vu")
+	if body := string(send("/echo", "vu").Body); body != "sid=a" {
+		t.Fatalf("mode vu sent %q", body)
+	}
+	if body := string(send("/hop", "vu").Body); body != "sid=a; hop=1" {
+		t.Fatalf("redirect hop sent %q", body)
+	}
+
+	other := &vuContext{id: 2, iteration: -1, sessions: &sessionStore{}, cookies: &cookieJar{}}
+	v, _ := c.request(map[string]any{"url": ts.URL + "/echo", "http": map[string]any{"cookies": "vu"}}, other)
+	if body := string(v.(Response).Body); body != "" {
+		t.Fatalf("another VU saw %q", body)
+	}
+}
+
+// Given http.cookies "proxy" and two proxies
+// When each logs in with its own session
+// Then each proxy keeps its own cookies, readable with getCookies
+func TestCookies_GivenProxyJar_WhenTwoProxies_ThenIsolated(t *testing.T) {
+	t.Parallel()
+	ts := cookieTarget(t)
+	p1 := startFakeSOCKS5(t, nil)
+	p2 := startFakeSOCKS5(t, nil)
+	c := newHealthClient()
+	c.defaultHTTP.Cookies = "proxy"
+
+	for _, p := range []struct{ url, v string }{{p1.URL(), "one"}, {p2.URL(), "two"}} {
+		doRequest(t, c, map[string]any{"url": ts.URL + "/login?v=" + p.v, "proxy": map[string]any{"url": p.url}})
+	}
+	resp := doRequest(t, c, map[string]any{"url": ts.URL + "/echo", "proxy": map[string]any{"url": p2.URL()}})
+	if string(resp.Body) != "sid=two" {
+		t.Fatalf("proxy 2 sent %q", resp.Body)
+	}
+	if resp := doRequest(t, c, map[string]any{"url": ts.URL + "/echo"}); string(resp.Body) != "" {
+		t.Fatalf("direct request sent %q", resp.Body)
+	}
+
+	got, err := c.GetCookies(ts.URL+"/", CookieScope{Proxy: p1.URL()})
+	if err != nil || len(got) != 1 || got[0].Value != "one" || !got[0].HostOnly {
+		t.Fatalf("cookies=%+v err=%v", got, err)
+	}
+	if _, err := c.GetCookies(ts.URL, CookieScope{}); err == nil {
+		t.Fatalf("proxy jar without proxy should fail")
+	}
+	if err := c.ClearCookies(CookieScope{}); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if got, _ := c.GetCookies("", CookieScope{Proxy: p2.URL()}); len(got) != 0 {
+		t.Fatalf("after clear: %+v", got)
+	}
+}
+
+// Given a jar with host-only, domain, secure, HttpOnly and session cookies
+// When it is exported and imported into another jar
+// Then the Netscape file round-trips every attribute
+func TestCookies_GivenJar_WhenNetscapeRoundTrip_ThenAttributesKept(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	c := newHealthClient()
+	scope := CookieScope{Jar: "shared"}
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+	for _, ck := range []Cookie{
+		{Name: "a", Value: "1", Expires: expires, HttpOnly: true},
+		{Name: "b", Value: "x=y", Domain: "example.com", Path: "/app", Secure: true},
+	} {
+		if err := c.SetCookie("https://www.example.com/", ck, scope); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if n, err := c.ExportCookies(path, scope); err != nil || n != 2 {
+		t.Fatalf("export n=%d err=%v", n, err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "#HttpOnly_www.example.com\tFALSE\t/\tFALSE\t") || !strings.Contains(string(data), ".example.com\tTRUE\t/app\tTRUE\t0\tb\tx=y") {
+		t.Fatalf("file:\n%s", data)
+	}
+
+	next := newHealthClient()
+	if n, err := next.ImportCookies(path, CookieScope{Jar: "vu"}); err != nil || n != 2 {
+		t.Fatalf("import n=%d err=%v", n, err)
+	}
+	got, _ := next.GetCookies("", CookieScope{Jar: "vu"})
+	want := []Cookie{
+		{Name: "b", Value: "x=y", Domain: "example.com", Path: "/app", Secure: true},
+		{Name: "a", Value: "1", Domain: "www.example.com", Path: "/", Expires: expires, HttpOnly: true, HostOnly: true},
+	}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got %+v", got)
+	}
+
+	if err := os.WriteFile(path, []byte("example.com\tTRUE\t/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := next.ImportCookies(path, CookieScope{Jar: "vu"}); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+// Given an unknown http.cookies mode
+// When a request is sent
+// Then it fails before anything is sent
+func TestCookies_GivenUnknownMode_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "http": map[string]any{"cookies": "browser"}})
+	if !strings.Contains(resp.Error, "unsupported http.cookies") {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
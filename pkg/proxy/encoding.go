@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings of http.acceptEncoding. With acceptEncoding the request
+// advertises them itself, so net/http leaves the response alone and readBody
+// decodes it; acceptGzip alone still lets the Transport negotiate gzip.
+const (
+	encodingBrotli  = "br"
+	encodingZstd    = "zstd"
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+var contentEncodings = []string{encodingBrotli, encodingZstd, encodingGzip, encodingDeflate}
+
+// acceptEncoding returns the validated Accept-Encoding of http.acceptEncoding,
+// or "" when it is not set.
+func (o HTTPOptions) acceptEncoding() (string, error) {
+	seen := make([]string, 0, len(o.AcceptEncoding))
+	for _, e := range o.AcceptEncoding {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !slices.Contains(contentEncodings, e) {
+			return "", fmt.Errorf("unsupported http.acceptEncoding %q (want %s)", e, strings.Join(contentEncodings, ", "))
+		}
+		if !slices.Contains(seen, e) {
+			seen = append(seen, e)
+		}
+	}
+	return strings.Join(seen, ", "), nil
+}
+
+// decoder returns a reader decoding the body of resp, and the content coding
+// it decodes, or r itself and "" when the body is not to be decoded: the
+// Transport already did, skipDecompress is set, or the coding is not one of
+// contentEncodings. Decoders are created on the first read, and empty bodies
+// of HEAD requests and 204s decode to nothing.
+func decoder(resp *http.Response, r io.Reader, skip bool) (io.ReadCloser, string) {
+	enc := bodyCoding(resp, skip)
+	if enc == "" {
+		return io.NopCloser(r), ""
+	}
+	return &lazyDecoder{r: r, enc: enc}, enc
+}
+
+// bodyCoding returns the content coding readBody decodes the body of resp
+// from, or "" when it leaves the body as it is.
+func bodyCoding(resp *http.Response, skip bool) string {
+	enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if skip || resp.Uncompressed || !slices.Contains(contentEncodings, enc) {
+		return ""
+	}
+	return enc
+}
+
+// lazyDecoder decodes r with the decoder of enc, created on the first read.
+type lazyDecoder struct {
+	r   io.Reader
+	enc string
+	dec io.Reader
+	end func()
+	err error
+}
+
+func (d *lazyDecoder) Read(p []byte) (int, error) {
+	if d.dec == nil && d.err == nil {
+		br := bufio.NewReader(d.r)
+		if _, err := br.Peek(1); err != nil {
+			d.err = err
+		} else {
+			d.dec, d.end, d.err = newDecoder(d.enc, br)
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.dec.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("response %s: %w", d.enc, err)
+	}
+	return n, err
+}
+
+func (d *lazyDecoder) Close() error {
+	if d.end != nil {
+		d.end()
+		d.end = nil
+	}
+	return nil
+}
+
+// zstdDecoders are reused: a zstd decoder allocates its window and tables up
+// front, too much for every response of a load test.
+var zstdDecoders = sync.Pool{New: func() any {
+	d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	return d
+}}
+
+// newDecoder returns the decoder of enc over the non-empty body in r, and what
+// to call when it is done with, if anything.
+func newDecoder(enc string, r *bufio.Reader) (io.Reader, func(), error) {
+	switch enc {
+	case encodingBrotli:
+		return brotli.NewReader(r), nil, nil
+	case encodingZstd:
+		d := zstdDecoders.Get().(*zstd.Decoder)
+		if err := d.Reset(r); err != nil {
+			zstdDecoders.Put(d)
+			return nil, nil, fmt.Errorf("response zstd: %w", err)
+		}
+		return d, func() { d.Reset(nil); zstdDecoders.Put(d) }, nil
+	case encodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("response gzip: %w", err)
+		}
+		return zr, nil, nil
+	}
+	// deflate is zlib-wrapped (RFC 9110), but some servers send raw deflate
+	head, _ := r.Peek(2)
+	if len(head) == 2 && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("response deflate: %w", err)
+		}
+		return zr, nil, nil
+	}
+	return flate.NewReader(r), nil, nil
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var encodedPayload = strings.Repeat("compressible payload ", 512)
+
+// encodingServer answers /<coding> with encodedPayload in that content coding
+// and echoes the Accept-Encoding of the request in X-Accept-Encoding; /raw
+// answers raw deflate and /broken a gzip header over garbage.
+func encodingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		var buf bytes.Buffer
+		var zw io.WriteCloser
+		enc := strings.TrimPrefix(r.URL.Path, "/")
+		switch enc {
+		case "br":
+			zw = brotli.NewWriter(&buf)
+		case "zstd":
+			zw, _ = zstd.NewWriter(&buf)
+		case "gzip":
+			zw = gzip.NewWriter(&buf)
+		case "deflate":
+			zw = zlib.NewWriter(&buf)
+		case "raw":
+			enc = "deflate"
+			zw, _ = flate.NewWriter(&buf, flate.BestSpeed)
+		case "broken":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff, 'n', 'o', 'p', 'e'})
+			return
+		}
+		io.WriteString(zw, encodedPayload)
+		zw.Close()
+		w.Header().Set("Content-Encoding", enc)
+		if r.Method != http.MethodHead {
+			w.Write(buf.Bytes())
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Given a target answering every content coding
+// When requests advertise them with acceptEncoding
+// Then each body is decoded and both sizes are reported
+func TestAcceptEncoding_GivenCodings_WhenRequested_ThenDecoded(t *testing.T) {
+	t.Parallel()
+	ts := encodingServer(t)
+	c := newHealthClient()
+	for _, enc := range []string{"br", "zstd", "gzip", "deflate", "raw"} {
+		resp := doRequest(t, c, map[string]any{"url": ts.URL + "/" + enc, "http": map[string]any{"acceptEncoding": []any{"br", "zstd", "gzip", "deflate"}}})
+		if string(resp.Body) != encodedPayload || resp.Error != "" {
+			t.Fatalf("%s: len=%d err=%s", enc, len(resp.Body), resp.Error)
+		}
+		if resp.ContentEncoding == "" || resp.BodySize != int64(len(encodedPayload)) || resp.EncodedSize == 0 || resp.EncodedSize >= resp.BodySize {
+			t.Fatalf("%s: encoding=%q size=%d encoded=%d", enc, resp.ContentEncoding, resp.BodySize, resp.EncodedSize)
+		}
+	}
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL + "/br", "method": "HEAD", "http": map[string]any{"acceptEncoding": []any{"br"}}})
+	if resp.Status != http.StatusOK || resp.Error != "" || len(resp.Body) != 0 {
+		t.Fatalf("head: status=%d err=%s", resp.Status, resp.Error)
+	}
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/broken", "http": map[string]any{"acceptEncoding": []any{"gzip"}}})
+	if !strings.Contains(resp.Error, "response gzip") {
+		t.Fatalf("broken: err=%q", resp.Error)
+	}
+}
+
+// Given encoded bodies, one of them corrupt, and discardBody
+// When they are requested
+// Then they are drained undecoded: encodedSize is the wire size, bodySize stays 0
+// and nothing is reported as decoded
+func TestAcceptEncoding_GivenDiscardBody_WhenRequested_ThenDrainedUndecoded(t *testing.T) {
+	t.Parallel()
+	ts := encodingServer(t)
+	c := newHealthClient()
+	for _, enc := range []string{"br", "zstd", "broken"} {
+		resp := doRequest(t, c, map[string]any{"url": ts.URL + "/" + enc, "http": map[string]any{"acceptEncoding": []any{"br", "zstd", "gzip"}, "discardBody": true}})
+		if resp.Error != "" || resp.EncodedSize == 0 || resp.EncodedSize >= int64(len(encodedPayload)) {
+			t.Fatalf("%s: encoded=%d err=%s", enc, resp.EncodedSize, resp.Error)
+		}
+		if resp.ContentEncoding != "" || resp.BodySize != 0 {
+			t.Fatalf("%s: encoding=%q size=%d for a body that was not decoded", enc, resp.ContentEncoding, resp.BodySize)
+		}
+	}
+}
+
+// Given acceptEncoding, an explicit Accept-Encoding header, and skipDecompress
+// When brotli responses are requested
+// Then the header advertises the codings, explicit headers are decoded too, and skipDecompress returns the raw bytes
+func TestAcceptEncoding_GivenHeaderOrSkip_WhenBrotli_ThenHandled(t *testing.T) {
+	t.Parallel()
+	ts := encodingServer(t)
+	c := newHealthClient()
+
+	req, err := c.buildRequest(RequestParams{URL: ts.URL, HTTP: HTTPOptions{AcceptEncoding: []string{"br", "ZSTD", "br"}}})
+	if err != nil || req.Header.Get("Accept-Encoding") != "br, zstd" {
+		t.Fatalf("accept-encoding=%q err=%v", req.Header.Get("Accept-Encoding"), err)
+	}
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL + "/br", "headers": map[string]any{"Accept-Encoding": "br"}})
+	if string(resp.Body) != encodedPayload || resp.ContentEncoding != "br" {
+		t.Fatalf("explicit header: len=%d encoding=%q err=%s", len(resp.Body), resp.ContentEncoding, resp.Error)
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/br", "http": map[string]any{"acceptEncoding": []any{"br"}, "skipDecompress": true}})
+	if string(resp.Body) == encodedPayload || resp.ContentEncoding != "" || resp.BodySize != int64(len(resp.Body)) {
+		t.Fatalf("skipDecompress: len=%d encoding=%q", len(resp.Body), resp.ContentEncoding)
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/br", "http": map[string]any{"acceptEncoding": []any{"compress"}}})
+	if !strings.Contains(resp.Error, "unsupported http.acceptEncoding") {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
+
+// Given a VU requesting a zstd body
+// When the request completes
+// Then the decoded and encoded sizes are pushed tagged with the coding
+func TestRequest_GivenEncodedBody_WhenVURequests_ThenDecodeMetricsPushed(t *testing.T) {
+	t.Parallel()
+	ts := encodingServer(t)
+	mi, vu, _ := newTestModule(t)
+	samples := vu.enterVU()
+
+	if _, err := mi.request(map[string]any{"url": ts.URL + "/zstd", "http": map[string]any{"acceptEncoding": "zstd"}}); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	got := drainSamples(samples)
+	decoded, encoded := got["proxy_body_decoded_bytes"], got["proxy_body_encoded_bytes"]
+	if len(decoded) != 1 || decoded[0].Value != float64(len(encodedPayload)) || len(encoded) != 1 || encoded[0].Value >= decoded[0].Value {
+		t.Fatalf("decoded=%v encoded=%v", decoded, encoded)
+	}
+	if enc, _ := decoded[0].Tags.Get("encoding"); enc != "zstd" {
+		t.Fatalf("encoding=%q", enc)
+	}
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ErrorClass categorizes a failed request so that only proxy-attributable
+// failures count against proxy health.
+type ErrorClass string
+
+const (
+	ErrClassProxyDial     ErrorClass = "proxy_dial"     // proxy unreachable, refused or dial timeout
+	ErrClassProxyAuth     ErrorClass = "proxy_auth"     // SOCKS auth failure or 407 from the proxy
+	ErrClassProxyReply    ErrorClass = "proxy_reply"    // SOCKS handshake or reply failure
+	ErrClassProxyConnect  ErrorClass = "proxy_connect"  // CONNECT answered with a non-2xx status
+	ErrClassProxyConfig   ErrorClass = "proxy_config"   // proxy URL cannot be used to build a client
+	ErrClassTargetDial    ErrorClass = "target_dial"    // target unreachable or refused (directly or as reported by the proxy)
+	ErrClassTargetTLS     ErrorClass = "target_tls"     // TLS handshake with the target failed
+	ErrClassTargetTimeout ErrorClass = "target_timeout" // target did not answer in time
+	ErrClassTargetReset   ErrorClass = "target_reset"   // connection reset or closed mid-exchange
+	ErrClassUnknown       ErrorClass = "unknown"
+	ErrClassPoolSaturated ErrorClass = "pool_saturated" // every candidate proxy is at its maxInFlight/maxRps limit
+	ErrClassPoolExhausted ErrorClass = "pool_exhausted" // no proxy of the pool is available (empty, ejected or filtered out)
+)
+
+// proxyAttributable reports the default health policy for a class: only
+// failures of the proxy itself count against it.
+func (ec ErrorClass) proxyAttributable() bool {
+	return strings.HasPrefix(string(ec), "proxy_")
+}
+
+// proxyError tags an error with a class at the point where its origin is still known.
+type proxyError struct {
+	class ErrorClass
+	err   error
+}
+
+func (e *proxyError) Error() string { return e.err.Error() }
+func (e *proxyError) Unwrap() error { return e.err }
+
+// proxyDialer dials the proxy itself and tags failures as ErrClassProxyDial,
+// so they can be told apart from target failures reported through the tunnel.
+// With stats set, traffic over the proxy connection is counted.
+type proxyDialer struct {
+	net.Dialer
+	stats *proxyStats
+}
+
+func newProxyDialer(stats *proxyStats) *proxyDialer {
+	return &proxyDialer{Dialer: net.Dialer{Timeout: 10 * time.Second}, stats: stats}
+}
+
+func (d *proxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, &proxyError{class: ErrClassProxyDial, err: err}
+	}
+	if d.stats != nil {
+		return &countingConn{Conn: conn, stats: d.stats}, nil
+	}
+	return conn, nil
+}
+
+// onProxyConnectResponse classifies a non-2xx answer to CONNECT from an HTTP proxy.
+func onProxyConnectResponse(_ context.Context, _ *url.URL, _ *http.Request, res *http.Response) error {
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+	class := ErrClassProxyConnect
+	if res.StatusCode == http.StatusProxyAuthRequired {
+		class = ErrClassProxyAuth
+	}
+	return &proxyError{class: class, err: fmt.Errorf("proxy CONNECT: %s", res.Status)}
+}
+
+// socksTargetReplies are SOCKS reply codes describing the target, not the proxy.
+var socksTargetReplies = []string{"network unreachable", "host unreachable", "connection refused", "TTL expired"}
+
+// classifyError maps a request error to an ErrorClass.
+func classifyError(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	var pe *proxyError
+	if errors.As(err, &pe) {
+		return pe.class
+	}
+
+	var op *net.OpError
+	if errors.As(err, &op) {
+		switch {
+		case strings.HasPrefix(op.Op, "socks"):
+			// Errors from the SOCKS handshake. Dial failures to the proxy were tagged above.
+			msg := op.Err.Error()
+			if strings.Contains(msg, "authentication") || strings.Contains(msg, "username/password") {
+				return ErrClassProxyAuth
+			}
+			for _, r := range socksTargetReplies {
+				if strings.HasSuffix(msg, r) {
+					return ErrClassTargetDial
+				}
+			}
+			return ErrClassProxyReply
+		case op.Op == "proxyconnect":
+			return ErrClassProxyDial
+		}
+	}
+
+	if isTLSError(err) {
+		return ErrClassTargetTLS
+	}
+	// HTTP/3: TLS alerts arrive as QUIC transport errors
+	var qe *quic.TransportError
+	if errors.As(err, &qe) && qe.ErrorCode.IsCryptoError() {
+		return ErrClassTargetTLS
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ETIMEDOUT) {
+		return ErrClassTargetTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrClassTargetTimeout
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrClassTargetReset
+	}
+
+	var dnsErr *net.DNSError
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) || errors.As(err, &dnsErr) {
+		return ErrClassTargetDial
+	}
+
+	return ErrClassUnknown
+}
+
+func isTLSError(err error) bool {
+	var (
+		certErr     *tls.CertificateVerificationError
+		recordErr   tls.RecordHeaderError
+		alertErr    tls.AlertError
+		authErr     x509.UnknownAuthorityError
+		hostErr     x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+		echRejected *tls.ECHRejectionError
+	)
+	switch {
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &authErr), errors.As(err, &hostErr), errors.As(err, &invalidErr),
+		errors.As(err, &echRejected):
+		return true
+	}
+	return strings.Contains(err.Error(), "tls: ")
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// doRequest calls Request and unwraps the Response value.
+func doRequest(t *testing.T, c *Client, params map[string]any) Response {
+	t.Helper()
+	v, err := c.Request(params)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	resp, ok := v.(Response)
+	if !ok {
+		t.Fatalf("unexpected response type: %T", v)
+	}
+	return resp
+}
+
+func newHealthClient() *Client {
+	return healthClient(HealthPolicy{BaseTTL: "1m"})
+}
+
+func healthClient(p HealthPolicy) *Client {
+	c := &Client{}
+	c.SetHealthPolicy(p)
+	return c
+}
+
+// Given a SOCKS proxy address nobody listens on
+// When a request is sent through it
+// Then the error is proxy_dial and the proxy is ejected
+func TestClassify_GivenProxyRefused_WhenRequest_ThenProxyDialAndEjected(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	proxyURL := "socks5://" + closedAddr(t)
+
+	resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "proxy": map[string]any{"url": proxyURL}})
+	if resp.ErrorClass != ErrClassProxyDial {
+		t.Fatalf("class=%q want %q (err=%s)", resp.ErrorClass, ErrClassProxyDial, resp.Error)
+	}
+	if c.proxyAvailable(&c.pool, proxyURL) {
+		t.Fatalf("proxy should be ejected after a proxy_dial failure")
+	}
+}
+
+// Given a SOCKS proxy requiring credentials
+// When the wrong password is used
+// Then the error is proxy_auth
+func TestClassify_GivenSOCKSBadAuth_WhenRequest_ThenProxyAuth(t *testing.T) {
+	t.Parallel()
+	s := startFakeSOCKS5(t, func(s *fakeSOCKS5) { s.User, s.Pass = "u", "secret" })
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{
+		"url":   "http://example.invalid/",
+		"proxy": map[string]any{"url": "socks5://u:wrong@" + s.Addr()},
+	})
+	if resp.ErrorClass != ErrClassProxyAuth {
+		t.Fatalf("class=%q want %q (err=%s)", resp.ErrorClass, ErrClassProxyAuth, resp.Error)
+	}
+}
+
+// Given a SOCKS proxy replying with a failure code
+// When the reply is about the proxy (general failure) or the target (connection refused)
+// Then the former is proxy_reply and ejects, the latter is target_dial and does not
+func TestClassify_GivenSOCKSReplies_WhenRequest_ThenProxyOrTarget(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		reply  byte
+		class  ErrorClass
+		ejects bool
+	}{
+		{reply: 1, class: ErrClassProxyReply, ejects: true},
+		{reply: 5, class: ErrClassTargetDial, ejects: false},
+	}
+	for _, tc := range cases {
+		s := startFakeSOCKS5(t, func(s *fakeSOCKS5) { s.Reply = tc.reply })
+		c := newHealthClient()
+		resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "proxy": map[string]any{"url": s.URL()}})
+		if resp.ErrorClass != tc.class {
+			t.Fatalf("reply %d: class=%q want %q (err=%s)", tc.reply, resp.ErrorClass, tc.class, resp.Error)
+		}
+		if ejected := !c.proxyAvailable(&c.pool, s.URL()); ejected != tc.ejects {
+			t.Fatalf("reply %d: ejected=%v want %v", tc.reply, ejected, tc.ejects)
+		}
+	}
+}
+
+// Given a slow origin behind a healthy SOCKS proxy
+// When the request times out
+// Then the error is target_timeout and the proxy stays in rotation
+func TestClassify_GivenSlowTarget_WhenTimeout_ThenTargetTimeoutNotEjected(t *testing.T) {
+	t.Parallel()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	s := startFakeSOCKS5(t, nil)
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{
+		"url":   slow.URL,
+		"http":  map[string]any{"timeout": "100ms"},
+		"proxy": map[string]any{"url": s.URL()},
+	})
+	if resp.ErrorClass != ErrClassTargetTimeout {
+		t.Fatalf("class=%q want %q (err=%s)", resp.ErrorClass, ErrClassTargetTimeout, resp.Error)
+	}
+	if !c.proxyAvailable(&c.pool, s.URL()) {
+		t.Fatalf("target timeout must not eject the proxy")
+	}
+}
+
+// Given failureClasses {target_timeout: true}
+// When a target timeout happens
+// Then it counts against the proxy
+func TestClassify_GivenClassOverride_WhenTargetTimeout_ThenEjected(t *testing.T) {
+	t.Parallel()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	s := startFakeSOCKS5(t, nil)
+	c := healthClient(HealthPolicy{BaseTTL: "1m", FailureClasses: map[string]bool{"target_timeout": true}})
+
+	doRequest(t, c, map[string]any{
+		"url":   slow.URL,
+		"http":  map[string]any{"timeout": "100ms"},
+		"proxy": map[string]any{"url": s.URL()},
+	})
+	if c.proxyAvailable(&c.pool, s.URL()) {
+		t.Fatalf("override should make target_timeout count against the proxy")
+	}
+}
+
+// Given an HTTP proxy answering CONNECT with 407
+// When an https target is requested through it
+// Then the error is proxy_auth
+func TestClassify_GivenConnect407_WhenHTTPSThroughProxy_ThenProxyAuth(t *testing.T) {
+	t.Parallel()
+	px := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer px.Close()
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{"url": "https://example.invalid/", "proxy": map[string]any{"url": px.URL}})
+	if resp.ErrorClass != ErrClassProxyAuth {
+		t.Fatalf("class=%q want %q (err=%s)", resp.ErrorClass, ErrClassProxyAuth, resp.Error)
+	}
+	if c.proxyAvailable(&c.pool, px.URL) {
+		t.Fatalf("407 should eject the proxy")
+	}
+}
+
+// Given an HTTP proxy answering CONNECT with 502
+// When an https target is requested through it
+// Then the error is proxy_connect
+func TestClassify_GivenConnect502_WhenHTTPSThroughProxy_ThenProxyConnect(t *testing.T) {
+	t.Parallel()
+	px := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer px.Close()
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{"url": "https://example.invalid/", "proxy": map[string]any{"url": px.URL}})
+	if resp.ErrorClass != ErrClassProxyConnect {
+		t.Fatalf("class=%q want %q (err=%s)", resp.ErrorClass, ErrClassProxyConnect, resp.Error)
+	}
+}
+
+// Given a TLS target with an untrusted certificate
+// When requested through a SOCKS proxy without insecureSkipVerify
+// Then the error is target_tls and the proxy stays in rotation
+func TestClassify_GivenUntrustedCert_WhenRequest_ThenTargetTLS(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	s := startFakeSOCKS5(t, nil)
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"url": s.URL()}})
+	if resp.ErrorClass != ErrClassTargetTLS {
+		t.Fatalf("class=%q want %q (err=%s)", resp.ErrorClass, ErrClassTargetTLS, resp.Error)
+	}
+	if !c.proxyAvailable(&c.pool, s.URL()) {
+		t.Fatalf("target TLS failure must not eject the proxy")
+	}
+}
+
+// Given wrapped low-level errors
+// When classifyError is called
+// Then resets, refusals and timeouts map to target classes
+func TestClassifyError_GivenWrappedErrors_WhenClassify_ThenTargetClasses(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, ErrClassTargetReset},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, ErrClassTargetDial},
+		{fmt.Errorf("wrapped: %w", &proxyError{class: ErrClassProxyDial, err: syscall.ECONNREFUSED}), ErrClassProxyDial},
+		{fmt.Errorf("something else"), ErrClassUnknown},
+	}
+	for _, tc := range cases {
+		if got := classifyError(tc.err); got != tc.want {
+			t.Fatalf("classifyError(%v)=%q want %q", tc.err, got, tc.want)
+		}
+	}
+}
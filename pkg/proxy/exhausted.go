@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// What a pooled request does when no proxy of its pool is available (empty,
+// all ejected or filtered out). Going direct must be asked for explicitly: it
+// would send the request from the load generator's own address.
+const (
+	exhaustedError  = "error"  // fail with pool_exhausted (default)
+	exhaustedWait   = "wait"   // wait up to exhaustedWait for a proxy to recover, then fail
+	exhaustedDirect = "direct" // send the request without a proxy
+	exhaustedAbort  = "abort"  // fail, and abort the test once the pool stays exhausted for abortAfter
+)
+
+var exhaustedPolicies = []string{exhaustedError, exhaustedWait, exhaustedDirect, exhaustedAbort}
+
+const (
+	defaultExhaustedWait  = 30 * time.Second
+	exhaustedPollInterval = 50 * time.Millisecond
+)
+
+// ErrPoolExhausted is returned by Request, together with the response, when
+// onExhausted is "abort" and the pool stayed exhausted for abortAfter. In k6
+// the module turns it into a test abort.
+var ErrPoolExhausted = errors.New("proxy pool exhausted")
+
+// exhaustionPolicy is the parsed onExhausted policy of a request.
+type exhaustionPolicy struct {
+	mode       string
+	wait       time.Duration
+	abortAfter time.Duration
+}
+
+func (o ProxyOptions) exhaustionPolicy() (exhaustionPolicy, error) {
+	p := exhaustionPolicy{mode: strings.ToLower(o.OnExhausted), wait: defaultExhaustedWait}
+	if p.mode == "" {
+		p.mode = exhaustedError
+	}
+	if !slices.Contains(exhaustedPolicies, p.mode) {
+		return p, fmt.Errorf("unsupported onExhausted %q (want one of %s)", o.OnExhausted, strings.Join(exhaustedPolicies, ", "))
+	}
+	if o.ExhaustedWait != "" {
+		d, err := time.ParseDuration(o.ExhaustedWait)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid exhaustedWait %q", o.ExhaustedWait)
+		}
+		p.wait = d
+	}
+	if o.AbortAfter != "" {
+		d, err := time.ParseDuration(o.AbortAfter)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid abortAfter %q", o.AbortAfter)
+		}
+		p.abortAfter = d
+	}
+	return p, nil
+}
+
+// markExhausted records that a pick from pl with filter found no proxy and
+// returns since when picks with that filter have been failing. Each filter is
+// tracked apart: a pool can run out of German proxies while others remain.
+func (pl *proxyPool) markExhausted(filter *ProxyFilter, now time.Time) time.Duration {
+	key := filterKey(filter)
+	v, ok := pl.exhausted.Load(key)
+	if !ok {
+		v, _ = pl.exhausted.LoadOrStore(key, new(atomic.Int64))
+	}
+	since := v.(*atomic.Int64)
+	since.CompareAndSwap(0, now.UnixNano())
+	return now.Sub(time.Unix(0, since.Load()))
+}
+
+// markAvailable records a successful pick from pl with filter.
+func (pl *proxyPool) markAvailable(filter *ProxyFilter) {
+	if v, ok := pl.exhausted.Load(filterKey(filter)); ok && v.(*atomic.Int64).Load() != 0 {
+		v.(*atomic.Int64).Store(0)
+	}
+}
+
+func filterKey(f *ProxyFilter) string {
+	if f.empty() {
+		return ""
+	}
+	return f.key()
+}
+
+// exhaustedResponse describes why pl had no proxy for the request.
+func exhaustedResponse(pl *proxyPool, filter *ProxyFilter) Response {
+	reason := "every proxy is ejected"
+	if l := pl.snapshot(); l == nil || len(l.entries) == 0 {
+		reason = "the pool is empty"
+	} else if len(l.view(filter).entries) == 0 {
+		reason = "no proxy matches the filter"
+	}
+	return Response{
+		Error:      fmt.Sprintf("no proxy available in pool %q: %s", pl.label(), reason),
+		ErrorClass: ErrClassPoolExhausted,
+	}
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/errext"
+)
+
+// countingTarget is a target server counting the requests it received.
+func countingTarget(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		io.WriteString(w, "OK")
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &hits
+}
+
+// Given a pool whose only proxy is ejected
+// When a request is sent with the default onExhausted
+// Then it fails with pool_exhausted and nothing reaches the target
+func TestExhausted_GivenAllEjected_WhenDefault_ThenErrorWithoutDirect(t *testing.T) {
+	t.Parallel()
+	ts, hits := countingTarget(t)
+	c := newHealthClient()
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: "socks5://p1:1080"}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	c.markBadProxy(&c.pool, "socks5://p1:1080")
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"pool": "default"}})
+	if resp.ErrorClass != ErrClassPoolExhausted || !strings.Contains(resp.Error, "every proxy is ejected") {
+		t.Fatalf("class=%q err=%q", resp.ErrorClass, resp.Error)
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("request leaked to the target directly")
+	}
+}
+
+// Given a list path that cannot be loaded
+// When a request is sent
+// Then it fails because the pool is empty
+func TestExhausted_GivenMissingList_WhenRequest_ThenPoolEmptyError(t *testing.T) {
+	t.Parallel()
+	ts, hits := countingTarget(t)
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"listPath": t.TempDir() + "/missing.txt"}})
+	if resp.ErrorClass != ErrClassPoolExhausted || !strings.Contains(resp.Error, "the pool is empty") || hits.Load() != 0 {
+		t.Fatalf("class=%q err=%q hits=%d", resp.ErrorClass, resp.Error, hits.Load())
+	}
+}
+
+// Given the list implied by randomPath and a missing file
+// When the list of a request is ensured, with and without onExhausted
+// Then the implied list is optional and the request goes direct unless
+// onExhausted is set, while an explicit listPath always uses the pool
+func TestExhausted_GivenImpliedListMissing_WhenEnsured_ThenDirectUnlessPolicySet(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	missing := ProxyOptions{ListPath: t.TempDir() + "/proxies.txt"}
+
+	if c.ensureList(&c.pool, missing, true) {
+		t.Fatalf("implied missing list should send the request direct")
+	}
+	if !c.ensureList(&c.pool, missing, false) {
+		t.Fatalf("explicit listPath should use the pool")
+	}
+	withPolicy := missing
+	withPolicy.OnExhausted = exhaustedError
+	if !c.ensureList(&c.pool, withPolicy, true) {
+		t.Fatalf("onExhausted should apply to the implied list")
+	}
+
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080"})
+	if !c.ensureList(&c.pool, ProxyOptions{ListPath: path}, true) {
+		t.Fatalf("implied list that loads should use the pool")
+	}
+}
+
+// Given a pool exhausted for one filter
+// When picks with another filter succeed meanwhile
+// Then the exhaustion of the first filter keeps counting
+func TestExhausted_GivenFilters_WhenOtherFilterPicks_ThenTrackedApart(t *testing.T) {
+	t.Parallel()
+	var pl proxyPool
+	fr, de := &ProxyFilter{Country: "fr"}, &ProxyFilter{Country: "de"}
+	t0 := time.Now()
+
+	pl.markExhausted(fr, t0)
+	pl.markAvailable(de)
+	pl.markAvailable(nil)
+	if since := pl.markExhausted(fr, t0.Add(time.Second)); since != time.Second {
+		t.Fatalf("fr exhausted for %v, want 1s", since)
+	}
+	if since := pl.markExhausted(de, t0.Add(time.Second)); since != 0 {
+		t.Fatalf("de exhausted for %v, want 0", since)
+	}
+	pl.markAvailable(fr)
+	if since := pl.markExhausted(fr, t0.Add(2*time.Second)); since != 0 {
+		t.Fatalf("fr exhausted for %v after a pick, want 0", since)
+	}
+}
+
+// Given an exhausted pool and onExhausted "direct"
+// When a request is sent
+// Then it goes to the target without a proxy
+func TestExhausted_GivenDirect_WhenExhausted_ThenSentDirectly(t *testing.T) {
+	t.Parallel()
+	ts, hits := countingTarget(t)
+	c := newHealthClient()
+	if _, err := c.SetProxies("", nil); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"pool": "default", "onExhausted": "direct"}})
+	if resp.Status != http.StatusOK || resp.Proxy != nil || hits.Load() != 1 {
+		t.Fatalf("status=%d proxy=%+v hits=%d err=%s", resp.Status, resp.Proxy, hits.Load(), resp.Error)
+	}
+}
+
+// Given a proxy ejected for a short time and onExhausted "wait"
+// When a request is sent
+// Then it waits for the proxy to recover, or fails once exhaustedWait runs out
+func TestExhausted_GivenWait_WhenProxyRecovers_ThenSentThroughIt(t *testing.T) {
+	t.Parallel()
+	ts, _ := countingTarget(t)
+	s := startFakeSOCKS5(t, nil)
+	c := newHealthClient()
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: s.URL()}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	if err := c.MarkProxyBad("", s.URL(), 150*time.Millisecond); err != nil {
+		t.Fatalf("mark: %v", err)
+	}
+	start := time.Now()
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"pool": "default", "onExhausted": "wait", "exhaustedWait": "5s"}})
+	if resp.Status != http.StatusOK || resp.Proxy == nil || time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("status=%d err=%s after %v", resp.Status, resp.Error, time.Since(start))
+	}
+
+	if err := c.MarkProxyBad("", s.URL(), time.Hour); err != nil {
+		t.Fatalf("mark: %v", err)
+	}
+	start = time.Now()
+	resp = doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"pool": "default", "onExhausted": "wait", "exhaustedWait": "100ms"}})
+	if resp.ErrorClass != ErrClassPoolExhausted || time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("class=%q after %v", resp.ErrorClass, time.Since(start))
+	}
+}
+
+// Given onExhausted "abort" with abortAfter
+// When the pool is exhausted for a shorter and then a longer time
+// Then requests fail first and ErrPoolExhausted is returned once abortAfter passed
+func TestExhausted_GivenAbort_WhenExhaustedLongEnough_ThenErrPoolExhausted(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	if _, err := c.SetProxies("", nil); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	params := map[string]any{"url": "http://example.invalid/", "proxy": map[string]any{"pool": "default", "onExhausted": "abort", "abortAfter": "50ms"}}
+
+	if _, err := c.Request(params); err != nil {
+		t.Fatalf("first exhaustion should not abort yet: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	v, err := c.Request(params)
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("err=%v", err)
+	}
+	if resp, ok := v.(Response); !ok || resp.ErrorClass != ErrClassPoolExhausted {
+		t.Fatalf("resp=%+v", v)
+	}
+}
+
+// Given a VU with a JS runtime and onExhausted "abort"
+// When the pool is exhausted
+// Then the runtime is interrupted with a test abort
+func TestExhausted_GivenAbortInVU_WhenExhausted_ThenTestAborted(t *testing.T) {
+	t.Parallel()
+	mi, vu, _ := newTestModule(t)
+	vu.rt = sobek.New()
+	if _, err := mi.setProxies(nil, nil); err != nil {
+		t.Fatalf("setProxies: %v", err)
+	}
+	if _, err := mi.request(map[string]any{"url": "http://example.invalid/", "proxy": map[string]any{"pool": "default", "onExhausted": "abort"}}); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	_, err := vu.rt.RunString("1")
+	var interrupted *sobek.InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("runtime not interrupted: %v", err)
+	}
+	if ie, ok := interrupted.Value().(*errext.InterruptError); !ok || !strings.HasPrefix(ie.Reason, errext.AbortTest) {
+		t.Fatalf("interrupt value=%v", interrupted.Value())
+	}
+}
+
+// Given an unknown onExhausted
+// When a request is sent
+// Then it fails before anything is sent
+func TestExhausted_GivenUnknownPolicy_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "proxy": map[string]any{"onExhausted": "panic"}})
+	if !strings.Contains(resp.Error, "unsupported onExhausted") {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
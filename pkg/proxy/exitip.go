@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exit IP discovery. Vendors sell "1000 proxies" that leave through a few
+// dozen addresses; probing an IP-echo endpoint through every proxy shows the
+// address the target actually sees, and proxies sharing it are flagged.
+
+const (
+	defaultExitProbeTimeout     = 10 * time.Second
+	defaultExitProbeConcurrency = 16
+	maxEchoBodyBytes            = 64 << 10
+)
+
+// ExitIPProbeOptions configures probeExitIPs and the probeExitIPs option of loadProxyList.
+type ExitIPProbeOptions struct {
+	EchoURL            string `json:"echoURL" js:"echoURL"`         // endpoint answering with the caller's IP, as text or JSON ({"ip"} or {"origin"})
+	Pool               string `json:"pool" js:"pool"`               // pool to probe; ignored at load time
+	Timeout            string `json:"timeout" js:"timeout"`         // per proxy, default "10s"
+	Concurrency        int    `json:"concurrency" js:"concurrency"` // proxies probed at once, default 16
+	InsecureSkipVerify bool   `json:"insecureSkipVerify" js:"insecureSkipVerify"`
+}
+
+// ExitIPReport is the outcome of a probe.
+type ExitIPReport struct {
+	Probed     int            `json:"probed"`
+	Failed     int            `json:"failed"`
+	Skipped    int            `json:"skipped"`              // templated proxies: every session may exit elsewhere
+	ExitIPs    int            `json:"exitIps" js:"exitIps"` // distinct exit IPs found
+	Duplicates []ExitIPGroup  `json:"duplicates"`           // exit IPs shared by several proxies
+	Results    []ExitIPResult `json:"results"`              // one per proxy, in list order
+}
+
+// ExitIPGroup lists the proxies leaving through the same address.
+type ExitIPGroup struct {
+	IP      string   `json:"ip"`
+	Proxies []string `json:"proxies"`
+}
+
+// ExitIPResult is the exit IP of one proxy, or why it could not be found.
+type ExitIPResult struct {
+	URL    string `json:"url"`
+	ExitIP string `json:"exitIp,omitempty" js:"exitIp"`
+	Error  string `json:"error,omitempty"`
+}
+
+// exitProbe caches the load-time probe of a list snapshot, so that every VU
+// loading the same list from the init context does not probe it again.
+type exitProbe struct {
+	list   *proxyList
+	report ExitIPReport
+}
+
+// exitIP returns the last probed exit IP of proxy p, "" when unknown.
+func (c *Client) exitIP(p string) string {
+	if v, ok := c.exitIPs.Load(p); ok {
+		return v.(string)
+	}
+	return ""
+}
+
+// exitIPCounts returns how many probed proxies leave through each exit IP.
+func (c *Client) exitIPCounts() map[string]int {
+	counts := map[string]int{}
+	c.exitIPs.Range(func(_, v any) bool {
+		counts[v.(string)]++
+		return true
+	})
+	return counts
+}
+
+// ProbeExitIPs requests opts.EchoURL through every proxy of the pool and
+// records the exit IPs, which then show in listProxies (meta.exitIp), in
+// getProxyStats and in Response.proxy. Templated proxies are skipped. Probe
+// failures are reported but do not affect proxy health.
+func (c *Client) ProbeExitIPs(opts ExitIPProbeOptions) (ExitIPReport, error) {
+	pl, err := c.existingPool(opts.Pool)
+	if err != nil {
+		return ExitIPReport{}, err
+	}
+	var entries []*ProxyEntry
+	if l := pl.snapshot(); l != nil {
+		entries = l.entries
+	}
+	return c.probeExitIPs(entries, opts)
+}
+
+// probeListExitIPs runs the load-time probe of pl's current list once.
+func (c *Client) probeListExitIPs(pl *proxyPool, opts ExitIPProbeOptions) (ExitIPReport, error) {
+	if _, _, err := opts.resolve(); err != nil {
+		return ExitIPReport{}, err
+	}
+	pl.probeMu.Lock()
+	defer pl.probeMu.Unlock()
+	l := pl.snapshot()
+	if p := pl.exitProbe; p != nil && p.list == l {
+		return p.report, nil
+	}
+	var entries []*ProxyEntry
+	if l != nil {
+		entries = l.entries
+	}
+	report, err := c.probeExitIPs(entries, opts)
+	if err != nil {
+		return report, err
+	}
+	pl.exitProbe = &exitProbe{list: l, report: report}
+	return report, nil
+}
+
+// resolve validates the options and fills in the defaults.
+func (o ExitIPProbeOptions) resolve() (timeout time.Duration, workers int, err error) {
+	if o.EchoURL == "" {
+		return 0, 0, errors.New("probeExitIPs needs echoURL")
+	}
+	timeout = defaultExitProbeTimeout
+	if o.Timeout != "" {
+		d, err := time.ParseDuration(o.Timeout)
+		if err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("invalid timeout %q", o.Timeout)
+		}
+		timeout = d
+	}
+	workers = o.Concurrency
+	if workers <= 0 {
+		workers = defaultExitProbeConcurrency
+	}
+	return timeout, workers, nil
+}
+
+func (c *Client) probeExitIPs(entries []*ProxyEntry, opts ExitIPProbeOptions) (ExitIPReport, error) {
+	timeout, workers, err := opts.resolve()
+	if err != nil {
+		return ExitIPReport{}, err
+	}
+
+	report := ExitIPReport{Duplicates: []ExitIPGroup{}, Results: make([]ExitIPResult, len(entries))}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		report.Results[i].URL = e.URL
+		if isProxyTemplate(e.URL) {
+			report.Results[i].Error = "skipped: templated proxy"
+			report.Skipped++
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *ExitIPResult) {
+			defer func() { <-sem; wg.Done() }()
+			ip, err := c.probeExitIP(r.URL, opts.EchoURL, timeout, opts.InsecureSkipVerify)
+			if err != nil {
+				r.Error = err.Error()
+				c.exitIPs.Delete(r.URL)
+				return
+			}
+			r.ExitIP = ip
+			c.exitIPs.Store(r.URL, ip)
+		}(&report.Results[i])
+	}
+	wg.Wait()
+
+	byIP := map[string][]string{}
+	for _, r := range report.Results {
+		switch {
+		case r.ExitIP != "":
+			report.Probed++
+			byIP[r.ExitIP] = append(byIP[r.ExitIP], r.URL)
+		case !isProxyTemplate(r.URL):
+			report.Failed++
+		}
+	}
+	report.ExitIPs = len(byIP)
+	for ip, urls := range byIP {
+		if len(urls) > 1 {
+			report.Duplicates = append(report.Duplicates, ExitIPGroup{IP: ip, Proxies: urls})
+		}
+	}
+	// most shared first
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		a, b := report.Duplicates[i], report.Duplicates[j]
+		if len(a.Proxies) != len(b.Proxies) {
+			return len(a.Proxies) > len(b.Proxies)
+		}
+		return a.IP < b.IP
+	})
+	return report, nil
+}
+
+// probeExitIP asks echoURL for the caller's address through proxy p.
+func (c *Client) probeExitIP(p, echoURL string, timeout time.Duration, insecure bool) (string, error) {
+	cli, err := c.getClientWithOpts(p, timeout, insecure, false, true, false)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, echoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := cli.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxEchoBodyBytes))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("echo endpoint answered %s", res.Status)
+	}
+	return parseEchoIP(body)
+}
+
+// parseEchoIP extracts the address from an IP-echo response: plain text
+// ("203.0.113.7") or JSON with an ip or origin field (ipify, httpbin). A
+// comma-separated origin lists the client first.
+func parseEchoIP(body []byte) (string, error) {
+	s := strings.TrimSpace(string(body))
+	if strings.HasPrefix(s, "{") {
+		var m map[string]any
+		if err := json.Unmarshal(body, &m); err != nil {
+			return "", fmt.Errorf("invalid echo response: %w", err)
+		}
+		s = ""
+		for _, k := range []string{"ip", "origin"} {
+			if v, ok := m[k].(string); ok {
+				s = v
+				break
+			}
+		}
+	}
+	s, _, _ = strings.Cut(s, ",")
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return "", fmt.Errorf("echo response is not an IP address: %.64q", s)
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// echoServer answers with the caller's IP as httpbin does, counting requests.
+func echoServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		io.WriteString(w, `{"origin": "`+host+`"}`)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &hits
+}
+
+// Given IP-echo responses in the usual formats
+// When they are parsed
+// Then the caller address is extracted, and anything else is rejected
+func TestParseEchoIP_GivenFormats_WhenParsed_ThenAddress(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"203.0.113.7\n":                        "203.0.113.7",
+		`{"ip":"2001:db8::1"}`:                 "2001:db8::1",
+		`{"origin": "198.51.100.1, 10.0.0.1"}`: "198.51.100.1",
+	}
+	for in, want := range cases {
+		if got, err := parseEchoIP([]byte(in)); err != nil || got != want {
+			t.Fatalf("%q: got %q err=%v", in, got, err)
+		}
+	}
+	for _, bad := range []string{"<html>", `{"address":"1.2.3.4"}`, "{"} {
+		if _, err := parseEchoIP([]byte(bad)); err == nil {
+			t.Fatalf("%q should fail", bad)
+		}
+	}
+}
+
+// Given three proxies, two of them leaving through the same address, one dead and one template
+// When their exit IPs are probed
+// Then each exit IP is recorded, the shared one is flagged and shows in responses and stats
+func TestProbeExitIPs_GivenSharedExit_WhenProbed_ThenDuplicatesFlagged(t *testing.T) {
+	t.Parallel()
+	echo, _ := echoServer(t)
+	a := startFakeSOCKS5(t, nil)
+	b := startFakeSOCKS5(t, nil)
+	other := startFakeSOCKS5(t, func(s *fakeSOCKS5) { s.Source = "127.0.0.2" })
+	dead := "socks5://" + closedAddr(t)
+	tpl := "socks5://u-{session}:p@" + a.Addr()
+
+	c := newHealthClient()
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: a.URL()}, {URL: b.URL()}, {URL: other.URL()}, {URL: dead}, {URL: tpl}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	report, err := c.ProbeExitIPs(ExitIPProbeOptions{EchoURL: echo.URL, Timeout: "2s"})
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if report.Probed != 3 || report.Failed != 1 || report.Skipped != 1 || report.ExitIPs != 2 {
+		t.Fatalf("report=%+v", report)
+	}
+	if len(report.Duplicates) != 1 || report.Duplicates[0].IP != "127.0.0.1" || len(report.Duplicates[0].Proxies) != 2 {
+		t.Fatalf("duplicates=%+v", report.Duplicates)
+	}
+	if report.Results[2].ExitIP != "127.0.0.2" || report.Results[3].Error == "" {
+		t.Fatalf("results=%+v", report.Results)
+	}
+
+	stats := map[string]ProxyStats{}
+	for _, ps := range c.GetProxyStats() {
+		stats[ps.URL] = ps
+	}
+	if stats[a.URL()].SharedExit != 2 || stats[other.URL()].ExitIP != "127.0.0.2" || stats[other.URL()].SharedExit != 0 {
+		t.Fatalf("stats=%+v", stats)
+	}
+	list, _ := c.ListProxies("", false)
+	if list[0].Meta["exitIp"] != "127.0.0.1" || list[3].Meta != nil {
+		t.Fatalf("list=%+v", list)
+	}
+
+	resp := doRequest(t, c, map[string]any{"url": echo.URL, "proxy": map[string]any{"url": other.URL()}})
+	if resp.Proxy == nil || resp.Proxy.ExitIP != "127.0.0.2" {
+		t.Fatalf("proxy=%+v err=%s", resp.Proxy, resp.Error)
+	}
+}
+
+// Given a list loaded with probeExitIPs
+// When the same list is loaded again (as every VU does from the init context)
+// Then it is probed once and the report is part of the load summary
+func TestLoadProxyList_GivenProbeExitIPs_WhenLoadedTwice_ThenProbedOnce(t *testing.T) {
+	t.Parallel()
+	echo, hits := echoServer(t)
+	s := startFakeSOCKS5(t, nil)
+	path := filepath.Join(t.TempDir(), "proxies.txt")
+	if err := os.WriteFile(path, []byte(s.URL()+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newHealthClient()
+	opts := ProxyListOptions{ProbeExitIPs: &ExitIPProbeOptions{EchoURL: echo.URL}}
+	for range 2 {
+		summary, err := c.LoadProxyListWithOptions(path, opts)
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		if summary.ExitIPs == nil || summary.ExitIPs.Probed != 1 {
+			t.Fatalf("summary=%+v", summary)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("probed %d times", hits.Load())
+	}
+
+	if _, err := c.LoadProxyListWithOptions(path, ProxyListOptions{ProbeExitIPs: &ExitIPProbeOptions{}}); err == nil || !strings.Contains(err.Error(), "echoURL") {
+		t.Fatalf("err=%v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+// failoverPolicy is the resolved form of the proxy retry options.
+type failoverPolicy struct {
+	maxRetries int
+	classes    map[ErrorClass]bool
+	statuses   map[int]bool
+	backoff    time.Duration
+	maxBackoff time.Duration
+	budget     time.Duration
+}
+
+// failoverPolicy resolves the retry options. An empty RetryOn retries on every
+// error class the health policy attributes to the proxy.
+func (o ProxyOptions) failoverPolicy() failoverPolicy {
+	p := failoverPolicy{
+		maxRetries: o.MaxRetries,
+		backoff:    defaultRetryBackoff,
+		maxBackoff: defaultRetryMaxBackoff,
+	}
+	for _, v := range o.RetryOn {
+		v = strings.TrimSpace(v)
+		if code, err := strconv.Atoi(v); err == nil {
+			if p.statuses == nil {
+				p.statuses = map[int]bool{}
+			}
+			p.statuses[code] = true
+			continue
+		}
+		if p.classes == nil {
+			p.classes = map[ErrorClass]bool{}
+		}
+		p.classes[ErrorClass(v)] = true
+	}
+	if d, err := time.ParseDuration(o.RetryBackoff); err == nil && d >= 0 {
+		p.backoff = d
+	}
+	if d, err := time.ParseDuration(o.RetryMaxBackoff); err == nil && d > 0 {
+		p.maxBackoff = d
+	}
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = p.backoff
+	}
+	if d, err := time.ParseDuration(o.RetryBudget); err == nil && d > 0 {
+		p.budget = d
+	}
+	return p
+}
+
+// shouldFailover reports whether resp warrants another attempt through a different proxy.
+func (c *Client) shouldFailover(pl *proxyPool, p failoverPolicy, resp *Response) bool {
+	if resp.ErrorClass != "" {
+		if p.classes == nil && p.statuses == nil {
+			return c.healthFor(pl).counts(resp.ErrorClass)
+		}
+		if p.classes[resp.ErrorClass] {
+			return true
+		}
+	}
+	return resp.Error == "" && p.statuses[resp.Status]
+}
+
+// delay returns the wait before retry number attempt (0-based).
+func (p failoverPolicy) delay(attempt int) time.Duration {
+	return backoffDelay(p.backoff, p.maxBackoff, attempt)
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Given a list with two dead proxies followed by a working one
+// When a request allows two retries
+// Then it fails over and reports every proxy tried
+func TestFailover_GivenDeadProxies_WhenRetries_ThenSucceedsThroughNext(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+	good := startFakeSOCKS5(t, nil)
+	dead1, dead2 := "socks5://"+closedAddr(t), "socks5://"+closedAddr(t)
+	path := writeProxiesFile(t, t.TempDir(), []string{dead1, dead2, good.URL()})
+
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{
+		"url":   ts.URL,
+		"proxy": map[string]any{"listPath": path, "maxRetries": int64(2), "retryBackoff": "1ms"},
+	})
+	if resp.Status != http.StatusOK || string(resp.Body) != "OK" {
+		t.Fatalf("status=%d body=%q err=%s", resp.Status, resp.Body, resp.Error)
+	}
+	if resp.Attempts != 3 || len(resp.ProxiesTried) != 3 {
+		t.Fatalf("attempts=%d tried=%v", resp.Attempts, resp.ProxiesTried)
+	}
+	if resp.Proxy == nil || resp.Proxy.URL != good.URL() {
+		t.Fatalf("final proxy=%+v want %s", resp.Proxy, good.URL())
+	}
+}
+
+// Given an HTTP proxy that answers 503 and one that answers 200
+// When retryOn contains 503
+// Then the request is retried through the second proxy
+func TestFailover_GivenRetryOnStatus_WhenProxyReturns503_ThenRetried(t *testing.T) {
+	t.Parallel()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via-ok")
+	}))
+	defer ok.Close()
+	path := writeProxiesFile(t, t.TempDir(), []string{bad.URL, ok.URL})
+
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{
+		"url":   "http://target.invalid/",
+		"proxy": map[string]any{"listPath": path, "maxRetries": int64(1), "retryOn": []any{"503", "proxy_dial"}, "retryBackoff": "1ms"},
+	})
+	if resp.Status != http.StatusOK || string(resp.Body) != "via-ok" || resp.Attempts != 2 {
+		t.Fatalf("status=%d body=%q attempts=%d", resp.Status, resp.Body, resp.Attempts)
+	}
+}
+
+// Given a slow target behind working proxies
+// When the request times out
+// Then it is not retried because the failure is not proxy-attributable
+func TestFailover_GivenTargetTimeout_WhenRetriesAllowed_ThenNoRetry(t *testing.T) {
+	t.Parallel()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	a, b := startFakeSOCKS5(t, nil), startFakeSOCKS5(t, nil)
+	path := writeProxiesFile(t, t.TempDir(), []string{a.URL(), b.URL()})
+
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{
+		"url":   slow.URL,
+		"http":  map[string]any{"timeout": "100ms"},
+		"proxy": map[string]any{"listPath": path, "maxRetries": int64(3)},
+	})
+	if resp.ErrorClass != ErrClassTargetTimeout || resp.Attempts != 1 {
+		t.Fatalf("class=%q attempts=%d", resp.ErrorClass, resp.Attempts)
+	}
+}
+
+// Given only dead proxies and a long backoff
+// When the retry budget is short
+// Then the request gives up once the budget is spent
+func TestFailover_GivenBudget_WhenExceeded_ThenStops(t *testing.T) {
+	t.Parallel()
+	var lines []string
+	for i := 0; i < 5; i++ {
+		lines = append(lines, "socks5://"+closedAddr(t))
+	}
+	path := writeProxiesFile(t, t.TempDir(), lines)
+
+	c := newHealthClient()
+	start := time.Now()
+	resp := doRequest(t, c, map[string]any{
+		"url": "http://target.invalid/",
+		"proxy": map[string]any{
+			"listPath":        path,
+			"maxRetries":      int64(4),
+			"retryBackoff":    "1s",
+			"retryMaxBackoff": "1s",
+			"retryBudget":     "50ms",
+		},
+	})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("budget not honoured: %v", elapsed)
+	}
+	if resp.Error == "" || resp.Attempts >= 5 {
+		t.Fatalf("expected an early failure, got attempts=%d err=%q", resp.Attempts, resp.Error)
+	}
+}
+
+// Given a base backoff and cap
+// When delay is computed for growing attempts
+// Then it stays within the exponential window and never exceeds the cap
+func TestFailoverPolicy_GivenBackoff_WhenDelay_ThenWithinWindow(t *testing.T) {
+	t.Parallel()
+	p := ProxyOptions{RetryBackoff: "10ms", RetryMaxBackoff: "40ms"}.failoverPolicy()
+	for attempt, window := range []time.Duration{10, 20, 40, 40} {
+		for i := 0; i < 50; i++ {
+			if d := p.delay(attempt); d < 0 || d > window*time.Millisecond {
+				t.Fatalf("delay(%d)=%v outside [0,%v]", attempt, d, window*time.Millisecond)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// h2cTransport speaks HTTP/2 with prior knowledge (RFC 9113, section 3.3) to
+// http:// targets: no TLS and no HTTP/1.1 Upgrade, the connection starts
+// with the HTTP/2 preface. Through a proxy, the connection is a SOCKS or
+// CONNECT tunnel, since a forwarding HTTP proxy would receive the preface.
+type h2cTransport struct {
+	*http2.Transport
+}
+
+func (t h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" {
+		// also redirects: the dial would send cleartext HTTP/2 to a TLS port
+		return nil, fmt.Errorf("http.protocol h2c needs http:// URLs, not %s", req.URL.Scheme)
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// newH2CTransport returns the h2c transport of proxyURL: direct without a
+// proxy, through the tunnel of a socks or http(s) one otherwise.
+func (c *Client) newH2CTransport(proxyURL, statsURL string, insecure, skipDecompress bool) (h2cTransport, error) {
+	dial := (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return h2cTransport{}, err
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "socks5", "socks5h", "socks4":
+			if dial, err = c.socksDial(u, statsURL); err != nil {
+				return h2cTransport{}, err
+			}
+		default:
+			dial = connectDial(u, newProxyDialer(c.statsFor(statsURL)), insecure)
+		}
+	}
+	return h2cTransport{&http2.Transport{
+		AllowHTTP:          true,
+		DisableCompression: skipDecompress,
+		IdleConnTimeout:    90 * time.Second,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}}, nil
+}
+
+// connectDial returns the dial function of tunnels opened with CONNECT
+// through the HTTP or HTTPS proxy u, failing like the CONNECT of net/http.
+func connectDial(u *url.URL, dialer *proxyDialer, insecure bool) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	https := strings.EqualFold(u.Scheme, "https")
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if https {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+	var auth string
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass))
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		if https {
+			tconn := tls.Client(conn, &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: insecure})
+			if err := tconn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, &proxyError{class: ErrClassProxyDial, err: fmt.Errorf("proxy TLS: %w", err)}
+			}
+			conn = tconn
+		}
+
+		req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+		if auth != "" {
+			req.Header.Set("Proxy-Authorization", auth)
+		}
+		br := bufio.NewReader(conn)
+		err = req.Write(conn)
+		var res *http.Response
+		if err == nil {
+			res, err = http.ReadResponse(br, req)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, &proxyError{class: ErrClassProxyConnect, err: fmt.Errorf("proxy CONNECT: %w", err)}
+		}
+		res.Body.Close()
+		if err := onProxyConnectResponse(ctx, u, req, res); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		if br.Buffered() > 0 {
+			// the target spoke first; keep what was read past the response
+			return &bufferedConn{Conn: conn, r: br}, nil
+		}
+		return conn, nil
+	}
+}
+
+// bufferedConn is a conn whose first bytes were read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
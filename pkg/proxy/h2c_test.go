@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// startH2CServer serves HTTP/2 with prior knowledge only, answering with the
+// protocol of the request.
+func startH2CServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}), &http2.Server{}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// connectProxy is an HTTP proxy that only tunnels with CONNECT, for clients
+// sending the credentials user:pass.
+func connectProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+	px := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if user, pass, ok := (&http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		done := make(chan struct{}, 2)
+		go func() { io.Copy(upstream, conn); done <- struct{}{} }()
+		go func() { io.Copy(conn, upstream); done <- struct{}{} }()
+		<-done
+	}))
+	t.Cleanup(px.Close)
+	return px
+}
+
+// Given an h2c-only server
+// When it is requested with protocol h2c directly, through socks5 and through a CONNECT proxy
+// Then every request goes over HTTP/2, reusing the connection
+func TestProtocolH2C_GivenPriorKnowledgeServer_WhenRequested_ThenHTTP2(t *testing.T) {
+	t.Parallel()
+	ts := startH2CServer(t)
+	s := startFakeSOCKS5(t, nil)
+	px := connectProxy(t)
+	authed := strings.Replace(px.URL, "http://", "http://user:pass@", 1)
+	c := newHealthClient()
+
+	for _, proxyURL := range []string{"", s.URL(), authed} {
+		params := map[string]any{"url": ts.URL, "http": map[string]any{"protocol": "h2c"}}
+		if proxyURL != "" {
+			params["proxy"] = map[string]any{"url": proxyURL}
+		}
+		for i := range 2 {
+			resp := doRequest(t, c, params)
+			if resp.Status != http.StatusOK || string(resp.Body) != "HTTP/2.0" || resp.ConnReused != (i > 0) {
+				t.Fatalf("proxy %q, request %d: status=%d body=%q reused=%v err=%s", proxyURL, i, resp.Status, resp.Body, resp.ConnReused, resp.Error)
+			}
+		}
+	}
+	if resp := doRequest(t, c, map[string]any{"url": ts.URL}); string(resp.Body) != "HTTP/1.1" {
+		t.Fatalf("auto: %q", resp.Body)
+	}
+}
+
+// Given protocol h2c
+// When the CONNECT proxy refuses the credentials, or the URL is https
+// Then the request fails with the cause
+func TestProtocolH2C_GivenBadProxyOrURL_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	ts := startH2CServer(t)
+	px := connectProxy(t)
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"url": px.URL}, "http": map[string]any{"protocol": "h2c"}})
+	if resp.ErrorClass != ErrClassProxyAuth {
+		t.Fatalf("class=%q err=%s", resp.ErrorClass, resp.Error)
+	}
+	resp = doRequest(t, c, map[string]any{"url": "https://example.invalid/", "http": map[string]any{"protocol": "h2c"}})
+	if !strings.Contains(resp.Error, "needs an http:// URL") {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 1
+	defaultBadProxyTTL      = 5 * time.Minute
+	defaultMinRequests      = 5
+)
+
+// HealthPolicy controls when a proxy is ejected from rotation and how it is reinstated.
+// Durations are strings (e.g. "30s") to match the rest of the JS-facing options.
+type HealthPolicy struct {
+	FailureThreshold int     `json:"failureThreshold" js:"failureThreshold"` // consecutive failures before ejection
+	FailureWindow    string  `json:"failureWindow" js:"failureWindow"`       // window for rate-based ejection; empty disables it
+	FailureRate      float64 `json:"failureRate" js:"failureRate"`           // failure ratio (0..1) within the window that ejects
+	MinRequests      int     `json:"minRequests" js:"minRequests"`           // samples required in the window before the rate applies
+	BaseTTL          string  `json:"baseTTL" js:"baseTTL"`                   // ejection TTL for the first ejection
+	MaxTTL           string  `json:"maxTTL" js:"maxTTL"`                     // cap for exponential backoff on repeated ejections
+	HalfOpenRequests int     `json:"halfOpenRequests" js:"halfOpenRequests"` // successful trial requests required before reinstatement
+
+	// FailureClasses overrides which error classes count against proxy health.
+	// By default only proxy_* classes do.
+	FailureClasses map[string]bool `json:"failureClasses,omitempty" js:"failureClasses"`
+}
+
+// healthConfig is the resolved form of HealthPolicy used on the hot path.
+type healthConfig struct {
+	threshold   int
+	window      time.Duration
+	rate        float64
+	minRequests int
+	baseTTL     time.Duration
+	maxTTL      time.Duration
+	halfOpen    int
+	classes     map[ErrorClass]bool
+}
+
+// resolve converts the policy into a healthConfig, filling unset values with defaults.
+func (p HealthPolicy) resolve() healthConfig {
+	cfg := healthConfig{
+		threshold:   p.FailureThreshold,
+		rate:        p.FailureRate,
+		minRequests: p.MinRequests,
+		baseTTL:     defaultBadProxyTTL,
+		halfOpen:    p.HalfOpenRequests,
+	}
+	if cfg.threshold <= 0 {
+		cfg.threshold = defaultFailureThreshold
+	}
+	if cfg.minRequests <= 0 {
+		cfg.minRequests = defaultMinRequests
+	}
+	if d, err := time.ParseDuration(p.FailureWindow); err == nil && d > 0 {
+		cfg.window = d
+	}
+	if d, err := time.ParseDuration(p.BaseTTL); err == nil && d >= 0 {
+		cfg.baseTTL = d
+	}
+	if d, err := time.ParseDuration(p.MaxTTL); err == nil && d > 0 {
+		cfg.maxTTL = d
+	}
+	if cfg.maxTTL < cfg.baseTTL {
+		cfg.maxTTL = cfg.baseTTL
+	}
+	if len(p.FailureClasses) > 0 {
+		cfg.classes = make(map[ErrorClass]bool, len(p.FailureClasses))
+		for k, v := range p.FailureClasses {
+			cfg.classes[ErrorClass(k)] = v
+		}
+	}
+	return cfg
+}
+
+// counts reports whether an error of the given class counts against proxy health.
+func (cfg healthConfig) counts(class ErrorClass) bool {
+	if v, ok := cfg.classes[class]; ok {
+		return v
+	}
+	return class.proxyAttributable()
+}
+
+// ttlFor returns the ejection TTL for the n-th consecutive ejection (1-based),
+// doubling from baseTTL and capped at maxTTL.
+func (cfg healthConfig) ttlFor(n int) time.Duration {
+	ttl := cfg.baseTTL
+	for i := 1; i < n && ttl < cfg.maxTTL; i++ {
+		ttl *= 2
+	}
+	if ttl > cfg.maxTTL {
+		ttl = cfg.maxTTL
+	}
+	return ttl
+}
+
+// proxyHealth is the per-proxy circuit state. A proxy is either healthy, ejected
+// (badUntil in the future) or half-open (TTL elapsed, admitting trial requests).
+type proxyHealth struct {
+	mu          sync.Mutex
+	consecutive int
+	windowStart time.Time
+	windowTotal int
+	windowFails int
+	ejections   int
+	badUntil    time.Time
+	halfOpen    bool
+	trials      int
+	trialsOK    int
+	trialSince  time.Time
+}
+
+func (h *proxyHealth) resetWindow(now time.Time) {
+	h.windowStart = now
+	h.windowTotal = 0
+	h.windowFails = 0
+}
+
+// observe records one outcome in the tumbling failure-rate window.
+func (h *proxyHealth) observe(cfg healthConfig, now time.Time, failed bool) {
+	if cfg.window <= 0 {
+		return
+	}
+	if h.windowStart.IsZero() || now.Sub(h.windowStart) > cfg.window {
+		h.resetWindow(now)
+	}
+	h.windowTotal++
+	if failed {
+		h.windowFails++
+	}
+}
+
+func (h *proxyHealth) rateExceeded(cfg healthConfig) bool {
+	if cfg.window <= 0 || cfg.rate <= 0 || h.windowTotal < cfg.minRequests {
+		return false
+	}
+	return float64(h.windowFails)/float64(h.windowTotal) >= cfg.rate
+}
+
+func (h *proxyHealth) eject(cfg healthConfig, now time.Time) {
+	h.ejectFor(cfg.ttlFor(h.ejections+1), now)
+}
+
+// ejectFor ejects the proxy for a fixed ttl, counting it as an ejection for the backoff.
+func (h *proxyHealth) ejectFor(ttl time.Duration, now time.Time) {
+	h.ejections++
+	h.badUntil = now.Add(ttl)
+	h.consecutive = 0
+	h.halfOpen = false
+	h.trials = 0
+	h.trialsOK = 0
+	h.resetWindow(time.Time{})
+}
+
+func (h *proxyHealth) reinstate() {
+	h.consecutive = 0
+	h.ejections = 0
+	h.badUntil = time.Time{}
+	h.halfOpen = false
+	h.trials = 0
+	h.trialsOK = 0
+}
+
+// admit reports whether a request may be sent through the proxy now. Once the
+// ejection TTL elapses the proxy becomes half-open and admits at most
+// cfg.halfOpen trial requests until they report back.
+func (h *proxyHealth) admit(cfg healthConfig, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.badUntil.IsZero() {
+		return true
+	}
+	if now.Before(h.badUntil) {
+		return false
+	}
+	if cfg.halfOpen <= 0 {
+		// No trial phase: back in rotation, but keep the ejection count so that
+		// an immediate relapse backs off further.
+		h.badUntil = time.Time{}
+		return true
+	}
+	if !h.halfOpen {
+		h.halfOpen = true
+		h.trials = 0
+		h.trialsOK = 0
+	}
+	// A trial that never reported back must not park the proxy forever.
+	if h.trials >= cfg.halfOpen && now.Sub(h.trialSince) > cfg.baseTTL {
+		h.trials = h.trialsOK
+	}
+	if h.trials >= cfg.halfOpen {
+		return false
+	}
+	h.trials++
+	h.trialSince = now
+	return true
+}
+
+// admissible reports whether admit would let a request through now, without
+// taking a half-open trial.
+func (h *proxyHealth) admissible(cfg healthConfig, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.badUntil.IsZero():
+		return true
+	case now.Before(h.badUntil):
+		return false
+	case cfg.halfOpen <= 0 || !h.halfOpen:
+		return true
+	}
+	return h.trials < cfg.halfOpen || now.Sub(h.trialSince) > cfg.baseTTL
+}
+
+// status reports the circuit state of h and, while ejected, until when.
+func (h *proxyHealth) status(cfg healthConfig, now time.Time) (string, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case now.Before(h.badUntil):
+		return "ejected", h.badUntil
+	case h.halfOpen || (cfg.halfOpen > 0 && !h.badUntil.IsZero()):
+		return "half_open", time.Time{}
+	default:
+		return "healthy", time.Time{}
+	}
+}
+
+func (h *proxyHealth) success(cfg healthConfig, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.halfOpen {
+		h.trialsOK++
+		if h.trialsOK >= cfg.halfOpen {
+			h.reinstate()
+		}
+		return
+	}
+	if !h.badUntil.IsZero() {
+		// late success from a request started before the ejection
+		return
+	}
+	h.consecutive = 0
+	h.ejections = 0
+	h.observe(cfg, now, false)
+}
+
+// failure records a failed request and reports whether it ejected the proxy.
+func (h *proxyHealth) failure(cfg healthConfig, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.halfOpen {
+		h.eject(cfg, now)
+		return true
+	}
+	if !h.badUntil.IsZero() && now.Before(h.badUntil) {
+		return false
+	}
+	h.consecutive++
+	h.observe(cfg, now, true)
+	if h.consecutive >= cfg.threshold || h.rateExceeded(cfg) {
+		h.eject(cfg, now)
+		return true
+	}
+	return false
+}
+
+func (c *Client) healthState(pl *proxyPool, p string) *proxyHealth {
+	if v, ok := pl.health.Load(p); ok {
+		return v.(*proxyHealth)
+	}
+	v, _ := pl.health.LoadOrStore(p, &proxyHealth{})
+	return v.(*proxyHealth)
+}
+
+// proxyAvailable reports whether p may be used from pl now. Proxies without
+// recorded state are always available and no state is allocated for them.
+func (c *Client) proxyAvailable(pl *proxyPool, p string) bool {
+	v, ok := pl.health.Load(p)
+	if !ok {
+		return true
+	}
+	return v.(*proxyHealth).admit(c.healthFor(pl), time.Now())
+}
+
+// proxyAdmissible is proxyAvailable without taking a half-open trial, for
+// callers that may still pass over p.
+func (c *Client) proxyAdmissible(pl *proxyPool, p string) bool {
+	v, ok := pl.health.Load(p)
+	if !ok {
+		return true
+	}
+	return v.(*proxyHealth).admissible(c.healthFor(pl), time.Now())
+}
+
+// recordProxySuccess resets the failure streak of p, completing a half-open trial if one is running.
+func (c *Client) recordProxySuccess(pl *proxyPool, p string) {
+	if p == "" {
+		return
+	}
+	cfg := c.healthFor(pl)
+	if cfg.window > 0 {
+		// rate-based ejection needs successes in the window as well
+		c.healthState(pl, p).success(cfg, time.Now())
+		return
+	}
+	if v, ok := pl.health.Load(p); ok {
+		v.(*proxyHealth).success(cfg, time.Now())
+	}
+}
+
+// recordProxyFailure counts a failure of the given class against p and ejects it
+// once the policy says so. Classes the policy does not attribute to the proxy are ignored.
+func (c *Client) recordProxyFailure(pl *proxyPool, p string, class ErrorClass) {
+	cfg := c.healthFor(pl)
+	if p == "" || !cfg.counts(class) {
+		return
+	}
+	c.healthState(pl, p).failure(cfg, time.Now())
+}
+
+// markBadProxy ejects p immediately, regardless of the failure threshold.
+func (c *Client) markBadProxy(pl *proxyPool, p string) {
+	if p == "" {
+		return
+	}
+	h := c.healthState(pl, p)
+	h.mu.Lock()
+	h.eject(c.healthFor(pl), time.Now())
+	h.mu.Unlock()
+}
+
+// unmarkBadProxy fully reinstates p and forgets its failure history.
+func (c *Client) unmarkBadProxy(pl *proxyPool, p string) {
+	if p == "" {
+		return
+	}
+	pl.health.Delete(p)
+}
+
+// clientHealth is the global health policy with its resolved form, replaced
+// as a whole so requests never see a policy and a config that disagree.
+type clientHealth struct {
+	policy HealthPolicy
+	cfg    healthConfig
+}
+
+// defaultHealth is in effect until SetHealthPolicy is called.
+var defaultHealth = &clientHealth{cfg: HealthPolicy{}.resolve()}
+
+// SetHealthPolicy replaces the proxy health policy. Existing per-proxy state is kept.
+// It is safe to call while requests are in flight.
+func (c *Client) SetHealthPolicy(p HealthPolicy) {
+	p.FailureClasses = maps.Clone(p.FailureClasses)
+	c.health.Store(&clientHealth{policy: p, cfg: p.resolve()})
+}
+
+// healthPolicy returns the global health policy as last set.
+func (c *Client) healthPolicy() HealthPolicy {
+	return c.globalHealth().policy
+}
+
+func (c *Client) globalHealth() *clientHealth {
+	if h := c.health.Load(); h != nil {
+		return h
+	}
+	return defaultHealth
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Given a failure threshold of 3
+// When a proxy fails twice and then a third time
+// Then it stays in rotation until the third failure ejects it
+func TestHealth_GivenThreshold_WhenFailures_ThenEjectedAtThreshold(t *testing.T) {
+	t.Parallel()
+	c := healthClient(HealthPolicy{FailureThreshold: 3, BaseTTL: "1m"})
+
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+	if !c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("proxy ejected before threshold")
+	}
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+	if c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("proxy should be ejected at threshold")
+	}
+}
+
+// Given a success between failures
+// When the streak is interrupted
+// Then the consecutive counter restarts
+func TestHealth_GivenSuccessBetweenFailures_WhenCounted_ThenStreakResets(t *testing.T) {
+	t.Parallel()
+	c := healthClient(HealthPolicy{FailureThreshold: 2, BaseTTL: "1m"})
+
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+	c.recordProxySuccess(&c.pool, "p")
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+	if !c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("streak should have been reset by the success")
+	}
+}
+
+// Given baseTTL=1s and maxTTL=5s
+// When ttlFor is computed for repeated ejections
+// Then it doubles and is capped at maxTTL
+func TestHealthConfig_GivenBackoff_WhenRepeated_ThenDoublesAndCaps(t *testing.T) {
+	t.Parallel()
+	cfg := HealthPolicy{BaseTTL: "1s", MaxTTL: "5s"}.resolve()
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := cfg.ttlFor(i + 1); got != w {
+			t.Fatalf("ttlFor(%d)=%v want %v", i+1, got, w)
+		}
+	}
+}
+
+// Given an empty policy
+// When resolved
+// Then the legacy behaviour (one failure, 5 minutes) is kept
+func TestHealthPolicy_GivenEmpty_WhenResolve_ThenLegacyDefaults(t *testing.T) {
+	t.Parallel()
+	cfg := HealthPolicy{}.resolve()
+	if cfg.threshold != 1 || cfg.baseTTL != 5*time.Minute || cfg.maxTTL != 5*time.Minute || cfg.halfOpen != 0 {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+}
+
+// Given halfOpenRequests=2
+// When the TTL elapses
+// Then only two trials are admitted and two successes reinstate the proxy
+func TestHealth_GivenHalfOpen_WhenTrialsSucceed_ThenReinstated(t *testing.T) {
+	t.Parallel()
+	c := healthClient(HealthPolicy{BaseTTL: "20ms", HalfOpenRequests: 2})
+
+	c.markBadProxy(&c.pool, "p")
+	time.Sleep(30 * time.Millisecond)
+
+	if !c.proxyAvailable(&c.pool, "p") || !c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("expected two half-open trials to be admitted")
+	}
+	if c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("third trial should wait for outcomes")
+	}
+	c.recordProxySuccess(&c.pool, "p")
+	c.recordProxySuccess(&c.pool, "p")
+	for i := 0; i < 5; i++ {
+		if !c.proxyAvailable(&c.pool, "p") {
+			t.Fatalf("proxy should be fully reinstated")
+		}
+	}
+}
+
+// Given a half-open proxy
+// When its trial fails
+// Then it is ejected again with a doubled TTL
+func TestHealth_GivenHalfOpen_WhenTrialFails_ThenEjectedWithBackoff(t *testing.T) {
+	t.Parallel()
+	c := healthClient(HealthPolicy{BaseTTL: "20ms", MaxTTL: "1s", HalfOpenRequests: 1})
+
+	c.markBadProxy(&c.pool, "p")
+	time.Sleep(30 * time.Millisecond)
+	if !c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("expected half-open trial")
+	}
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+
+	h := c.healthState(&c.pool, "p")
+	h.mu.Lock()
+	ejections, until := h.ejections, time.Until(h.badUntil)
+	h.mu.Unlock()
+	if ejections != 2 {
+		t.Fatalf("ejections=%d want 2", ejections)
+	}
+	if until <= 20*time.Millisecond || until > 40*time.Millisecond {
+		t.Fatalf("second ejection TTL=%v want ~40ms", until)
+	}
+}
+
+// Given a listed proxy that is half-open with halfOpenRequests=1
+// When a request picks it from the list
+// Then the single trial is used by that request and its success reinstates the proxy
+func TestHealth_GivenHalfOpenListProxy_WhenPicked_ThenTrialSucceeds(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	s := startFakeSOCKS5(t, nil)
+	c := healthClient(HealthPolicy{BaseTTL: "20ms", HalfOpenRequests: 1})
+	path := writeProxiesFile(t, t.TempDir(), []string{s.URL()})
+	if err := c.LoadProxyList(path); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+
+	c.markBadProxy(&c.pool, s.URL())
+	time.Sleep(30 * time.Millisecond)
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"listPath": path}})
+	if resp.Error != "" || resp.Status != http.StatusOK {
+		t.Fatalf("half-open trial failed: status=%d err=%s", resp.Status, resp.Error)
+	}
+	if resp.Proxy == nil || resp.Proxy.URL != s.URL() {
+		t.Fatalf("request did not go through the listed proxy: %+v", resp.Proxy)
+	}
+	for i := 0; i < 3; i++ {
+		if !c.proxyAvailable(&c.pool, s.URL()) {
+			t.Fatalf("proxy should be reinstated after its trial succeeded")
+		}
+	}
+}
+
+// Given a failure-rate window
+// When half of the requests in the window fail
+// Then the proxy is ejected even though the streak never hits the threshold
+func TestHealth_GivenFailureRate_WhenExceeded_ThenEjected(t *testing.T) {
+	t.Parallel()
+	c := healthClient(HealthPolicy{
+		FailureThreshold: 100,
+		FailureWindow:    "1m",
+		FailureRate:      0.5,
+		MinRequests:      4,
+		BaseTTL:          "1m",
+	})
+
+	c.recordProxySuccess(&c.pool, "p")
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+	c.recordProxySuccess(&c.pool, "p")
+	if !c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("ejected before minRequests")
+	}
+	c.recordProxyFailure(&c.pool, "p", ErrClassProxyDial)
+	if c.proxyAvailable(&c.pool, "p") {
+		t.Fatalf("expected rate-based ejection")
+	}
+}
+
+// Given configure() with proxy.health
+// When applied
+// Then the resolved policy is used by the client
+func TestConfigure_GivenHealthPolicy_WhenApplied_ThenResolved(t *testing.T) {
+	t.Parallel()
+	c := &Client{}
+	_, err := c.Configure(map[string]any{
+		"proxy": map[string]any{
+			"health": map[string]any{
+				"failureThreshold": int64(3),
+				"baseTTL":          "10s",
+				"maxTTL":           "2m",
+				"halfOpenRequests": float64(2),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	h := c.healthFor(&c.pool)
+	if h.threshold != 3 || h.baseTTL != 10*time.Second || h.maxTTL != 2*time.Minute || h.halfOpen != 2 {
+		t.Fatalf("unexpected resolved policy: %+v", h)
+	}
+}
+
+// Given a health policy with failure classes in use
+// When configure() sets other failure classes
+// Then they replace the previous ones and the policy in use is not mutated
+func TestConfigure_GivenFailureClasses_WhenReconfigured_ThenReplacedNotMerged(t *testing.T) {
+	t.Parallel()
+	c := &Client{}
+	classes := func(m map[string]any) map[string]any {
+		return map[string]any{"proxy": map[string]any{"health": map[string]any{"failureClasses": m}}}
+	}
+	if _, err := c.Configure(classes(map[string]any{"timeout": false})); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	before := c.healthPolicy()
+	if _, err := c.Configure(classes(map[string]any{"http_5xx": true})); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, ok := before.FailureClasses["http_5xx"]; ok || len(before.FailureClasses) != 1 {
+		t.Fatalf("previous policy mutated: %v", before.FailureClasses)
+	}
+	after := c.healthPolicy().FailureClasses
+	if _, ok := after["timeout"]; ok || len(after) != 1 || !after["http_5xx"] {
+		t.Fatalf("failure classes: %v", after)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -45,6 +46,61 @@ func asBool(v any) (bool, bool) {
 	}
 }
 
+// asInt attempts to convert v to int; JS numbers arrive as int64 or float64
+func asInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(n))
+		return i, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// asFloat attempts to convert v to float64
+func asFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// asStringSlice converts a JS array (or a single scalar) to []string
+func asStringSlice(v any) []string {
+	switch src := v.(type) {
+	case []string:
+		return src
+	case []any:
+		out := make([]string, 0, len(src))
+		for _, e := range src {
+			if s, ok := asString(e); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case nil:
+		return nil
+	default:
+		s, _ := asString(v)
+		return []string{s}
+	}
+}
+
 // toStringMapString attempts to convert v to map[string]string
 func toStringMapString(v any) map[string]string {
 	m := map[string]string{}
@@ -144,37 +200,45 @@ func normalizeKeys(m map[string]any) map[string]any {
 
 // decode helpers to fill option structs
 func decodeHTTPOptions(m map[string]any, dst *HTTPOptions) {
-	if v, ok := m["timeout"]; ok {
+	if v, ok := m["timeout"]; ok && v != nil {
 		if s, ok := asString(v); ok {
 			dst.Timeout = s
 		}
 	}
-	if v, ok := m["insecureSkipVerify"]; ok {
+	if v, ok := m["insecureSkipVerify"]; ok && v != nil {
 		if b, ok := asBool(v); ok {
 			dst.InsecureSkipVerify = b
 		}
 	}
-	if v, ok := m["disableHTTP2"]; ok {
+	if v, ok := m["protocol"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Protocol = s
+		}
+	}
+	if v, ok := m["disableHTTP2"]; ok && v != nil {
 		if b, ok := asBool(v); ok {
 			dst.DisableHTTP2 = b
 		}
 	}
-	if v, ok := m["autoReferer"]; ok {
+	if v, ok := m["autoReferer"]; ok && v != nil {
 		if b, ok := asBool(v); ok {
 			dst.AutoReferer = b
 		}
 	}
-	if v, ok := m["followRedirects"]; ok {
+	if v, ok := m["followRedirects"]; ok && v != nil {
 		if b, ok := asBool(v); ok {
 			dst.FollowRedirects = b
 		}
 	}
-	if v, ok := m["acceptGzip"]; ok {
+	if v, ok := m["acceptGzip"]; ok && v != nil {
 		if b, ok := asBool(v); ok {
 			dst.AcceptGzip = b
 		}
 	}
-	if v, ok := m["headers"]; ok {
+	if v, ok := m["acceptEncoding"]; ok && v != nil {
+		dst.AcceptEncoding = asStringSlice(v)
+	}
+	if v, ok := m["headers"]; ok && v != nil {
 		if dst.Headers == nil {
 			dst.Headers = map[string]string{}
 		}
@@ -182,34 +246,400 @@ func decodeHTTPOptions(m map[string]any, dst *HTTPOptions) {
 			dst.Headers[k] = s
 		}
 	}
-	if v, ok := m["randomUserAgent"]; ok {
+	if v, ok := m["randomUserAgent"]; ok && v != nil {
 		if b, ok := asBool(v); ok {
 			dst.RandomUserAgent = b
 		}
 	}
-	if v, ok := m["userAgentListPath"]; ok {
+	if v, ok := m["userAgentListPath"]; ok && v != nil {
 		if s, ok := asString(v); ok {
 			dst.UserAgentListPath = s
 		}
 	}
+	if v, ok := m["discardBody"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.DiscardBody = b
+		}
+	}
+	if v, ok := m["skipDecompress"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.SkipDecompress = b
+		}
+	}
+	if v, ok := m["cookies"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Cookies = s
+		}
+	}
+	if v, ok := m["chunked"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.Chunked = b
+		}
+	}
+	if v, ok := m["maxRedirects"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.MaxRedirects = n
+		}
+	}
+	if v, ok := m["redirectCrossHost"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RedirectCrossHost = s
+		}
+	}
+	if v, ok := m["redirectDowngrade"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RedirectDowngrade = s
+		}
+	}
+	if v, ok := m["redirectProxy"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RedirectProxy = s
+		}
+	}
+	if v, ok := m["redirectReferer"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RedirectReferer = s
+		}
+	}
+	if v, ok := m["responseType"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.ResponseType = s
+		}
+	}
+	if v, ok := m["maxBodyBytes"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.MaxBodyBytes = int64(n)
+		}
+	}
+	if v, ok := m["drainLimit"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.DrainLimit = int64(n)
+		}
+	}
+	if v, ok := m["bodyHash"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.BodyHash = s
+		}
+	}
+	if v, ok := m["retries"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.Retries = n
+		}
+	}
+	if v, ok := m["retryStatuses"]; ok && v != nil {
+		dst.RetryStatuses = nil
+		for _, s := range asStringSlice(v) {
+			if n, ok := asInt(s); ok {
+				dst.RetryStatuses = append(dst.RetryStatuses, n)
+			}
+		}
+	}
+	if v, ok := m["retryErrors"]; ok && v != nil {
+		dst.RetryErrors = asStringSlice(v)
+	}
+	if v, ok := m["retryBackoff"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RetryBackoff = s
+		}
+	}
+	if v, ok := m["retryMaxBackoff"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RetryMaxBackoff = s
+		}
+	}
+	if v, ok := m["maxRetryAfter"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.MaxRetryAfter = s
+		}
+	}
+	if v, ok := m["retryNonIdempotent"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.RetryNonIdempotent = b
+		}
+	}
 }
 
 func decodeProxyOptions(m map[string]any, dst *ProxyOptions) {
-	if v, ok := m["url"]; ok {
+	if v, ok := m["url"]; ok && v != nil {
 		if s, ok := asString(v); ok {
 			dst.URL = s
 		}
 	}
-	if v, ok := m["listPath"]; ok {
+	if v, ok := m["listPath"]; ok && v != nil {
 		if s, ok := asString(v); ok {
 			dst.ListPath = s
 		}
 	}
-	if v, ok := m["disable"]; ok {
+	if v, ok := m["pool"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Pool = s
+		}
+	}
+	if v, ok := m["disable"]; ok && v != nil {
 		if b, ok := asBool(v); ok {
 			dst.Disable = b
 		}
 	}
+	if v, ok := m["defaultScheme"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.DefaultScheme = s
+		}
+	}
+	if v, ok := m["filter"]; ok && v != nil {
+		if fm, ok := v.(map[string]any); ok {
+			f := &ProxyFilter{}
+			if s, ok := asString(fm["country"]); ok && fm["country"] != nil {
+				f.Country = s
+			}
+			if s, ok := asString(fm["provider"]); ok && fm["provider"] != nil {
+				f.Provider = s
+			}
+			if tv, ok := fm["tags"]; ok && tv != nil {
+				f.Tags = asStringSlice(tv)
+			} else if tv, ok := fm["tag"]; ok && tv != nil {
+				f.Tags = asStringSlice(tv)
+			}
+			dst.Filter = f
+		}
+	}
+	if v, ok := m["maxRetries"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.MaxRetries = n
+		}
+	}
+	if v, ok := m["retryOn"]; ok && v != nil {
+		dst.RetryOn = asStringSlice(v)
+	}
+	if v, ok := m["retryBackoff"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RetryBackoff = s
+		}
+	}
+	if v, ok := m["retryMaxBackoff"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RetryMaxBackoff = s
+		}
+	}
+	if v, ok := m["retryBudget"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RetryBudget = s
+		}
+	}
+	if v, ok := m["maxInFlight"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.MaxInFlight = n
+		}
+	}
+	if v, ok := m["maxRps"]; ok && v != nil {
+		if f, ok := asFloat(v); ok {
+			dst.MaxRps = f
+		}
+	}
+	if v, ok := m["limitWait"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.LimitWait = s
+		}
+	}
+	if v, ok := m["sessionRotation"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.SessionRotation = s
+		}
+	}
+	if v, ok := m["sessionRequests"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.SessionRequests = n
+		}
+	}
+	if v, ok := m["onExhausted"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.OnExhausted = s
+		}
+	}
+	if v, ok := m["exhaustedWait"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.ExhaustedWait = s
+		}
+	}
+	if v, ok := m["abortAfter"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.AbortAfter = s
+		}
+	}
+}
+
+func decodeProxyListOptions(m map[string]any, dst *ProxyListOptions) {
+	if v, ok := m["defaultScheme"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.DefaultScheme = s
+		}
+	}
+	if v, ok := m["name"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Name = s
+		}
+	}
+	if v, ok := m["strategy"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Strategy = s
+		}
+	}
+	if v, ok := m["health"]; ok && v != nil {
+		if hm, ok := v.(map[string]any); ok {
+			dst.Health = &HealthPolicy{}
+			decodeHealthPolicy(hm, dst.Health)
+		}
+	}
+	if v, ok := m["refreshInterval"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.RefreshInterval = s
+		}
+	}
+	if v, ok := m["headers"]; ok && v != nil {
+		if dst.Headers == nil {
+			dst.Headers = map[string]string{}
+		}
+		for k, s := range toStringMapString(v) {
+			dst.Headers[k] = s
+		}
+	}
+	if v, ok := m["fieldPath"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.FieldPath = s
+		}
+	}
+	if v, ok := m["probeExitIPs"]; ok && v != nil {
+		if pm, ok := v.(map[string]any); ok {
+			dst.ProbeExitIPs = &ExitIPProbeOptions{}
+			decodeExitIPProbeOptions(pm, dst.ProbeExitIPs)
+		}
+	}
+}
+
+func decodeHealthPolicy(m map[string]any, dst *HealthPolicy) {
+	if v, ok := m["failureThreshold"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.FailureThreshold = n
+		}
+	}
+	if v, ok := m["failureWindow"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.FailureWindow = s
+		}
+	}
+	if v, ok := m["failureRate"]; ok && v != nil {
+		if f, ok := asFloat(v); ok {
+			dst.FailureRate = f
+		}
+	}
+	if v, ok := m["minRequests"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.MinRequests = n
+		}
+	}
+	if v, ok := m["baseTTL"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.BaseTTL = s
+		}
+	}
+	if v, ok := m["maxTTL"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.MaxTTL = s
+		}
+	}
+	if v, ok := m["halfOpenRequests"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.HalfOpenRequests = n
+		}
+	}
+	if v, ok := m["failureClasses"]; ok && v != nil {
+		if cm, ok := v.(map[string]any); ok {
+			// a fresh map: the classes replace the previous ones, whose map
+			// may be shared with the policy in use
+			classes := make(map[string]bool, len(cm))
+			for k, cv := range cm {
+				if b, ok := asBool(cv); ok {
+					classes[k] = b
+				}
+			}
+			dst.FailureClasses = classes
+		}
+	}
+}
+
+func decodeExportProxiesOptions(m map[string]any, dst *ExportProxiesOptions) {
+	if v, ok := m["pool"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Pool = s
+		}
+	}
+	if v, ok := m["sort"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Sort = s
+		}
+	}
+	if v, ok := m["minSuccessRate"]; ok && v != nil {
+		if f, ok := asFloat(v); ok {
+			dst.MinSuccessRate = f
+		}
+	}
+	if v, ok := m["includeEjected"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.IncludeEjected = b
+		}
+	}
+}
+
+func decodeExitIPProbeOptions(m map[string]any, dst *ExitIPProbeOptions) {
+	if v, ok := m["echoURL"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.EchoURL = s
+		}
+	}
+	if v, ok := m["pool"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Pool = s
+		}
+	}
+	if v, ok := m["timeout"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			dst.Timeout = s
+		}
+	}
+	if v, ok := m["concurrency"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.Concurrency = n
+		}
+	}
+	if v, ok := m["insecureSkipVerify"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.InsecureSkipVerify = b
+		}
+	}
+}
+
+func decodeCookie(m map[string]any, dst *Cookie) {
+	for k, p := range map[string]*string{"name": &dst.Name, "value": &dst.Value, "domain": &dst.Domain, "path": &dst.Path, "expires": &dst.Expires} {
+		if v, ok := m[k]; ok && v != nil {
+			if s, ok := asString(v); ok {
+				*p = s
+			}
+		}
+	}
+	if v, ok := m["maxAge"]; ok && v != nil {
+		if n, ok := asInt(v); ok {
+			dst.MaxAge = n
+		}
+	}
+	if v, ok := m["secure"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.Secure = b
+		}
+	}
+	if v, ok := m["httpOnly"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			dst.HttpOnly = b
+		}
+	}
 }
 
 func readLines(path string) ([]string, time.Time, error) {
@@ -296,6 +296,23 @@ func TestDecodeProxyOptions_GivenMap_WhenDecode_ThenStructFilled(t *testing.T) {
 	}
 }
 
+// Given options set to null, as JavaScript callers pass for "unset"
+// When decodeProxyOptions and decodeHTTPOptions are called
+// Then the null fields keep their previous values instead of becoming "<nil>"
+func TestDecodeOptions_GivenNullValues_WhenDecode_ThenIgnored(t *testing.T) {
+	t.Parallel()
+	proxy := ProxyOptions{Pool: "eu"}
+	decodeProxyOptions(map[string]any{"pool": nil, "defaultScheme": nil, "filter": map[string]any{"tags": nil}}, &proxy)
+	if proxy.Pool != "eu" || proxy.DefaultScheme != "" || proxy.Filter == nil || proxy.Filter.Tags != nil {
+		t.Fatalf("null proxy options not ignored: %+v", proxy)
+	}
+	var http HTTPOptions
+	decodeHTTPOptions(map[string]any{"timeout": nil, "protocol": nil, "maxRedirects": nil}, &http)
+	if http.Timeout != "" || http.Protocol != "" || http.MaxRedirects != 0 {
+		t.Fatalf("null HTTP options not ignored: %+v", http)
+	}
+}
+
 // Given a text file containing normal lines, empty lines, and comments
 // When readLines is called
 // Then it should return only trimmed non-comment lines, preserve modification time, and error on missing file
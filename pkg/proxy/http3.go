@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// h3HandshakeTimeout matches the dial timeout of the TCP transports.
+const h3HandshakeTimeout = 10 * time.Second
+
+// newH3Transport returns the HTTP/3 transport of proxyURL: direct without a
+// proxy, through UDP ASSOCIATE with a socks5 one. Other proxies cannot carry
+// UDP. The TLS options are those of the TCP transports.
+func (c *Client) newH3Transport(proxyURL, statsURL string, insecure, skipDecompress bool) (*http3.Transport, error) {
+	tr := &http3.Transport{
+		TLSClientConfig:    &tls.Config{InsecureSkipVerify: insecure},
+		QUICConfig:         &quic.Config{HandshakeIdleTimeout: h3HandshakeTimeout},
+		DisableCompression: skipDecompress,
+	}
+	if proxyURL == "" {
+		return tr, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("http.protocol h3 needs a socks5 proxy, not %s", u.Scheme)
+	}
+	user := u.User.Username()
+	pass, _ := u.User.Password()
+	dialer := newProxyDialer(c.statsFor(statsURL))
+	tr.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+		target, err := udpTarget(addr)
+		if err != nil {
+			return nil, err
+		}
+		pc, err := socksAssociate(ctx, dialer, u.Host, user, pass, target)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := quic.DialEarly(ctx, pc, target, tlsCfg, cfg)
+		if err != nil {
+			pc.Close()
+			return nil, err
+		}
+		go func() {
+			// quic-go leaves packet conns it did not create open
+			<-conn.Context().Done()
+			pc.Close()
+		}()
+		return conn, nil
+	}
+	return tr, nil
+}
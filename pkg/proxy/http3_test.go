@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// startH3Server serves HTTP/3 on loopback UDP with the self-signed
+// certificate of httptest, answering with the protocol of the request.
+func startH3Server(t *testing.T) string {
+	t.Helper()
+	tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := tlsSrv.TLS.Certificates
+	tlsSrv.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	srv := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: cert}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		}),
+	}
+	go srv.Serve(conn)
+	t.Cleanup(func() {
+		srv.Close()
+		conn.Close()
+	})
+	return "https://" + conn.LocalAddr().String() + "/"
+}
+
+// Given an HTTP/3 server
+// When it is requested with protocol h3 directly and twice through a socks5 proxy with credentials
+// Then the requests go over HTTP/3, the second one on the same QUIC connection, and the relay traffic is counted
+func TestProtocolH3_GivenQUICServer_WhenDirectOrSOCKS5_ThenHTTP3(t *testing.T) {
+	t.Parallel()
+	target := startH3Server(t)
+	c := newHealthClient()
+	opts := map[string]any{"protocol": "h3", "insecureSkipVerify": true}
+
+	resp := doRequest(t, c, map[string]any{"url": target, "http": opts})
+	if resp.Status != http.StatusOK || string(resp.Body) != "HTTP/3.0" {
+		t.Fatalf("direct: status=%d body=%q err=%s", resp.Status, resp.Body, resp.Error)
+	}
+
+	s := startFakeSOCKS5(t, func(s *fakeSOCKS5) { s.User, s.Pass = "u", "p" })
+	for i := range 2 {
+		resp = doRequest(t, c, map[string]any{"url": target, "proxy": map[string]any{"url": s.URL()}, "http": opts})
+		if resp.Status != http.StatusOK || string(resp.Body) != "HTTP/3.0" || resp.ConnReused != (i > 0) {
+			t.Fatalf("socks5 %d: status=%d body=%q reused=%v err=%s", i, resp.Status, resp.Body, resp.ConnReused, resp.Error)
+		}
+	}
+	if s.accepted.Load() != 1 {
+		t.Fatalf("associations: %d", s.accepted.Load())
+	}
+	for _, ps := range c.GetProxyStats() {
+		if ps.URL == s.URL() && (ps.BytesUp == 0 || ps.BytesDown == 0 || ps.Successes != 2) {
+			t.Fatalf("stats: %+v", ps)
+		}
+	}
+}
+
+// Given a socks5 proxy answering UDP ASSOCIATE with the host name of its relay
+// When an h3 request goes through it
+// Then the name is resolved and the request is relayed
+func TestProtocolH3_GivenRelayHostName_WhenSOCKS5_ThenResolved(t *testing.T) {
+	t.Parallel()
+	target := startH3Server(t)
+	c := newHealthClient()
+	opts := map[string]any{"protocol": "h3", "insecureSkipVerify": true}
+
+	s := startFakeSOCKS5(t, func(s *fakeSOCKS5) { s.Relay = "localhost" })
+	resp := doRequest(t, c, map[string]any{"url": target, "proxy": map[string]any{"url": s.URL()}, "http": opts})
+	if resp.Status != http.StatusOK || string(resp.Body) != "HTTP/3.0" {
+		t.Fatalf("status=%d body=%q err=%s", resp.Status, resp.Body, resp.Error)
+	}
+
+	bad := startFakeSOCKS5(t, func(s *fakeSOCKS5) { s.Relay = "relay.invalid" })
+	resp = doRequest(t, c, map[string]any{"url": target, "proxy": map[string]any{"url": bad.URL()}, "http": opts})
+	if resp.ErrorClass != ErrClassProxyDial || !strings.Contains(resp.Error, "relay.invalid") {
+		t.Fatalf("unresolvable: class=%s err=%s", resp.ErrorClass, resp.Error)
+	}
+}
+
+// Given a relay socket that is not a UDP conn
+// When quic-go sizes its buffers
+// Then an error is returned instead of a panic
+func TestSocksPacketConn_GivenNonUDPConn_WhenSetBuffer_ThenError(t *testing.T) {
+	t.Parallel()
+	pc, err := net.ListenPacket("unixgram", filepath.Join(t.TempDir(), "relay"))
+	if err != nil {
+		t.Skipf("unixgram: %v", err)
+	}
+	defer pc.Close()
+	c := &socksPacketConn{PacketConn: pc}
+	if c.SetReadBuffer(1<<20) == nil || c.SetWriteBuffer(1<<20) == nil {
+		t.Fatal("want errors for a non-UDP socket")
+	}
+}
+
+// Given protocol h3
+// When the proxy cannot relay UDP, or is not socks5, or the protocol is unknown
+// Then the request fails with the class of the cause
+func TestProtocolH3_GivenUnsupportedProxy_WhenRequest_ThenClassified(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	opts := map[string]any{"protocol": "h3", "insecureSkipVerify": true}
+
+	noUDP := startFakeSOCKS5(t, func(s *fakeSOCKS5) { s.Reply = 7 }) // command not supported
+	resp := doRequest(t, c, map[string]any{"url": "https://127.0.0.1:1/", "proxy": map[string]any{"url": noUDP.URL()}, "http": opts})
+	if resp.ErrorClass != ErrClassProxyReply || !strings.Contains(resp.Error, "UDP ASSOCIATE") {
+		t.Fatalf("no udp: class=%s err=%s", resp.ErrorClass, resp.Error)
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": "https://127.0.0.1:1/", "proxy": map[string]any{"url": "http://127.0.0.1:1"}, "http": opts})
+	if resp.ErrorClass != ErrClassProxyConfig || !strings.Contains(resp.Error, "needs a socks5 proxy") {
+		t.Fatalf("http proxy: class=%s err=%s", resp.ErrorClass, resp.Error)
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": "https://127.0.0.1:1/", "http": map[string]any{"protocol": "spdy"}})
+	if !strings.Contains(resp.Error, "unsupported http.protocol") {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
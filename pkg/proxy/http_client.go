@@ -7,12 +7,35 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/proxy"
 )
 
+// Transport protocols of http.protocol, each with its own cached clients.
+const (
+	protocolAuto = "auto" // HTTP/2 or HTTP/1.1 over TCP, as negotiated with ALPN (default)
+	protocolH3   = "h3"   // HTTP/3 over QUIC, direct or through SOCKS5 UDP ASSOCIATE; see http3.go
+	protocolH2C  = "h2c"  // HTTP/2 with prior knowledge over cleartext TCP; see h2c.go
+)
+
+var protocols = []string{protocolAuto, protocolH3, protocolH2C}
+
+// protocol returns the validated http.protocol, "" standing for auto.
+func (o HTTPOptions) protocol() (string, error) {
+	p := strings.ToLower(o.Protocol)
+	switch {
+	case p == "" || p == protocolAuto:
+		return "", nil
+	case !slices.Contains(protocols, p):
+		return "", fmt.Errorf("unsupported http.protocol %q (want one of %s)", o.Protocol, strings.Join(protocols, ", "))
+	}
+	return p, nil
+}
+
 type clientKey struct {
 	Proxy              string
 	Timeout            time.Duration
@@ -20,14 +43,22 @@ type clientKey struct {
 	DisableH2          bool
 	FollowRedirects    bool
 	DisableCompression bool
+	Protocol           string // "" for HTTP/2 or HTTP/1.1 over TCP, or a transport of http.protocol
 }
 
 func (k clientKey) String() string {
-	return fmt.Sprintf("%s|%s|ik:%t|h2off:%t|redir:%t|nocomp:%t",
-		k.Proxy, k.Timeout.String(), k.Insecure, k.DisableH2, k.FollowRedirects, k.DisableCompression)
+	return fmt.Sprintf("%s|%s|ik:%t|h2off:%t|redir:%t|nocomp:%t|proto:%s",
+		k.Proxy, k.Timeout.String(), k.Insecure, k.DisableH2, k.FollowRedirects, k.DisableCompression, k.Protocol)
 }
 
 func (c *Client) getClientWithOpts(proxyURL string, timeout time.Duration, insecure, disableH2, followRedirects, skipDecompress bool) (*http.Client, error) {
+	return c.clientFor(proxyURL, proxyURL, timeout, insecure, disableH2, followRedirects, skipDecompress, "")
+}
+
+// clientFor returns the cached client for proxyURL, counting its traffic in
+// the stats of statsURL (the template of an expanded proxy URL). protocol is
+// a validated http.protocol.
+func (c *Client) clientFor(proxyURL, statsURL string, timeout time.Duration, insecure, disableH2, followRedirects, skipDecompress bool, protocol string) (*http.Client, error) {
 	key := clientKey{
 		Proxy:              proxyURL,
 		Timeout:            timeout,
@@ -35,12 +66,28 @@ func (c *Client) getClientWithOpts(proxyURL string, timeout time.Duration, insec
 		DisableH2:          disableH2,
 		FollowRedirects:    followRedirects,
 		DisableCompression: skipDecompress,
+		Protocol:           protocol,
 	}
 
 	if v, ok := c.clients.Load(key.String()); ok {
 		return v.(*http.Client), nil
 	}
 
+	switch protocol {
+	case protocolH3:
+		tr, err := c.newH3Transport(proxyURL, statsURL, insecure, skipDecompress)
+		if err != nil {
+			return nil, err
+		}
+		return c.storeClient(key, tr, timeout, followRedirects), nil
+	case protocolH2C:
+		tr, err := c.newH2CTransport(proxyURL, statsURL, insecure, skipDecompress)
+		if err != nil {
+			return nil, err
+		}
+		return c.storeClient(key, tr, timeout, followRedirects), nil
+	}
+
 	dialer := &net.Dialer{Timeout: 10 * time.Second}
 	tr := &http.Transport{
 		DialContext:         dialer.DialContext,
@@ -62,29 +109,45 @@ func (c *Client) getClientWithOpts(proxyURL string, timeout time.Duration, insec
 		}
 		switch strings.ToLower(u.Scheme) {
 		case "socks5", "socks5h", "socks4":
-			auth := proxy.Auth{User: u.User.Username()}
-			if pwd, ok := u.User.Password(); ok {
-				auth.Password = pwd
-			}
-			d, err := proxy.SOCKS5("tcp", u.Host, &auth, proxy.Direct)
+			dial, err := c.socksDial(u, statsURL)
 			if err != nil {
 				return nil, err
 			}
-			type contextDialer interface {
-				DialContext(ctx context.Context, network, addr string) (net.Conn, error)
-			}
-			if cd, ok := d.(contextDialer); ok {
-				tr.DialContext = cd.DialContext
-			} else {
-				tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return d.Dial(network, addr)
-				}
-			}
+			tr.DialContext = dial
 		default:
 			tr.Proxy = http.ProxyURL(u)
+			tr.DialContext = newProxyDialer(c.statsFor(statsURL)).DialContext
+			tr.OnProxyConnectResponse = onProxyConnectResponse
 		}
 	}
 
+	return c.storeClient(key, tr, timeout, followRedirects), nil
+}
+
+// socksDial returns the dial function of connections through the SOCKS
+// proxy u, counting its traffic in the stats of statsURL.
+func (c *Client) socksDial(u *url.URL, statsURL string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	auth := proxy.Auth{User: u.User.Username()}
+	if pwd, ok := u.User.Password(); ok {
+		auth.Password = pwd
+	}
+	d, err := proxy.SOCKS5("tcp", u.Host, &auth, newProxyDialer(c.statsFor(statsURL)))
+	if err != nil {
+		return nil, err
+	}
+	type contextDialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	}
+	if cd, ok := d.(contextDialer); ok {
+		return cd.DialContext, nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	}, nil
+}
+
+// storeClient caches the client of key over tr.
+func (c *Client) storeClient(key clientKey, tr http.RoundTripper, timeout time.Duration, followRedirects bool) *http.Client {
 	client := &http.Client{
 		Transport: tr,
 		Timeout:   timeout,
@@ -96,8 +159,43 @@ func (c *Client) getClientWithOpts(proxyURL string, timeout time.Duration, insec
 		},
 	}
 
-	c.clients.Store(key.String(), client)
-	return client, nil
+	k := key.String()
+	c.clients.Store(k, client)
+	v, _ := c.clientKeys.LoadOrStore(key.Proxy, &clientKeySet{})
+	v.(*clientKeySet).add(k)
+	return client
+}
+
+// clientKeySet holds the keys of the cached clients of one proxy URL, so an
+// identity can be dropped without scanning every cached client.
+type clientKeySet struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (s *clientKeySet) add(k string) {
+	s.mu.Lock()
+	if !slices.Contains(s.keys, k) {
+		s.keys = append(s.keys, k)
+	}
+	s.mu.Unlock()
+}
+
+// dropClients closes the idle connections of the clients of proxyURL and
+// forgets them; used for expanded identities that will not be used again.
+func (c *Client) dropClients(proxyURL string) {
+	v, ok := c.clientKeys.LoadAndDelete(proxyURL)
+	if !ok {
+		return
+	}
+	s := v.(*clientKeySet)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if cl, ok := c.clients.LoadAndDelete(k); ok {
+			cl.(*http.Client).CloseIdleConnections()
+		}
+	}
 }
 
 func (c *Client) getClient(proxyURL string, timeout time.Duration, insecure, disableH2, followRedirects bool) (*http.Client, error) {
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limitPollInterval is how often a request waiting for a saturated pool re-checks it.
+const limitPollInterval = 5 * time.Millisecond
+
+// proxyLimits are the per-proxy limits in effect for one pick; zero means unlimited.
+type proxyLimits struct {
+	maxInFlight int
+	maxRps      float64
+}
+
+// limits returns the global limits of the options.
+func (o ProxyOptions) limits() proxyLimits {
+	return proxyLimits{maxInFlight: o.MaxInFlight, maxRps: o.MaxRps}
+}
+
+// forEntry applies the entry's own limits over the global ones.
+func (l proxyLimits) forEntry(e *ProxyEntry) proxyLimits {
+	if e.MaxInFlight > 0 {
+		l.maxInFlight = e.MaxInFlight
+	}
+	if e.MaxRps > 0 {
+		l.maxRps = e.MaxRps
+	}
+	return l
+}
+
+// proxyLimiter tracks the requests running through one proxy and its request rate.
+type proxyLimiter struct {
+	inFlight atomic.Int64
+
+	mu   sync.Mutex
+	rate *rate.Limiter // created on first rate-limited use
+}
+
+func (c *Client) limiterFor(p string) *proxyLimiter {
+	if v, ok := c.proxyLimiters.Load(p); ok {
+		return v.(*proxyLimiter)
+	}
+	v, _ := c.proxyLimiters.LoadOrStore(p, &proxyLimiter{})
+	return v.(*proxyLimiter)
+}
+
+// reserve takes an in-flight slot and, with maxRps, a rate token (burst is the
+// rate rounded down, at least 1). ok is false when either is exhausted; the
+// reservation can be undone if the proxy ends up not being used.
+func (l *proxyLimiter) reserve(lim proxyLimits, now time.Time) (r *rate.Reservation, ok bool) {
+	if n := l.inFlight.Add(1); lim.maxInFlight > 0 && n > int64(lim.maxInFlight) {
+		l.inFlight.Add(-1)
+		return nil, false
+	}
+	if lim.maxRps <= 0 {
+		return nil, true
+	}
+	l.mu.Lock()
+	burst := max(1, int(lim.maxRps))
+	if l.rate == nil {
+		l.rate = rate.NewLimiter(rate.Limit(lim.maxRps), burst)
+	} else if l.rate.Limit() != rate.Limit(lim.maxRps) || l.rate.Burst() != burst {
+		l.rate.SetLimitAt(now, rate.Limit(lim.maxRps))
+		l.rate.SetBurstAt(now, burst)
+	}
+	r = l.rate.ReserveN(now, 1)
+	l.mu.Unlock()
+	if !r.OK() || r.DelayFrom(now) > 0 {
+		r.CancelAt(now)
+		l.inFlight.Add(-1)
+		return nil, false
+	}
+	return r, true
+}
+
+// undo returns a reservation that was not used.
+func (l *proxyLimiter) undo(r *rate.Reservation, now time.Time) {
+	if r != nil {
+		r.CancelAt(now)
+	}
+	l.inFlight.Add(-1)
+}
+
+func (l *proxyLimiter) release() {
+	l.inFlight.Add(-1)
+}
+
+// acquireProxy picks the next proxy of the pool that is healthy and under its
+// limits. When candidates are skipped only because they are saturated, it
+// re-checks until LimitWait (or the request's context) runs out; when none is
+// available at all, it re-checks for up to exhaustedWait. saturated reports a
+// pick that failed because of limits rather than health.
+func (c *Client) acquireProxy(ctx context.Context, pl *proxyPool, o ProxyOptions, exclude []string, exhaustedWait time.Duration) (e *ProxyEntry, release func(), saturated bool) {
+	limits := o.limits()
+	start := time.Now()
+	var limitDeadline time.Time
+	if d, err := time.ParseDuration(o.LimitWait); err == nil && d > 0 {
+		limitDeadline = start.Add(d)
+	}
+	exhaustedDeadline := start.Add(exhaustedWait)
+	for {
+		e, release, saturated = c.nextProxy(pl, o.Filter, exclude, limits)
+		deadline, poll := exhaustedDeadline, exhaustedPollInterval
+		if saturated {
+			deadline, poll = limitDeadline, limitPollInterval
+		}
+		if e != nil || !time.Now().Before(deadline) {
+			return e, release, saturated
+		}
+		t := time.NewTimer(min(poll, time.Until(deadline)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, release, saturated
+		case <-t.C:
+		}
+	}
+}
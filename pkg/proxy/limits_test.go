@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Given a proxy limited to one request in flight by list metadata
+// When it is picked and its slot is still held
+// Then the next picks skip it until the slot is released
+func TestNextProxy_GivenMaxInFlightMeta_WhenSaturated_ThenSkipped(t *testing.T) {
+	t.Parallel()
+	c := &Client{}
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080#maxInFlight=1", "socks5://b:1080"})
+	if err := c.LoadProxyList(path); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	a, releaseA, _ := c.nextProxy(&c.pool, nil, nil, proxyLimits{})
+	if a == nil || a.URL != "socks5://a:1080" {
+		t.Fatalf("first pick=%+v", a)
+	}
+	for i := 0; i < 3; i++ {
+		if e, release, _ := c.nextProxy(&c.pool, nil, nil, proxyLimits{}); e == nil || e.URL != "socks5://b:1080" {
+			t.Fatalf("pick %d=%+v, want b while a is busy", i, e)
+		} else {
+			release()
+		}
+	}
+	if e, _, saturated := c.nextProxy(&c.pool, nil, []string{"socks5://b:1080"}, proxyLimits{}); e != nil || !saturated {
+		t.Fatalf("expected saturation, got %+v saturated=%t", e, saturated)
+	}
+	releaseA()
+	if e, _, _ := c.nextProxy(&c.pool, nil, []string{"socks5://b:1080"}, proxyLimits{}); e == nil || e.URL != "socks5://a:1080" {
+		t.Fatalf("a not available after release: %+v", e)
+	}
+}
+
+// Given the only proxy is at its maxInFlight and then ejected
+// When a pick is made
+// Then the pool is exhausted rather than saturated, and no slot is reserved
+func TestNextProxy_GivenSaturatedAndEjected_WhenPicked_ThenNotSaturated(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080#maxInFlight=1"})
+	if err := c.LoadProxyList(path); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	a, releaseA, _ := c.nextProxy(&c.pool, nil, nil, proxyLimits{})
+	if a == nil {
+		t.Fatal("first pick failed")
+	}
+	c.markBadProxy(&c.pool, a.URL)
+	if e, _, saturated := c.nextProxy(&c.pool, nil, nil, proxyLimits{}); e != nil || saturated {
+		t.Fatalf("pick=%+v saturated=%t, want exhausted", e, saturated)
+	}
+	releaseA()
+	if e, _, saturated := c.nextProxy(&c.pool, nil, nil, proxyLimits{}); e != nil || saturated {
+		t.Fatalf("pick=%+v saturated=%t after release", e, saturated)
+	}
+	if n := c.limiterFor(a.URL).inFlight.Load(); n != 0 {
+		t.Fatalf("in flight=%d, ejected proxy reserved a slot", n)
+	}
+}
+
+// Given a global maxRps of 2
+// When GetNextProxy is called in a burst
+// Then the single proxy is handed out twice and then skipped
+func TestGetNextProxy_GivenGlobalMaxRps_WhenBurst_ThenLimited(t *testing.T) {
+	t.Parallel()
+	c := NewClient()
+	c.Configure(map[string]any{"proxy": map[string]any{"maxRps": int64(2)}})
+	if err := c.LoadProxyList(writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080"})); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	got := []string{c.GetNextProxy(), c.GetNextProxy(), c.GetNextProxy()}
+	if got[0] == "" || got[1] == "" || got[2] != "" {
+		t.Fatalf("picks=%q", got)
+	}
+}
+
+// Given one SOCKS proxy with maxInFlight=1 and a slow target
+// When two requests run concurrently
+// Then without limitWait one fails as pool_saturated, with limitWait both succeed
+func TestRequest_GivenMaxInFlight_WhenConcurrent_ThenSaturatedOrWaits(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+	socks := startFakeSOCKS5(t, nil)
+	path := writeProxiesFile(t, t.TempDir(), []string{socks.URL()})
+
+	run := func(limitWait string) []Response {
+		c := newHealthClient()
+		out := make([]Response, 2)
+		var wg sync.WaitGroup
+		for i := range out {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v, _ := c.Request(map[string]any{
+					"url":   ts.URL,
+					"proxy": map[string]any{"listPath": path, "maxInFlight": int64(1), "limitWait": limitWait},
+				})
+				out[i], _ = v.(Response)
+			}()
+			time.Sleep(20 * time.Millisecond) // let the first one take the slot
+		}
+		wg.Wait()
+		return out
+	}
+
+	out := run("")
+	if out[0].Status != http.StatusOK || out[1].ErrorClass != ErrClassPoolSaturated || out[1].Attempts != 0 {
+		t.Fatalf("without wait: first=%d/%s second=%q/%d", out[0].Status, out[0].Error, out[1].ErrorClass, out[1].Attempts)
+	}
+	out = run("2s")
+	if out[0].Status != http.StatusOK || out[1].Status != http.StatusOK {
+		t.Fatalf("with wait: first=%d/%s second=%d/%s", out[0].Status, out[0].Error, out[1].Status, out[1].Error)
+	}
+}
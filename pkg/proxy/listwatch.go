@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultListReloadInterval = 2 * time.Second
+
+// List kinds, used as the "list" tag of the reload metrics.
+const (
+	listKindProxy     = "proxy"
+	listKindUserAgent = "user_agent"
+	listKindReferer   = "referer"
+)
+
+// listReloads counts snapshot swaps per list kind until they are reported as metrics.
+type listReloads struct {
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+func (c *Client) recordListReload(kind string) {
+	c.listReloads.mu.Lock()
+	defer c.listReloads.mu.Unlock()
+	if c.listReloads.pending == nil {
+		c.listReloads.pending = map[string]int{}
+	}
+	c.listReloads.pending[kind]++
+}
+
+// takeListReloads returns the reloads recorded since the last call and resets them.
+func (c *Client) takeListReloads() map[string]int {
+	c.listReloads.mu.Lock()
+	defer c.listReloads.mu.Unlock()
+	out := c.listReloads.pending
+	c.listReloads.pending = nil
+	return out
+}
+
+// listSize returns the number of entries in the current snapshot of a list kind.
+func (c *Client) listSize(kind string) int {
+	switch kind {
+	case listKindProxy:
+		n := 0
+		for _, pl := range c.allPools() {
+			if l := pl.snapshot(); l != nil {
+				n += len(l.entries)
+			}
+		}
+		return n
+	case listKindUserAgent:
+		return len(c.getUASlice())
+	case listKindReferer:
+		return len(c.getRefererSlice())
+	}
+	return 0
+}
+
+// listWatcher polls the loaded list files on a ticker. It runs once a list
+// file was loaded and while the reload interval is positive.
+type listWatcher struct {
+	mu       sync.Mutex
+	interval time.Duration      // as set; 0 is the default, negative disables
+	wanted   bool               // a list file was loaded
+	every    time.Duration      // interval of the running goroutine
+	stop     context.CancelFunc // stops the running goroutine; nil when none runs
+}
+
+// SetListReloadInterval sets how often loaded list files are checked for
+// changes. Zero or a negative value disables the checks and stops the watcher.
+func (c *Client) SetListReloadInterval(d time.Duration) {
+	if d <= 0 {
+		d = -1
+	}
+	w := &c.listWatch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.interval = d
+	c.restartListWatcher()
+}
+
+func (c *Client) listReloadInterval() time.Duration {
+	c.listWatch.mu.Lock()
+	defer c.listWatch.mu.Unlock()
+	return c.listWatch.effective()
+}
+
+func (w *listWatcher) effective() time.Duration {
+	switch {
+	case w.interval == 0:
+		return defaultListReloadInterval
+	case w.interval < 0:
+		return 0
+	default:
+		return w.interval
+	}
+}
+
+// startListWatcher starts polling the loaded list files, unless it already
+// runs or reloading is disabled.
+func (c *Client) startListWatcher() {
+	c.listWatch.mu.Lock()
+	defer c.listWatch.mu.Unlock()
+	c.listWatch.wanted = true
+	c.restartListWatcher()
+}
+
+// restartListWatcher brings the watcher in line with the interval: stopped
+// when disabled, restarted when the interval changed. Callers hold listWatch.mu.
+func (c *Client) restartListWatcher() {
+	w := &c.listWatch
+	d := w.effective()
+	if w.stop != nil && w.every == d {
+		return
+	}
+	if w.stop != nil {
+		w.stop()
+		w.stop = nil
+	}
+	if !w.wanted || d <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.stop, w.every = cancel, d
+	go c.watchLists(ctx, d)
+}
+
+func (c *Client) watchLists(ctx context.Context, d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.reloadChangedLists()
+		}
+	}
+}
+
+// reloadChangedLists reloads every loaded list file whose mtime moved. Each
+// loader swaps its snapshot only if the content differs; read errors (e.g. a
+// file being replaced) keep the current snapshot until the next check.
+func (c *Client) reloadChangedLists() {
+	for _, pl := range c.allPools() {
+		pl.mu.Lock()
+		if !pl.mtime.IsZero() {
+			_, _ = c.loadProxyFile(pl, pl.currentPath(), pl.opts)
+		}
+		pl.mu.Unlock()
+	}
+
+	c.listMu.Lock()
+	defer c.listMu.Unlock()
+	if !c.uaListMTime.IsZero() {
+		_ = c.loadUserAgents(c.uaListPath)
+	}
+	if !c.refererListMTime.IsZero() {
+		_ = c.loadReferers(c.refererListPath)
+	}
+}
+
+// userAgentsLoaded reports whether path is the UA list currently in use. It
+// takes no lock, as every request with randomUserAgent asks.
+func (c *Client) userAgentsLoaded(path string) bool {
+	p, _ := c.uaLoadedPath.Load().(string)
+	return p == path && c.uaListVal.Load() != nil
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// bumpMTime moves a file's mtime forward so the change is seen regardless of timestamp granularity.
+func bumpMTime(t *testing.T, path string) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	next := fi.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, next, next); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+}
+
+func (c *Client) listWatcherRunning() bool {
+	c.listWatch.mu.Lock()
+	defer c.listWatch.mu.Unlock()
+	return c.listWatch.stop != nil
+}
+
+// Given loaded proxy and UA files and a short reload interval
+// When both files are edited
+// Then the watcher swaps the snapshots without any further load call
+func TestListWatcher_GivenEditedFiles_WhenInterval_ThenSnapshotsSwapped(t *testing.T) {
+	t.Parallel()
+	c := &Client{}
+	c.SetListReloadInterval(10 * time.Millisecond)
+	proxies := writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080"})
+	uas := writeListFile(t, "ua.txt", "UA-1\n")
+	if err := c.LoadProxyList(proxies); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	if err := c.LoadUserAgents(uas); err != nil {
+		t.Fatalf("LoadUserAgents: %v", err)
+	}
+
+	if err := os.WriteFile(proxies, []byte("socks5://b:1080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bumpMTime(t, proxies)
+	if err := os.WriteFile(uas, []byte("UA-2\nUA-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bumpMTime(t, uas)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.GetNextProxy() != "socks5://b:1080" || !slices.Equal(c.getUASlice(), []string{"UA-2", "UA-3"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("lists not reloaded: proxy=%q ua=%v", c.GetNextProxy(), c.getUASlice())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if r := c.takeListReloads(); r[listKindProxy] != 2 || r[listKindUserAgent] != 2 {
+		t.Fatalf("reloads=%v", r)
+	}
+}
+
+// Given a running list watcher
+// When reloading is disabled and a list file is loaded or edited
+// Then the watcher is stopped, not restarted, and the edit is not picked up
+func TestListWatcher_GivenDisabled_WhenLoaded_ThenStoppedAndNotStarted(t *testing.T) {
+	t.Parallel()
+	c := &Client{}
+	c.SetListReloadInterval(10 * time.Millisecond)
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080"})
+	if err := c.LoadProxyList(path); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	if !c.listWatcherRunning() {
+		t.Fatalf("watcher not started by the load")
+	}
+
+	c.SetListReloadInterval(0)
+	if c.listWatcherRunning() {
+		t.Fatalf("watcher still running after reloading was disabled")
+	}
+	if err := c.LoadUserAgents(writeListFile(t, "ua.txt", "UA-1\n")); err != nil {
+		t.Fatalf("LoadUserAgents: %v", err)
+	}
+	if c.listWatcherRunning() {
+		t.Fatalf("watcher started while reloading is disabled")
+	}
+	if err := os.WriteFile(path, []byte("socks5://b:1080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bumpMTime(t, path)
+	time.Sleep(50 * time.Millisecond)
+	if got := c.GetNextProxy(); got != "socks5://a:1080" {
+		t.Fatalf("edit picked up with reloading disabled: %q", got)
+	}
+
+	c.SetListReloadInterval(10 * time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for c.GetNextProxy() != "socks5://b:1080" {
+		if time.Now().After(deadline) {
+			t.Fatalf("watcher not restarted when reloading was enabled again")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.SetListReloadInterval(0)
+}
+
+// Given a loaded proxy list
+// When the file is touched without changing its content
+// Then the snapshot (and its cached filter views) is kept and no reload is counted
+func TestLoadProxyList_GivenTouchedUnchangedFile_WhenReload_ThenSnapshotKept(t *testing.T) {
+	t.Parallel()
+	c := &Client{}
+	c.SetListReloadInterval(0)
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080", "socks5://b:1080"})
+	if err := c.LoadProxyList(path); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	before := c.proxySnapshot()
+	bumpMTime(t, path)
+	if err := c.LoadProxyList(path); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	if c.proxySnapshot() != before {
+		t.Fatalf("snapshot swapped for unchanged content")
+	}
+	if r := c.takeListReloads(); r[listKindProxy] != 1 {
+		t.Fatalf("reloads=%v want 1", r)
+	}
+}
+
+// Given a proxy list loaded in the init context
+// When a VU runs requests
+// Then the first one reports the reload and list size as metrics without its
+// own tags, later ones report nothing new
+func TestRequest_GivenListReload_WhenVURequests_ThenReloadMetricsPushedOnce(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+	mi, vu, _ := newTestModule(t)
+	mi.client.SetListReloadInterval(0)
+	if _, err := mi.loadProxyList(writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080", "b:1080"}), nil); err != nil {
+		t.Fatalf("loadProxyList: %v", err)
+	}
+	samples := vu.enterVU()
+	vu.state.Tags.Modify(func(t *metrics.TagsAndMeta) { t.SetTag("scenario", "first") })
+
+	params := map[string]any{"url": ts.URL, "proxy": map[string]any{"disable": true}}
+	if _, err := mi.request(params); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	got := drainSamples(samples)
+	reloads, sizes := got["proxy_list_reloads"], got["proxy_list_size"]
+	if len(reloads) != 1 || reloads[0].Value != 1 || len(sizes) != 1 || sizes[0].Value != 2 {
+		t.Fatalf("samples=%v", got)
+	}
+	if kind, _ := sizes[0].Tags.Get("list"); kind != listKindProxy {
+		t.Fatalf("list tag=%q", kind)
+	}
+	if _, ok := reloads[0].Tags.Get("scenario"); ok {
+		t.Fatalf("reload attributed to the reporting VU: %v", reloads[0].Tags.Map())
+	}
+
+	if _, err := mi.request(params); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if got := drainSamples(samples); len(got) != 0 {
+		t.Fatalf("unexpected samples: %v", got)
+	}
+}
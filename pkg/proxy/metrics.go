@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"time"
+
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/metrics"
+)
+
+// moduleMetrics are the custom k6 metrics emitted by the module.
+type moduleMetrics struct {
+	listReloads *metrics.Metric // proxy_list_reloads{list}: snapshot swaps of a list
+	listSize    *metrics.Metric // proxy_list_size{list}: entries after the last swap
+	retries     *metrics.Metric // proxy_request_retries{kind,reason}: attempts followed by a retry or failover
+	decoded     *metrics.Metric // proxy_body_decoded_bytes{encoding}: response body bytes after decoding
+	encoded     *metrics.Metric // proxy_body_encoded_bytes{encoding}: the same bodies before decoding
+
+	// root tags of the list metrics, which describe the Client rather than the VU reporting them
+	root *metrics.TagSet
+}
+
+// registerMetrics registers the module metrics. It is a no-op outside the init context.
+func registerMetrics(vu modules.VU) moduleMetrics {
+	env := vu.InitEnv()
+	if env == nil || env.Registry == nil {
+		return moduleMetrics{}
+	}
+	return moduleMetrics{
+		listReloads: env.Registry.MustNewMetric("proxy_list_reloads", metrics.Counter),
+		listSize:    env.Registry.MustNewMetric("proxy_list_size", metrics.Gauge),
+		retries:     env.Registry.MustNewMetric("proxy_request_retries", metrics.Counter),
+		decoded:     env.Registry.MustNewMetric("proxy_body_decoded_bytes", metrics.Counter, metrics.Data),
+		encoded:     env.Registry.MustNewMetric("proxy_body_encoded_bytes", metrics.Counter, metrics.Data),
+		root:        env.Registry.RootTagSet(),
+	}
+}
+
+// pushListMetrics reports the list reloads that happened since the last push,
+// from any VU, together with the current size of each reloaded list. Reloads
+// belong to the Client shared by all VUs, so the samples carry only the list
+// tag, not the tags of the VU that happens to push them.
+func (mi *ModuleInstance) pushListMetrics() {
+	if mi.metrics.listReloads == nil {
+		return
+	}
+	state := mi.vu.State()
+	if state == nil {
+		return
+	}
+	reloads := mi.client.takeListReloads()
+	if len(reloads) == 0 {
+		return
+	}
+	now := time.Now()
+	samples := make(metrics.Samples, 0, 2*len(reloads))
+	for kind, n := range reloads {
+		t := mi.metrics.root.With("list", kind)
+		samples = append(samples,
+			metrics.Sample{TimeSeries: metrics.TimeSeries{Metric: mi.metrics.listReloads, Tags: t}, Time: now, Value: float64(n)},
+			metrics.Sample{TimeSeries: metrics.TimeSeries{Metric: mi.metrics.listSize, Tags: t}, Time: now, Value: float64(mi.client.listSize(kind))},
+		)
+	}
+	metrics.PushIfNotDone(mi.vu.Context(), state.Samples, samples)
+}
+
+// pushRetryMetrics reports each attempt of resp that was followed by a target
+// retry or a proxy failover, tagged with what followed and why.
+func (mi *ModuleInstance) pushRetryMetrics(resp any) {
+	r, ok := resp.(Response)
+	if !ok || len(r.Tries) == 0 || mi.metrics.retries == nil {
+		return
+	}
+	state := mi.vu.State()
+	if state == nil {
+		return
+	}
+	now := time.Now()
+	tags := state.Tags.GetCurrentValues().Tags
+	samples := make(metrics.Samples, 0, len(r.Tries)-1)
+	for _, a := range r.Tries {
+		if a.Next == "" {
+			continue
+		}
+		samples = append(samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{Metric: mi.metrics.retries, Tags: tags.With("kind", a.Next).With("reason", a.Reason)},
+			Time:       now,
+			Value:      1,
+		})
+	}
+	metrics.PushIfNotDone(mi.vu.Context(), state.Samples, samples)
+}
+
+// pushDecodeMetrics reports the sizes of a response body decoded from a
+// content coding. The encoded size is unknown when the Transport decoded gzip.
+func (mi *ModuleInstance) pushDecodeMetrics(resp any) {
+	r, ok := resp.(Response)
+	if !ok || r.ContentEncoding == "" || mi.metrics.decoded == nil {
+		return
+	}
+	state := mi.vu.State()
+	if state == nil {
+		return
+	}
+	now := time.Now()
+	tags := state.Tags.GetCurrentValues().Tags.With("encoding", r.ContentEncoding)
+	samples := metrics.Samples{
+		{TimeSeries: metrics.TimeSeries{Metric: mi.metrics.decoded, Tags: tags}, Time: now, Value: float64(r.BodySize)},
+	}
+	if r.EncodedSize > 0 {
+		samples = append(samples, metrics.Sample{TimeSeries: metrics.TimeSeries{Metric: mi.metrics.encoded, Tags: tags}, Time: now, Value: float64(r.EncodedSize)})
+	}
+	metrics.PushIfNotDone(mi.vu.Context(), state.Samples, samples)
+}
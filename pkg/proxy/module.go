@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"go.k6.io/k6/errext"
+	"go.k6.io/k6/js/modules"
+)
+
+// RootModule is the global module registered with k6. All VUs share its Client,
+// so proxy lists, health state and cached transports are process-wide.
+type RootModule struct {
+	client *Client
+}
+
+// ModuleInstance is the per-VU view of the module.
+type ModuleInstance struct {
+	vu       modules.VU
+	client   *Client
+	metrics  moduleMetrics
+	sessions sessionStore // sticky sessions of templated proxy URLs
+	cookies  cookieJar    // cookie jar of http.cookies "vu"
+}
+
+var (
+	_ modules.Module   = &RootModule{}
+	_ modules.Instance = &ModuleInstance{}
+)
+
+// New returns the root module backed by a fresh Client.
+func New() *RootModule {
+	return &RootModule{client: NewClient()}
+}
+
+// NewModuleInstance implements modules.Module.
+func (r *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
+	return &ModuleInstance{vu: vu, client: r.client, metrics: registerMetrics(vu)}
+}
+
+// Exports implements modules.Instance.
+func (mi *ModuleInstance) Exports() modules.Exports {
+	c := mi.client
+	return modules.Exports{
+		Named: map[string]any{
+			"request":                mi.request,
+			"loadProxyList":          mi.loadProxyList,
+			"getNextProxy":           c.GetNextProxy,
+			"getProxyStats":          c.GetProxyStats,
+			"addProxy":               mi.addProxy,
+			"removeProxy":            mi.removeProxy,
+			"setProxies":             mi.setProxies,
+			"markProxyBad":           mi.markProxyBad,
+			"markProxyGood":          mi.markProxyGood,
+			"listProxies":            mi.listProxies,
+			"saveProxyState":         c.SaveProxyState,
+			"loadProxyState":         c.LoadProxyState,
+			"exportProxies":          mi.exportProxies,
+			"exportProxyReport":      c.ExportProxyReport,
+			"probeExitIPs":           mi.probeExitIPs,
+			"getCookies":             mi.getCookies,
+			"setCookie":              mi.setCookie,
+			"clearCookies":           mi.clearCookies,
+			"exportCookies":          mi.exportCookies,
+			"importCookies":          mi.importCookies,
+			"loadUserAgents":         c.LoadUserAgents,
+			"configure":              c.Configure,
+			"defaultConfig":          c.DefaultConfig,
+			"preview":                c.Preview,
+			"getRandomUserAgent":     c.GetRandomUserAgent,
+			"loadReferers":           c.LoadReferers,
+			"getRandomReferer":       c.GetRandomReferer,
+			"getRandomPath":          c.GetRandomPath,
+			"getRandomPathWithQuery": c.GetRandomPathWithQuery,
+		},
+	}
+}
+
+// request wraps Client.Request and reports the pending list metrics from this
+// VU. An exhausted pool with onExhausted "abort" aborts the test like test.abort().
+func (mi *ModuleInstance) request(raw any) (any, error) {
+	resp, err := mi.client.request(raw, mi.vuContext())
+	mi.pushListMetrics()
+	mi.pushRetryMetrics(resp)
+	mi.pushDecodeMetrics(resp)
+	if errors.Is(err, ErrPoolExhausted) {
+		if rt := mi.vu.Runtime(); rt != nil {
+			rt.Interrupt(&errext.InterruptError{Reason: errext.AbortTest + ": " + err.Error()})
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+// vuContext identifies this VU and its current iteration for proxy URL templates.
+func (mi *ModuleInstance) vuContext() *vuContext {
+	vc := &vuContext{iteration: -1, sessions: &mi.sessions, cookies: &mi.cookies}
+	if state := mi.vu.State(); state != nil {
+		vc.id = state.VUID
+		vc.iteration = state.Iteration
+	}
+	return vc
+}
+
+// loadProxyList is the JS entry point: loadProxyList(path, {name, strategy, health, defaultScheme, refreshInterval, headers, fieldPath})
+// returns a load summary. path may be a file or an http(s) URL.
+func (mi *ModuleInstance) loadProxyList(path string, raw any) (ProxyListSummary, error) {
+	var opts ProxyListOptions
+	if raw != nil {
+		m, err := asMap(raw)
+		if err != nil {
+			return ProxyListSummary{}, err
+		}
+		decodeProxyListOptions(m, &opts)
+	}
+	return mi.client.LoadProxyListWithOptions(path, opts)
+}
+
+// poolControlOptions decodes the trailing {pool, healthy} argument of the pool control functions.
+func poolControlOptions(raw any) (pool string, healthy bool, err error) {
+	if raw == nil {
+		return "", false, nil
+	}
+	m, err := asMap(raw)
+	if err != nil {
+		return "", false, err
+	}
+	if v, ok := m["pool"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			pool = s
+		}
+	}
+	if v, ok := m["healthy"]; ok && v != nil {
+		if b, ok := asBool(v); ok {
+			healthy = b
+		}
+	}
+	return pool, healthy, nil
+}
+
+// addProxy is the JS entry point: addProxy(url, {country, provider, tags, weight, maxInFlight, maxRps}, {pool}).
+func (mi *ModuleInstance) addProxy(url string, meta, opts any) error {
+	pool, _, err := poolControlOptions(opts)
+	if err != nil {
+		return err
+	}
+	m := map[string]any{}
+	if meta != nil {
+		mm, err := asMap(meta)
+		if err != nil {
+			return err
+		}
+		maps.Copy(m, mm)
+	}
+	m["url"] = url
+	e, _ := entryFromAny(m)
+	return mi.client.AddProxy(pool, *e)
+}
+
+// removeProxy is the JS entry point: removeProxy(url, {pool}) returns whether the proxy was in the pool.
+func (mi *ModuleInstance) removeProxy(url string, opts any) (bool, error) {
+	pool, _, err := poolControlOptions(opts)
+	if err != nil {
+		return false, err
+	}
+	return mi.client.RemoveProxy(pool, url)
+}
+
+// setProxies is the JS entry point: setProxies([url or {url, ...meta}], {pool}) returns a load summary.
+func (mi *ModuleInstance) setProxies(list []any, opts any) (ProxyListSummary, error) {
+	pool, _, err := poolControlOptions(opts)
+	if err != nil {
+		return ProxyListSummary{}, err
+	}
+	entries := make([]ProxyEntry, len(list))
+	for i, v := range list {
+		if e, ok := entryFromAny(v); ok {
+			entries[i] = *e
+		}
+	}
+	return mi.client.SetProxies(pool, entries)
+}
+
+// markProxyBad is the JS entry point: markProxyBad(url, ttl, {pool}); an empty ttl uses the health policy.
+func (mi *ModuleInstance) markProxyBad(url string, ttl any, opts any) error {
+	pool, _, err := poolControlOptions(opts)
+	if err != nil {
+		return err
+	}
+	var d time.Duration
+	if s, _ := asString(ttl); ttl != nil && s != "" {
+		if d, err = time.ParseDuration(s); err != nil || d < 0 {
+			return fmt.Errorf("invalid ttl %q", s)
+		}
+	}
+	return mi.client.MarkProxyBad(pool, url, d)
+}
+
+// markProxyGood is the JS entry point: markProxyGood(url, {pool}).
+func (mi *ModuleInstance) markProxyGood(url string, opts any) error {
+	pool, _, err := poolControlOptions(opts)
+	if err != nil {
+		return err
+	}
+	return mi.client.MarkProxyGood(pool, url)
+}
+
+// listProxies is the JS entry point: listProxies({pool, healthy}).
+func (mi *ModuleInstance) listProxies(opts any) ([]ProxyEntry, error) {
+	pool, healthy, err := poolControlOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return mi.client.ListProxies(pool, healthy)
+}
+
+// exportProxies is the JS entry point: exportProxies(path, {pool, sort, minSuccessRate, includeEjected}).
+func (mi *ModuleInstance) exportProxies(path string, raw any) (int, error) {
+	var opts ExportProxiesOptions
+	if raw != nil {
+		m, err := asMap(raw)
+		if err != nil {
+			return 0, err
+		}
+		decodeExportProxiesOptions(m, &opts)
+	}
+	return mi.client.ExportProxies(path, opts)
+}
+
+// probeExitIPs is the JS entry point: probeExitIPs({echoURL, pool, timeout, concurrency, insecureSkipVerify}).
+func (mi *ModuleInstance) probeExitIPs(raw any) (ExitIPReport, error) {
+	var opts ExitIPProbeOptions
+	if raw != nil {
+		m, err := asMap(raw)
+		if err != nil {
+			return ExitIPReport{}, err
+		}
+		decodeExitIPProbeOptions(m, &opts)
+	}
+	return mi.client.ProbeExitIPs(opts)
+}
+
+// cookieScopeOptions decodes the trailing {jar, proxy} argument of the cookie functions.
+func cookieScopeOptions(raw any) (CookieScope, error) {
+	var scope CookieScope
+	if raw == nil {
+		return scope, nil
+	}
+	m, err := asMap(raw)
+	if err != nil {
+		return scope, err
+	}
+	if v, ok := m["jar"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			scope.Jar = s
+		}
+	}
+	if v, ok := m["proxy"]; ok && v != nil {
+		if s, ok := asString(v); ok {
+			scope.Proxy = s
+		}
+	}
+	return scope, nil
+}
+
+// getCookies is the JS entry point: getCookies(url, {jar, proxy}); every cookie of the jar when url is empty.
+func (mi *ModuleInstance) getCookies(url string, opts any) ([]Cookie, error) {
+	scope, err := cookieScopeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return mi.client.getCookies(mi.vuContext(), url, scope)
+}
+
+// setCookie is the JS entry point: setCookie(url, {name, value, domain, path, expires, maxAge, secure, httpOnly}, {jar, proxy}).
+func (mi *ModuleInstance) setCookie(url string, raw, opts any) error {
+	scope, err := cookieScopeOptions(opts)
+	if err != nil {
+		return err
+	}
+	m, err := asMap(raw)
+	if err != nil {
+		return err
+	}
+	var ck Cookie
+	decodeCookie(m, &ck)
+	return mi.client.setCookie(mi.vuContext(), url, ck, scope)
+}
+
+// clearCookies is the JS entry point: clearCookies({jar, proxy}).
+func (mi *ModuleInstance) clearCookies(opts any) error {
+	scope, err := cookieScopeOptions(opts)
+	if err != nil {
+		return err
+	}
+	return mi.client.clearCookies(mi.vuContext(), scope)
+}
+
+// exportCookies is the JS entry point: exportCookies(path, {jar, proxy}) writes a Netscape cookies.txt file.
+func (mi *ModuleInstance) exportCookies(path string, opts any) (int, error) {
+	scope, err := cookieScopeOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+	return mi.client.exportCookies(mi.vuContext(), path, scope)
+}
+
+// importCookies is the JS entry point: importCookies(path, {jar, proxy}) reads a Netscape cookies.txt file.
+func (mi *ModuleInstance) importCookies(path string, opts any) (int, error) {
+	scope, err := cookieScopeOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+	return mi.client.importCookies(mi.vuContext(), path, scope)
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+)
+
+// fakeVU is a minimal modules.VU: init context until enterVU is called.
+type fakeVU struct {
+	ctx     context.Context
+	initEnv *common.InitEnvironment
+	state   *lib.State
+	rt      *sobek.Runtime
+}
+
+func (v *fakeVU) Context() context.Context               { return v.ctx }
+func (v *fakeVU) Events() common.Events                  { return common.Events{} }
+func (v *fakeVU) InitEnv() *common.InitEnvironment       { return v.initEnv }
+func (v *fakeVU) State() *lib.State                      { return v.state }
+func (v *fakeVU) Runtime() *sobek.Runtime                { return v.rt }
+func (v *fakeVU) RegisterCallback() func(f func() error) { return func(func() error) {} }
+
+// newTestModule instantiates the module for one VU in the init context.
+func newTestModule(t *testing.T) (*ModuleInstance, *fakeVU, *metrics.Registry) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	registry := metrics.NewRegistry()
+	vu := &fakeVU{ctx: ctx, initEnv: &common.InitEnvironment{TestPreInitState: &lib.TestPreInitState{Registry: registry}}}
+	mi := New().NewModuleInstance(vu).(*ModuleInstance)
+	return mi, vu, registry
+}
+
+// enterVU moves the VU out of the init context and returns its sample channel.
+func (v *fakeVU) enterVU() chan metrics.SampleContainer {
+	samples := make(chan metrics.SampleContainer, 100)
+	v.initEnv = nil
+	v.state = &lib.State{Samples: samples, Tags: lib.NewVUStateTags(metrics.NewRegistry().RootTagSet())}
+	return samples
+}
+
+// drainSamples returns the samples pushed so far, grouped by metric name.
+func drainSamples(ch chan metrics.SampleContainer) map[string][]metrics.Sample {
+	out := map[string][]metrics.Sample{}
+	for {
+		select {
+		case sc := <-ch:
+			for _, s := range sc.GetSamples() {
+				out[s.Metric.Name] = append(out[s.Metric.Name], s)
+			}
+		default:
+			return out
+		}
+	}
+}
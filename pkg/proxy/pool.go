@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultPoolName = "default"
+
+// Rotation strategies of a pool.
+const (
+	strategyRoundRobin    = "round_robin"    // weighted round-robin (default)
+	strategyRandom        = "random"         // weighted random start, then the next healthy entry
+	strategyLeastInFlight = "least_inflight" // entry with the fewest running requests, then the next healthy entry
+)
+
+var poolStrategies = []string{strategyRoundRobin, strategyRandom, strategyLeastInFlight}
+
+// proxyPool is one proxy list with its own rotation cursor, health state and
+// strategy. The unnamed pool lives in Client.pool; named pools are created by
+// loadProxyList(path, {name}) and selected with request({proxy: {pool}}).
+type proxyPool struct {
+	name     string
+	listVal  atomic.Value // holds *proxyList
+	rr       atomic.Uint64
+	health   sync.Map // map[string]*proxyHealth
+	settings atomic.Pointer[poolSettings]
+	// filter key ("" without one) -> *atomic.Int64, unix nanos of the first
+	// pick that found no proxy, 0 while picks succeed
+	exhausted sync.Map
+
+	// path of the list in use, written under mu and read lock-free by requests
+	path atomic.Value // string
+
+	mu      sync.Mutex // serializes list loads and background refreshes; guards the fields below
+	mtime   time.Time
+	sum     [32]byte // sha256 of the loaded file, to skip no-op reloads
+	opts    ProxyListOptions
+	summary ProxyListSummary // summary of the last load, returned when the file is unchanged
+	stop    context.CancelFunc
+	loading *remoteLoad // remote list fetch in progress, if any
+
+	probeMu   sync.Mutex
+	exitProbe *exitProbe // load-time exit IP probe of the current list, guarded by probeMu
+}
+
+// poolSettings are the per-pool options read on the hot path.
+type poolSettings struct {
+	strategy string
+	health   *healthConfig // nil uses the client's policy
+}
+
+func (pl *proxyPool) snapshot() *proxyList {
+	if l, ok := pl.listVal.Load().(*proxyList); ok {
+		return l
+	}
+	return nil
+}
+
+func (pl *proxyPool) strategy() string {
+	if s := pl.settings.Load(); s != nil && s.strategy != "" {
+		return s.strategy
+	}
+	return strategyRoundRobin
+}
+
+// label is the pool name used in stats and errors.
+func (pl *proxyPool) label() string {
+	if pl.name == "" {
+		return defaultPoolName
+	}
+	return pl.name
+}
+
+// currentPath returns the path of the loaded list.
+func (pl *proxyPool) currentPath() string {
+	p, _ := pl.path.Load().(string)
+	return p
+}
+
+// applySettings stores the strategy and health policy of the list options.
+func (pl *proxyPool) applySettings(opts ProxyListOptions) {
+	s := &poolSettings{strategy: opts.Strategy}
+	if opts.Health != nil {
+		cfg := opts.Health.resolve()
+		s.health = &cfg
+	}
+	pl.settings.Store(s)
+}
+
+// healthFor returns the health policy in effect for pl.
+func (c *Client) healthFor(pl *proxyPool) healthConfig {
+	if s := pl.settings.Load(); s != nil && s.health != nil {
+		return *s.health
+	}
+	return c.globalHealth().cfg
+}
+
+// poolFor returns the pool of the given name, the unnamed pool for "" or
+// "default". With create, a missing named pool is created empty; otherwise nil is returned.
+func (c *Client) poolFor(name string, create bool) *proxyPool {
+	if name == "" || name == defaultPoolName {
+		return &c.pool
+	}
+	if v, ok := c.pools.Load(name); ok {
+		return v.(*proxyPool)
+	}
+	if !create {
+		return nil
+	}
+	v, _ := c.pools.LoadOrStore(name, &proxyPool{name: name})
+	return v.(*proxyPool)
+}
+
+// allPools returns the unnamed pool followed by the named ones, sorted by name.
+func (c *Client) allPools() []*proxyPool {
+	var named []*proxyPool
+	c.pools.Range(func(_, v any) bool {
+		named = append(named, v.(*proxyPool))
+		return true
+	})
+	sort.Slice(named, func(i, j int) bool { return named[i].name < named[j].name })
+	return append([]*proxyPool{&c.pool}, named...)
+}
+
+// validatePoolOptions normalizes the pool-related list options.
+func validatePoolOptions(opts *ProxyListOptions) error {
+	opts.Strategy = strings.ToLower(opts.Strategy)
+	if opts.Strategy != "" && !slices.Contains(poolStrategies, opts.Strategy) {
+		return fmt.Errorf("unsupported pool strategy %q (want one of %s)", opts.Strategy, strings.Join(poolStrategies, ", "))
+	}
+	return nil
+}
+
+// pickStart returns the slot of l that nextProxy tries first; it then walks
+// the following slots in order, wrapping around. least_inflight starts at the
+// slot with the fewest running requests, scanning from a rotating offset so
+// that ties take turns.
+func (c *Client) pickStart(pl *proxyPool, l *proxyList) int {
+	n := len(l.slots)
+	switch pl.strategy() {
+	case strategyRandom:
+		return rand.Intn(n)
+	case strategyLeastInFlight:
+		from := int(pl.rr.Add(1)-1) % n
+		start, least := from, int64(math.MaxInt64)
+		for i := range n {
+			s := (from + i) % n
+			if f := c.limiterFor(l.entries[l.slots[s]].URL).inFlight.Load(); f < least {
+				start, least = s, f
+			}
+		}
+		return start
+	default:
+		return int(pl.rr.Add(1)-1) % n
+	}
+}
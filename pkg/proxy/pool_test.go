@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Given two named pools loaded from different lists
+// When proxies are picked from each
+// Then every pool rotates with its own cursor
+func TestPools_GivenTwoNamedPools_WhenPicked_ThenIndependentCursors(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	eu := writeProxiesFile(t, t.TempDir(), []string{"socks5://eu1:1080", "socks5://eu2:1080"})
+	us := writeProxiesFile(t, t.TempDir(), []string{"socks5://us1:1080", "socks5://us2:1080", "socks5://us3:1080"})
+	if _, err := c.LoadProxyListWithOptions(eu, ProxyListOptions{Name: "eu"}); err != nil {
+		t.Fatalf("load eu: %v", err)
+	}
+	if _, err := c.LoadProxyListWithOptions(us, ProxyListOptions{Name: "us"}); err != nil {
+		t.Fatalf("load us: %v", err)
+	}
+
+	pick := func(name string) string {
+		e, release, _ := c.nextProxy(c.poolFor(name, false), nil, nil, proxyLimits{})
+		if e == nil {
+			t.Fatalf("no proxy in pool %s", name)
+		}
+		release()
+		return e.URL
+	}
+	got := []string{pick("eu"), pick("us"), pick("eu"), pick("us"), pick("eu")}
+	want := []string{"socks5://eu1:1080", "socks5://us1:1080", "socks5://eu2:1080", "socks5://us2:1080", "socks5://eu1:1080"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("picks=%v want %v", got, want)
+		}
+	}
+	if l := c.proxySnapshot(); l != nil {
+		t.Fatalf("unnamed pool should stay empty, got %d entries", len(l.entries))
+	}
+}
+
+// Given the same proxy in two pools
+// When it is ejected in one of them
+// Then it stays available in the other
+func TestPools_GivenSharedProxy_WhenEjectedInOnePool_ThenHealthyInOther(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	const p = "socks5://shared:1080"
+	a, b := c.poolFor("a", true), c.poolFor("b", true)
+
+	c.markBadProxy(a, p)
+	if c.proxyAvailable(a, p) {
+		t.Fatalf("proxy should be ejected in pool a")
+	}
+	if !c.proxyAvailable(b, p) {
+		t.Fatalf("proxy should still be available in pool b")
+	}
+}
+
+// Given a pool with its own health policy
+// When one of its proxies fails once
+// Then the pool's threshold applies instead of the client's
+func TestPools_GivenPoolHealthPolicy_WhenFailure_ThenPoolThresholdApplies(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://p1:1080"})
+	if _, err := c.LoadProxyListWithOptions(path, ProxyListOptions{Name: "lenient", Health: &HealthPolicy{FailureThreshold: 3}}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	pl := c.poolFor("lenient", false)
+
+	c.recordProxyFailure(pl, "socks5://p1:1080", ErrClassProxyDial)
+	if !c.proxyAvailable(pl, "socks5://p1:1080") {
+		t.Fatalf("pool threshold of 3 should keep the proxy after one failure")
+	}
+	c.recordProxyFailure(&c.pool, "socks5://p1:1080", ErrClassProxyDial)
+	if c.proxyAvailable(&c.pool, "socks5://p1:1080") {
+		t.Fatalf("client threshold of 1 should eject the proxy in the unnamed pool")
+	}
+}
+
+// Given the least_inflight strategy and a busy first proxy
+// When a proxy is picked
+// Then the idle one is chosen
+func TestPools_GivenLeastInFlight_WhenFirstBusy_ThenIdlePicked(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://busy:1080", "socks5://idle:1080"})
+	if _, err := c.LoadProxyListWithOptions(path, ProxyListOptions{Name: "li", Strategy: "least_inflight"}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	c.limiterFor("socks5://busy:1080").inFlight.Add(2)
+
+	for i := 0; i < 3; i++ {
+		e, release, _ := c.nextProxy(c.poolFor("li", false), nil, nil, proxyLimits{})
+		if e == nil || e.URL != "socks5://idle:1080" {
+			t.Fatalf("pick %d=%v want idle", i, e)
+		}
+		release()
+	}
+}
+
+// Given pools of 2 and 500 proxies under each strategy
+// When proxies are picked
+// Then a pick allocates the same regardless of the pool size
+func TestPools_GivenLargePool_WhenPicked_ThenAllocsIndependentOfSize(t *testing.T) {
+	c := newHealthClient()
+	allocs := func(strategy string, n int) float64 {
+		urls := make([]string, n)
+		for i := range urls {
+			urls[i] = fmt.Sprintf("socks5://p%d:1080", i)
+		}
+		name := fmt.Sprintf("%s-%d", strategy, n)
+		if _, err := c.LoadProxyListWithOptions(writeProxiesFile(t, t.TempDir(), urls), ProxyListOptions{Name: name, Strategy: strategy}); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		pl := c.poolFor(name, false)
+		pick := func() {
+			_, release, _ := c.nextProxy(pl, nil, nil, proxyLimits{})
+			release()
+		}
+		for range 2 * n {
+			pick() // create the limiter of every proxy first
+		}
+		return testing.AllocsPerRun(100, pick)
+	}
+	for _, strategy := range poolStrategies {
+		if small, large := allocs(strategy, 2), allocs(strategy, 500); large > small {
+			t.Fatalf("%s: %v allocs per pick from 500 proxies, %v from 2", strategy, large, small)
+		}
+	}
+}
+
+// Given the least_inflight strategy and idle proxies
+// When proxies are picked
+// Then ties take turns instead of always picking the first proxy
+func TestPools_GivenLeastInFlightTie_WhenPicked_ThenRotates(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080", "socks5://b:1080", "socks5://c:1080"})
+	if _, err := c.LoadProxyListWithOptions(path, ProxyListOptions{Name: "li", Strategy: "least_inflight"}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		e, release, _ := c.nextProxy(c.poolFor("li", false), nil, nil, proxyLimits{})
+		seen[e.URL] = true
+		release()
+	}
+	if len(seen) != 3 {
+		t.Fatalf("picked %v, want each proxy once", seen)
+	}
+}
+
+// Given an unknown strategy
+// When a list is loaded
+// Then the load fails
+func TestPools_GivenUnknownStrategy_WhenLoad_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://p1:1080"})
+	_, err := c.LoadProxyListWithOptions(path, ProxyListOptions{Name: "x", Strategy: "fastest"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported pool strategy") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+// Given a request naming a pool that was never loaded
+// When it is sent
+// Then it fails without going direct
+func TestPools_GivenUnknownPool_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "proxy": map[string]any{"pool": "nope"}})
+	if !strings.Contains(resp.Error, `unknown proxy pool "nope"`) {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
+
+// Given a named pool with a working SOCKS proxy
+// When a request selects the pool
+// Then it goes through the pool and reports it in the response and stats
+func TestPools_GivenNamedPool_WhenRequest_ThenRoutedThroughPool(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+	s := startFakeSOCKS5(t, nil)
+	path := writeProxiesFile(t, t.TempDir(), []string{s.URL()})
+
+	c := newHealthClient()
+	if _, err := c.LoadProxyListWithOptions(path, ProxyListOptions{Name: "eu"}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "proxy": map[string]any{"pool": "eu"}})
+	if resp.Status != http.StatusOK || resp.Proxy == nil || resp.Proxy.URL != s.URL() || resp.Proxy.Pool != "eu" {
+		t.Fatalf("status=%d proxy=%+v err=%s", resp.Status, resp.Proxy, resp.Error)
+	}
+
+	stats := c.GetProxyStats()
+	if len(stats) != 1 || stats[0].Pool != "eu" || stats[0].Successes != 1 {
+		t.Fatalf("stats=%+v", stats)
+	}
+}
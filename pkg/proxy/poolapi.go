@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Runtime pool control. Scripts know things the module cannot detect (e.g. a
+// 200 response that is actually a captcha page), so they may edit pools and
+// their health while the test runs. Edits swap the pool snapshot like a reload;
+// addProxy/removeProxy last until the pool's source list changes, setProxies
+// detaches the pool from its source until loadProxyList is called again.
+
+// setProxiesSource is the summary source of lists set at runtime.
+const setProxiesSource = "setProxies"
+
+// existingPool returns the pool of the given name or an error naming it.
+func (c *Client) existingPool(name string) (*proxyPool, error) {
+	pl := c.poolFor(name, false)
+	if pl == nil {
+		return nil, fmt.Errorf("unknown proxy pool %q", name)
+	}
+	return pl, nil
+}
+
+// schemeFor returns the default scheme for entries added to pl. Callers hold pl.mu.
+func (c *Client) schemeFor(pl *proxyPool) string {
+	switch {
+	case pl.opts.DefaultScheme != "":
+		return pl.opts.DefaultScheme
+	case c.defaultProxy.DefaultScheme != "":
+		return c.defaultProxy.DefaultScheme
+	}
+	return defaultProxyScheme
+}
+
+// canonicalURL normalizes u like list entries of pl so "host:port" finds
+// "http://host:port"; an unparsable u is returned as is. Callers hold pl.mu.
+func (c *Client) canonicalURL(pl *proxyPool, u string) string {
+	if n, err := normalizeProxyURL(u, c.schemeFor(pl)); err == nil {
+		return n
+	}
+	return u
+}
+
+// AddProxy adds e to the pool (created when missing), or replaces the metadata
+// of the entry with the same URL.
+func (c *Client) AddProxy(pool string, e ProxyEntry) error {
+	pl := c.poolFor(pool, true)
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	u, err := normalizeProxyURL(e.URL, c.schemeFor(pl))
+	if err != nil {
+		return err
+	}
+	e.URL = u
+
+	var entries []*ProxyEntry
+	if l := pl.snapshot(); l != nil {
+		entries = slices.Clone(l.entries)
+	}
+	if i := slices.IndexFunc(entries, func(x *ProxyEntry) bool { return x.URL == u }); i >= 0 {
+		entries[i] = &e
+	} else {
+		entries = append(entries, &e)
+	}
+	pl.listVal.Store(newProxyList(entries))
+	c.recordListReload(listKindProxy)
+	return nil
+}
+
+// RemoveProxy removes url from the pool and forgets its health state. It
+// reports whether the proxy was in the pool.
+func (c *Client) RemoveProxy(pool, url string) (bool, error) {
+	pl, err := c.existingPool(pool)
+	if err != nil {
+		return false, err
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	url = c.canonicalURL(pl, url)
+	pl.health.Delete(url)
+
+	l := pl.snapshot()
+	if l == nil {
+		return false, nil
+	}
+	i := slices.IndexFunc(l.entries, func(x *ProxyEntry) bool { return x.URL == url })
+	if i < 0 {
+		return false, nil
+	}
+	pl.listVal.Store(newProxyList(slices.Delete(slices.Clone(l.entries), i, i+1)))
+	c.recordListReload(listKindProxy)
+	return true, nil
+}
+
+// SetProxies replaces the pool's proxies (creating the pool when missing).
+// Entries are validated like a loaded list and the summary reports the
+// rejected ones. The pool stops following its source file or URL.
+func (c *Client) SetProxies(pool string, list []ProxyEntry) (ProxyListSummary, error) {
+	pl := c.poolFor(pool, true)
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	parsed := make([]*ProxyEntry, len(list))
+	for i := range list {
+		e := list[i]
+		e.line = i + 1
+		parsed[i] = &e
+	}
+	entries, summary := normalizeEntries(setProxiesSource, "json", parsed, c.schemeFor(pl))
+
+	pl.stopRefresh()
+	pl.loading = nil // supersedes a remote fetch in progress
+	pl.listVal.Store(newProxyList(entries))
+	// keep path so requests naming it do not reload the source; a zero
+	// mtime keeps the watcher away and lets loadProxyList reattach it
+	pl.mtime = time.Time{}
+	pl.sum = [32]byte{}
+	pl.summary = summary
+	c.recordListReload(listKindProxy)
+	return summary, nil
+}
+
+// MarkProxyBad ejects url from the pool's rotation for ttl, or for the TTL of
+// the health policy when ttl is zero. After it, the proxy goes through the
+// usual half-open trials.
+func (c *Client) MarkProxyBad(pool, url string, ttl time.Duration) error {
+	pl, err := c.existingPool(pool)
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return fmt.Errorf("invalid ttl %v", ttl)
+	}
+	pl.mu.Lock()
+	url = c.canonicalURL(pl, url)
+	pl.mu.Unlock()
+	if ttl == 0 {
+		c.markBadProxy(pl, url)
+		return nil
+	}
+	h := c.healthState(pl, url)
+	h.mu.Lock()
+	h.ejectFor(ttl, time.Now())
+	h.mu.Unlock()
+	return nil
+}
+
+// MarkProxyGood reinstates url in the pool and forgets its failure history.
+func (c *Client) MarkProxyGood(pool, url string) error {
+	pl, err := c.existingPool(pool)
+	if err != nil {
+		return err
+	}
+	pl.mu.Lock()
+	url = c.canonicalURL(pl, url)
+	pl.mu.Unlock()
+	c.unmarkBadProxy(pl, url)
+	return nil
+}
+
+// ListProxies returns the pool's entries in list order. With healthyOnly,
+// ejected proxies are left out (half-open ones are kept).
+func (c *Client) ListProxies(pool string, healthyOnly bool) ([]ProxyEntry, error) {
+	pl, err := c.existingPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	out := []ProxyEntry{}
+	l := pl.snapshot()
+	if l == nil {
+		return out, nil
+	}
+	cfg, now := c.healthFor(pl), time.Now()
+	for _, e := range l.entries {
+		if healthyOnly {
+			if v, ok := pl.health.Load(e.URL); ok {
+				if state, _ := v.(*proxyHealth).status(cfg, now); state == "ejected" {
+					continue
+				}
+			}
+		}
+		out = append(out, c.entryCopy(e))
+	}
+	return out, nil
+}
+
+// entryCopy returns a copy of e safe to hand out, with the probed exit IP in meta.exitIp.
+func (c *Client) entryCopy(e *ProxyEntry) ProxyEntry {
+	cp := *e
+	cp.Tags = slices.Clone(e.Tags)
+	cp.Meta = maps.Clone(e.Meta)
+	if ip := c.exitIP(e.URL); ip != "" {
+		if cp.Meta == nil {
+			cp.Meta = map[string]string{}
+		}
+		cp.Meta["exitIp"] = ip
+	}
+	return cp
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyURLs(t *testing.T, c *Client, pool string, healthy bool) []string {
+	t.Helper()
+	list, err := c.ListProxies(pool, healthy)
+	if err != nil {
+		t.Fatalf("ListProxies: %v", err)
+	}
+	out := make([]string, len(list))
+	for i, e := range list {
+		out[i] = e.URL
+	}
+	return out
+}
+
+// Given an empty client
+// When proxies are added to a named pool, one of them twice
+// Then the pool is created and the second add updates the metadata
+func TestPoolAPI_GivenAddProxy_WhenSameURLTwice_ThenMetadataReplaced(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	if err := c.AddProxy("eu", ProxyEntry{URL: "socks5://a:1080", Country: "de"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := c.AddProxy("eu", ProxyEntry{URL: "b.example:8080"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := c.AddProxy("eu", ProxyEntry{URL: "socks5://a:1080", Country: "fr"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	list, err := c.ListProxies("eu", false)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list) != 2 || list[0].URL != "socks5://a:1080" || list[0].Country != "fr" || list[1].URL != "http://b.example:8080" {
+		t.Fatalf("list=%+v", list)
+	}
+	if err := c.AddProxy("eu", ProxyEntry{URL: "ftp://bad:21"}); err == nil {
+		t.Fatalf("expected an error for an unsupported scheme")
+	}
+}
+
+// Given a pool with an ejected proxy
+// When the proxy is removed
+// Then it leaves the rotation and its health state is forgotten
+func TestPoolAPI_GivenEjectedProxy_WhenRemoved_ThenGoneWithHealth(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: "socks5://a:1080"}, {URL: "socks5://b:1080"}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	c.markBadProxy(&c.pool, "socks5://a:1080")
+
+	removed, err := c.RemoveProxy("", "socks5://a:1080")
+	if err != nil || !removed {
+		t.Fatalf("removed=%v err=%v", removed, err)
+	}
+	if got := proxyURLs(t, c, "", false); len(got) != 1 || got[0] != "socks5://b:1080" {
+		t.Fatalf("list=%v", got)
+	}
+	if _, ok := c.pool.health.Load("socks5://a:1080"); ok {
+		t.Fatalf("health state should be forgotten")
+	}
+	if removed, _ := c.RemoveProxy("", "socks5://a:1080"); removed {
+		t.Fatalf("second remove should report false")
+	}
+}
+
+// Given a proxy marked bad with a ttl
+// When the pool is listed before and after markProxyGood
+// Then healthy lists leave it out until it is reinstated
+func TestPoolAPI_GivenMarkProxyBad_WhenListedHealthy_ThenExcludedUntilGood(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: "socks5://a:1080"}, {URL: "socks5://b:1080"}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := c.MarkProxyBad("", "socks5://a:1080", time.Hour); err != nil {
+		t.Fatalf("mark bad: %v", err)
+	}
+	if got := proxyURLs(t, c, "", true); len(got) != 1 || got[0] != "socks5://b:1080" {
+		t.Fatalf("healthy=%v", got)
+	}
+	if got := proxyURLs(t, c, "", false); len(got) != 2 {
+		t.Fatalf("all=%v", got)
+	}
+	ps := c.GetProxyStats()
+	if until, _ := time.Parse(time.RFC3339Nano, ps[0].BadUntil); time.Until(until) < 59*time.Minute {
+		t.Fatalf("badUntil=%q want about an hour from now", ps[0].BadUntil)
+	}
+
+	if err := c.MarkProxyGood("", "socks5://a:1080"); err != nil {
+		t.Fatalf("mark good: %v", err)
+	}
+	if got := proxyURLs(t, c, "", true); len(got) != 2 {
+		t.Fatalf("healthy after good=%v", got)
+	}
+}
+
+// Given a pool loaded from a file
+// When setProxies replaces it and the file changes afterwards
+// Then the watcher leaves the set proxies alone until loadProxyList is called again
+func TestPoolAPI_GivenSetProxies_WhenSourceChanges_ThenDetached(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	path := writeProxiesFile(t, t.TempDir(), []string{"socks5://file1:1080"})
+	if _, err := c.LoadProxyListWithOptions(path, ProxyListOptions{}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	summary, err := c.SetProxies("", []ProxyEntry{{URL: "socks5://set1:1080"}, {URL: "nope"}})
+	if err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if summary.Loaded != 1 || summary.Skipped != 1 || !strings.HasPrefix(summary.Errors[0], "setProxies[2]") {
+		t.Fatalf("summary=%+v", summary)
+	}
+	if !c.proxyListLoaded(&c.pool, path) {
+		t.Fatalf("requests naming the old path should not reload it")
+	}
+
+	writeProxiesFile(t, filepath.Dir(path), []string{"socks5://file2:1080"})
+	c.reloadChangedLists()
+	if got := proxyURLs(t, c, "", false); len(got) != 1 || got[0] != "socks5://set1:1080" {
+		t.Fatalf("after watcher=%v", got)
+	}
+	if _, err := c.LoadProxyListWithOptions(path, ProxyListOptions{}); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := proxyURLs(t, c, "", false); len(got) != 1 || got[0] != "socks5://file2:1080" {
+		t.Fatalf("after loadProxyList=%v", got)
+	}
+}
+
+// Given no pool named "nope"
+// When it is controlled
+// Then every call but add/set fails with an unknown pool error
+func TestPoolAPI_GivenUnknownPool_WhenControlled_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	_, errRemove := c.RemoveProxy("nope", "socks5://a:1080")
+	_, errList := c.ListProxies("nope", false)
+	for _, err := range []error{
+		errRemove,
+		errList,
+		c.MarkProxyBad("nope", "socks5://a:1080", 0),
+		c.MarkProxyGood("nope", "socks5://a:1080"),
+	} {
+		if err == nil || !strings.Contains(err.Error(), `unknown proxy pool "nope"`) {
+			t.Fatalf("err=%v", err)
+		}
+	}
+}
+
+// Given the JS wrappers
+// When a proxy is added with metadata and a pool option
+// Then the entry lands in that pool with its metadata
+func TestPoolAPI_GivenJSArguments_WhenAddProxy_ThenDecoded(t *testing.T) {
+	t.Parallel()
+	mi, _, _ := newTestModule(t)
+	meta := map[string]any{"country": "de", "tags": []any{"residential", "eu"}, "maxInFlight": int64(3)}
+	if err := mi.addProxy("socks5://a:1080", meta, map[string]any{"pool": "eu"}); err != nil {
+		t.Fatalf("addProxy: %v", err)
+	}
+	if err := mi.markProxyBad("socks5://a:1080", "10m", map[string]any{"pool": "eu"}); err != nil {
+		t.Fatalf("markProxyBad: %v", err)
+	}
+	if err := mi.markProxyBad("socks5://a:1080", "soon", map[string]any{"pool": "eu"}); err == nil {
+		t.Fatalf("expected an invalid ttl error")
+	}
+
+	list, err := mi.listProxies(map[string]any{"pool": "eu"})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("list=%+v err=%v", list, err)
+	}
+	if e := list[0]; e.Country != "de" || len(e.Tags) != 2 || e.MaxInFlight != 3 {
+		t.Fatalf("entry=%+v", e)
+	}
+	if healthy, _ := mi.listProxies(map[string]any{"pool": "eu", "healthy": true}); len(healthy) != 0 {
+		t.Fatalf("healthy=%+v", healthy)
+	}
+}
@@ -0,0 +1,375 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProxyEntry is one proxy of a list together with its optional metadata.
+type ProxyEntry struct {
+	URL      string            `json:"url"`
+	Weight   int               `json:"weight,omitempty"`
+	Country  string            `json:"country,omitempty"`
+	Provider string            `json:"provider,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+
+	// Provider limits for this proxy; override the global proxy.maxInFlight/maxRps.
+	MaxInFlight int     `json:"maxInFlight,omitempty" js:"maxInFlight"`
+	MaxRps      float64 `json:"maxRps,omitempty" js:"maxRps"`
+
+	line int // position in the source list, for load errors
+}
+
+// ProxyFilter selects a subset of the pool. Empty fields match everything;
+// every listed tag must be present on the entry.
+type ProxyFilter struct {
+	Country  string   `json:"country,omitempty" js:"country"`
+	Provider string   `json:"provider,omitempty" js:"provider"`
+	Tags     []string `json:"tags,omitempty" js:"tags"`
+}
+
+func (f *ProxyFilter) empty() bool {
+	return f == nil || (f.Country == "" && f.Provider == "" && len(f.Tags) == 0)
+}
+
+// key is a canonical representation used to cache filtered views.
+func (f *ProxyFilter) key() string {
+	tags := slices.Clone(f.Tags)
+	sort.Strings(tags)
+	return strings.ToLower(f.Country) + "|" + strings.ToLower(f.Provider) + "|" + strings.ToLower(strings.Join(tags, ","))
+}
+
+func (f *ProxyFilter) match(e *ProxyEntry) bool {
+	if f.Country != "" && !strings.EqualFold(f.Country, e.Country) {
+		return false
+	}
+	if f.Provider != "" && !strings.EqualFold(f.Provider, e.Provider) {
+		return false
+	}
+	for _, t := range f.Tags {
+		if !slices.ContainsFunc(e.Tags, func(et string) bool { return strings.EqualFold(et, t) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// setField assigns one metadata key; unknown keys are kept in Meta.
+func (e *ProxyEntry) setField(k, v string) {
+	k = strings.TrimSpace(k)
+	v = strings.TrimSpace(v)
+	switch strings.ToLower(k) {
+	case "url":
+		e.URL = v
+	case "weight":
+		if n, ok := asInt(v); ok {
+			e.Weight = n
+		}
+	case "country":
+		e.Country = v
+	case "provider":
+		e.Provider = v
+	case "maxinflight":
+		if n, ok := asInt(v); ok {
+			e.MaxInFlight = n
+		}
+	case "maxrps":
+		if f, ok := asFloat(v); ok {
+			e.MaxRps = f
+		}
+	case "tag", "tags":
+		for _, t := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' || r == '|' }) {
+			if t = strings.TrimSpace(t); t != "" && !slices.Contains(e.Tags, t) {
+				e.Tags = append(e.Tags, t)
+			}
+		}
+	default:
+		if e.Meta == nil {
+			e.Meta = map[string]string{}
+		}
+		e.Meta[k] = v
+	}
+}
+
+// parseProxyLine parses "url#weight=3&country=de&tag=residential". A fragment is
+// never meaningful in a proxy URL, so the first '#' after the credentials starts
+// the metadata; a password may contain '#'. A '#' at the start of the line or
+// after whitespace starts a comment.
+func parseProxyLine(line string) *ProxyEntry {
+	line = stripComment(line)
+	raw, meta := line, ""
+	from := strings.LastIndexByte(line, '@') + 1
+	if i := strings.IndexByte(line[from:], '#'); i >= 0 {
+		raw, meta = line[:from+i], line[from+i+1:]
+	}
+	e := &ProxyEntry{URL: strings.TrimSpace(raw)}
+	if meta == "" {
+		return e
+	}
+	q, err := url.ParseQuery(meta)
+	if err != nil {
+		return e
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range q[k] {
+			e.setField(k, v)
+		}
+	}
+	return e
+}
+
+// stripComment cuts line at a '#' that starts it or follows whitespace.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// entryFromAny converts a decoded JSON/YAML element (string or object) to an entry.
+func entryFromAny(v any) (*ProxyEntry, bool) {
+	switch x := v.(type) {
+	case string:
+		return parseProxyLine(x), true
+	case map[string]any:
+		e := &ProxyEntry{}
+		for k, fv := range x {
+			switch fvv := fv.(type) {
+			case []any:
+				for _, item := range fvv {
+					s, _ := asString(item)
+					e.setField(k, s)
+				}
+			default:
+				s, _ := asString(fv)
+				e.setField(k, s)
+			}
+		}
+		return e, e.URL != ""
+	default:
+		return nil, false
+	}
+}
+
+// proxyListFormat picks the list format from the file extension, sniffing JSON otherwise.
+func proxyListFormat(path string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".csv":
+		return "csv"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	if t := bytes.TrimSpace(data); len(t) > 0 && t[0] == '[' {
+		return "json"
+	}
+	return "text"
+}
+
+// parseProxyEntries decodes a proxy list in the given format.
+func parseProxyEntries(format string, data []byte) ([]*ProxyEntry, error) {
+	switch format {
+	case "json", "yaml":
+		var items []any
+		var err error
+		if format == "json" {
+			err = json.Unmarshal(data, &items)
+		} else {
+			err = yaml.Unmarshal(data, &items)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s proxy list: %w", format, err)
+		}
+		out := make([]*ProxyEntry, 0, len(items))
+		for i, it := range items {
+			e, ok := entryFromAny(it)
+			if !ok {
+				e = &ProxyEntry{}
+			}
+			e.line = i + 1
+			out = append(out, e)
+		}
+		return out, nil
+	case "csv":
+		return parseProxyCSV(data)
+	default:
+		lines := strings.Split(string(data), "\n")
+		out := make([]*ProxyEntry, 0, len(lines))
+		for i, line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			e := parseProxyLine(line)
+			e.line = i + 1
+			out = append(out, e)
+		}
+		return out, nil
+	}
+}
+
+// parseProxyCSV reads a CSV list with a header row naming the fields (url, weight, country, provider, tags, ...).
+func parseProxyCSV(data []byte) ([]*ProxyEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy CSV header: %w", err)
+	}
+	var out []*ProxyEntry
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy CSV: %w", err)
+		}
+		e := &ProxyEntry{}
+		e.line, _ = r.FieldPos(0)
+		for i, v := range rec {
+			if i < len(header) && v != "" {
+				e.setField(header[i], v)
+			}
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// supportedProxySchemes lists the schemes the transport knows how to dial. socks4a
+// is not among them: no dialer resolves names on the proxy for it.
+var supportedProxySchemes = []string{"socks4", "socks5", "socks5h", "http", "https"}
+
+const defaultProxyScheme = "http"
+
+// normalizeProxyURL accepts a full proxy URL or one of the common vendor
+// formats and returns a URL the transport can use:
+//
+//	scheme://[user:pass@]host:port
+//	user:pass@host:port
+//	host:port:user:pass
+//	user:pass:host:port
+//	host:port
+//
+// Entries without a scheme get defaultScheme. Templates ({session} etc.) are
+// validated with their placeholders masked and returned unexpanded.
+func normalizeProxyURL(raw, defaultScheme string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("empty proxy URL")
+	}
+	if err := checkPlaceholders(raw); err != nil {
+		return "", err
+	}
+	raw, unmask := maskPlaceholders(raw)
+	if !strings.Contains(raw, "://") {
+		parts := strings.Split(raw, ":")
+		switch {
+		case strings.Contains(raw, "@"), len(parts) == 2:
+			raw = defaultScheme + "://" + raw
+		case len(parts) >= 4:
+			host, port, user, pass, err := splitVendorProxy(raw)
+			if err != nil {
+				return "", err
+			}
+			raw = (&url.URL{Scheme: defaultScheme, User: url.UserPassword(user, pass), Host: net.JoinHostPort(host, port)}).String()
+		default:
+			return "", errors.New("unrecognized proxy format (want scheme://host:port, user:pass@host:port or host:port:user:pass)")
+		}
+	}
+
+	if at := strings.LastIndexByte(raw, '@'); at >= 0 {
+		// a '#' in the credentials would otherwise start a fragment
+		if start := strings.Index(raw, "://") + len("://"); start <= at {
+			raw = raw[:start] + strings.ReplaceAll(raw[start:at], "#", "%23") + raw[at:]
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err // do not echo credentials back
+		}
+		return "", fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if !slices.Contains(supportedProxySchemes, strings.ToLower(u.Scheme)) {
+		return "", fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("missing proxy host")
+	}
+	if !isPort(u.Port()) {
+		return "", errors.New("missing or invalid proxy port")
+	}
+	return unmask(raw), nil
+}
+
+// splitVendorProxy splits host:port:user:pass, whose password may hold ':',
+// or user:pass:host:port. When both fit, the form whose host looks like one
+// (an IP, then a dotted name) wins; a tie is rejected rather than guessed.
+func splitVendorProxy(raw string) (host, port, user, pass string, err error) {
+	parts := strings.Split(raw, ":")
+	hostFirst := isPort(parts[1])
+	userFirst := len(parts) == 4 && isPort(parts[3])
+	if hostFirst && userFirst {
+		switch a, b := hostScore(parts[0]), hostScore(parts[2]); {
+		case a > b:
+			userFirst = false
+		case b > a:
+			hostFirst = false
+		default:
+			return "", "", "", "", errors.New("ambiguous proxy format: fits both host:port:user:pass and user:pass:host:port (write it as user:pass@host:port)")
+		}
+	}
+	switch {
+	case hostFirst:
+		f := strings.SplitN(raw, ":", 4)
+		return f[0], f[1], f[2], f[3], nil
+	case userFirst:
+		return parts[2], parts[3], parts[0], parts[1], nil
+	}
+	return "", "", "", "", errors.New("unrecognized proxy format (want scheme://host:port, user:pass@host:port or host:port:user:pass)")
+}
+
+// hostScore rates how much s looks like a proxy host: 2 for an IP, 1 for a
+// dotted name, 0 otherwise.
+func hostScore(s string) int {
+	switch {
+	case net.ParseIP(s) != nil:
+		return 2
+	case strings.Contains(s, "."):
+		return 1
+	}
+	return 0
+}
+
+func isPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n < 65536
+}
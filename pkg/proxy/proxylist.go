@@ -1,106 +1,292 @@
 package proxy
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
-// LoadProxyList atomically replaces the in-memory proxy list snapshot.
-// It ignores empty lines and lines starting with '#'. When the file path is empty,
-// it clears the list. It compares mtime to avoid unnecessary reloads.
+// proxyList is an immutable snapshot of the loaded proxies. slots holds entry
+// indexes in rotation order, repeated according to weight.
+type proxyList struct {
+	entries []*ProxyEntry
+	slots   []int
+	views   sync.Map // filter key -> *proxyList
+}
+
+// newProxyList builds a snapshot. Weighted entries are interleaved round by round
+// (weights 3,1,1 rotate as a b c a a) so heavy proxies are not hit in bursts.
+func newProxyList(entries []*ProxyEntry) *proxyList {
+	l := &proxyList{entries: entries}
+	maxW := 1
+	for _, e := range entries {
+		maxW = max(maxW, e.Weight)
+	}
+	l.slots = make([]int, 0, len(entries))
+	for round := 0; round < maxW; round++ {
+		for i, e := range entries {
+			if round < max(e.Weight, 1) {
+				l.slots = append(l.slots, i)
+			}
+		}
+	}
+	return l
+}
+
+// view returns the snapshot restricted to entries matching f, cached per filter.
+func (l *proxyList) view(f *ProxyFilter) *proxyList {
+	if f.empty() {
+		return l
+	}
+	key := f.key()
+	if v, ok := l.views.Load(key); ok {
+		return v.(*proxyList)
+	}
+	var sub []*ProxyEntry
+	for _, e := range l.entries {
+		if f.match(e) {
+			sub = append(sub, e)
+		}
+	}
+	v, _ := l.views.LoadOrStore(key, newProxyList(sub))
+	return v.(*proxyList)
+}
+
+// proxySnapshot returns the list of the unnamed pool.
+func (c *Client) proxySnapshot() *proxyList {
+	return c.pool.snapshot()
+}
+
+// ProxyListOptions controls how a proxy list is loaded.
+type ProxyListOptions struct {
+	DefaultScheme string `json:"defaultScheme" js:"defaultScheme"` // scheme for entries without one (default "http")
+
+	// Name loads the list into a named pool instead of the unnamed one.
+	Name     string        `json:"name" js:"name"`
+	Strategy string        `json:"strategy" js:"strategy"`       // round_robin (default), random or least_inflight
+	Health   *HealthPolicy `json:"health,omitempty" js:"health"` // pool-specific health policy; nil uses the global one
+
+	// Remote (http/https) sources only.
+	RefreshInterval string            `json:"refreshInterval" js:"refreshInterval"` // re-fetch period, e.g. "10m"; empty loads once
+	Headers         map[string]string `json:"headers" js:"headers"`                 // request headers, e.g. Authorization
+	FieldPath       string            `json:"fieldPath" js:"fieldPath"`             // dotted path to the list in a JSON response, e.g. "data.proxies"
+
+	// ProbeExitIPs probes the exit IP of every proxy once the list is loaded.
+	ProbeExitIPs *ExitIPProbeOptions `json:"probeExitIPs,omitempty" js:"probeExitIPs"`
+}
+
+// ProxyListSummary reports the outcome of a list load. Errors point at the
+// offending entry as "file:line" (or "file[n]" for JSON/YAML lists).
+type ProxyListSummary struct {
+	Source     string   `json:"source"`
+	Loaded     int      `json:"loaded"`
+	Skipped    int      `json:"skipped"`
+	Duplicates int      `json:"duplicates"`
+	Errors     []string `json:"errors,omitempty"`
+	// RefreshError is the last background refresh failure of a remote list (the previous pool is kept).
+	RefreshError string `json:"refreshError,omitempty" js:"refreshError"`
+	// ExitIPs is the exit IP probe requested with probeExitIPs.
+	ExitIPs *ExitIPReport `json:"exitIps,omitempty" js:"exitIps"`
+}
+
+// LoadProxyList loads a proxy list with the configured default scheme. See LoadProxyListWithOptions.
 func (c *Client) LoadProxyList(path string) error {
+	_, err := c.LoadProxyListWithOptions(path, ProxyListOptions{})
+	return err
+}
+
+// LoadProxyListWithOptions atomically replaces the in-memory proxy list snapshot.
+// Plain text lists ignore empty lines and lines starting with '#' and accept
+// metadata after the URL ("socks5://h:1080#weight=3&country=de&tag=residential");
+// .json, .yaml/.yml and .csv files carry the same fields per entry. Entries may use
+// vendor formats (host:port:user:pass, user:pass@host:port); invalid entries are
+// skipped and reported in the summary, duplicates are dropped. When the file path
+// is empty, it clears the list. It compares mtime and content to avoid unnecessary reloads.
+// An http(s):// path is fetched instead of read, see loadRemoteProxyList.
+func (c *Client) LoadProxyListWithOptions(path string, opts ProxyListOptions) (ProxyListSummary, error) {
+	if probe := opts.ProbeExitIPs; probe != nil {
+		opts.ProbeExitIPs = nil
+		summary, err := c.LoadProxyListWithOptions(path, opts)
+		if err != nil {
+			return summary, err
+		}
+		report, err := c.probeListExitIPs(c.poolFor(opts.Name, true), *probe)
+		if err != nil {
+			return summary, err
+		}
+		summary.ExitIPs = &report
+		return summary, nil
+	}
+	if opts.DefaultScheme == "" {
+		opts.DefaultScheme = c.defaultProxy.DefaultScheme
+	}
+	if opts.DefaultScheme == "" {
+		opts.DefaultScheme = defaultProxyScheme
+	}
+	opts.DefaultScheme = strings.ToLower(opts.DefaultScheme)
+	if !slices.Contains(supportedProxySchemes, opts.DefaultScheme) {
+		return ProxyListSummary{}, fmt.Errorf("unsupported default proxy scheme %q", opts.DefaultScheme)
+	}
+
+	if err := validatePoolOptions(&opts); err != nil {
+		return ProxyListSummary{}, err
+	}
+
+	pl := c.poolFor(opts.Name, true)
+	if isRemoteList(path) {
+		return c.loadRemoteProxyList(pl, path, opts)
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.loading = nil // supersedes a remote fetch in progress
+
 	if path == "" {
 		// clear list
-		c.proxyListVal.Store([]string{})
-		c.proxyListPath = ""
-		c.proxyListMTime = time.Time{}
-		return nil
+		pl.stopRefresh()
+		pl.listVal.Store(newProxyList(nil))
+		pl.path.Store("")
+		pl.mtime = time.Time{}
+		pl.sum = [32]byte{}
+		pl.summary = ProxyListSummary{}
+		c.recordListReload(listKindProxy)
+		return pl.summary, nil
 	}
 
+	return c.loadProxyFile(pl, path, opts)
+}
+
+// loadProxyFile (re)loads a list file into pl when its mtime moved and swaps the
+// snapshot only when the content changed. Callers hold pl.mu.
+func (c *Client) loadProxyFile(pl *proxyPool, path string, opts ProxyListOptions) (ProxyListSummary, error) {
 	fi, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("failed to stat proxy list: %w", err)
+		return ProxyListSummary{}, fmt.Errorf("failed to stat proxy list: %w", err)
 	}
 	// If same file and not modified, skip reload
-	if c.proxyListPath == path && !fi.ModTime().After(c.proxyListMTime) {
-		return nil
+	same := pl.currentPath() == path && pl.opts.equal(opts)
+	if same && !fi.ModTime().After(pl.mtime) {
+		return pl.summary, nil
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to read proxy list: %w", err)
+		return ProxyListSummary{}, fmt.Errorf("failed to read proxy list: %w", err)
 	}
-	lines := strings.Split(string(data), "\n")
-	proxies := make([]string, 0, len(lines))
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		proxies = append(proxies, line)
+	sum := sha256.Sum256(data)
+	if same && sum == pl.sum {
+		// touched but unchanged: keep the snapshot and its cached filter views
+		pl.mtime = fi.ModTime()
+		return pl.summary, nil
+	}
+	format := proxyListFormat(path, data)
+	parsed, err := parseProxyEntries(format, data)
+	if err != nil {
+		return ProxyListSummary{}, fmt.Errorf("%s: %w", path, err)
 	}
+	entries, summary := normalizeEntries(path, format, parsed, opts.DefaultScheme)
+
 	// Store snapshot atomically (can be empty)
-	c.proxyListVal.Store(proxies)
-	c.proxyListPath = path
-	c.proxyListMTime = fi.ModTime()
-	// NOTE: we intentionally do not reset the round-robin cursor (proxyRR)
+	pl.stopRefresh()
+	pl.applySettings(opts)
+	pl.listVal.Store(newProxyList(entries))
+	pl.path.Store(path)
+	pl.mtime = fi.ModTime()
+	pl.sum = sum
+	pl.opts = opts
+	pl.summary = summary
+	c.recordListReload(listKindProxy)
+	c.startListWatcher()
+	// NOTE: we intentionally do not reset the round-robin cursor (rr)
 	// to avoid concentrating traffic on index 0 right after reload.
-	return nil
+	return summary, nil
 }
 
-// GetNextProxy returns the next healthy proxy using lock-free round-robin over the current snapshot.
-// If the list is empty or all proxies are currently marked as bad (not yet expired), it returns an empty string.
-func (c *Client) GetNextProxy() string {
-	v := c.proxyListVal.Load()
-	if v == nil {
-		return ""
-	}
-	list, ok := v.([]string)
-	if !ok || len(list) == 0 {
-		return ""
-	}
-
-	n := len(list)
-	if n == 1 {
-		p := list[0]
-		// quick bad-proxy check
-		if t, bad := c.badProxies.Load(p); bad {
-			if expireAt, ok := t.(time.Time); ok && time.Now().Before(expireAt) {
-				return ""
+// proxyListLoaded reports whether path is the list currently in use by pl, so
+// requests can skip the load; changes are picked up by the list watcher. It
+// takes no lock, as every request asks.
+func (c *Client) proxyListLoaded(pl *proxyPool, path string) bool {
+	return pl.currentPath() == path && pl.snapshot() != nil
+}
+
+// normalizeEntries validates parsed entries, rewrites vendor formats to URLs and drops duplicates.
+func normalizeEntries(source, format string, parsed []*ProxyEntry, defaultScheme string) ([]*ProxyEntry, ProxyListSummary) {
+	summary := ProxyListSummary{Source: source}
+	entries := make([]*ProxyEntry, 0, len(parsed))
+	seen := make(map[string]struct{}, len(parsed))
+	for _, e := range parsed {
+		u, err := normalizeProxyURL(e.URL, defaultScheme)
+		if err != nil {
+			summary.Skipped++
+			pos := fmt.Sprintf("%s:%d", source, e.line)
+			if format == "json" || format == "yaml" {
+				pos = fmt.Sprintf("%s[%d]", source, e.line)
 			}
-			c.badProxies.Delete(p)
+			summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %v", pos, err))
+			continue
+		}
+		if _, dup := seen[u]; dup {
+			summary.Duplicates++
+			continue
 		}
-		return p
+		seen[u] = struct{}{}
+		e.URL = u
+		entries = append(entries, e)
 	}
+	summary.Loaded = len(entries)
+	return entries, summary
+}
 
-	start := int(c.proxyRR.Add(1)-1) % n
-	now := time.Now()
-	for i := 0; i < n; i++ {
-		idx := (start + i) % n
-		p := list[idx]
-		if t, bad := c.badProxies.Load(p); bad {
-			if expireAt, ok := t.(time.Time); ok && now.Before(expireAt) {
-				continue
-			}
-			c.badProxies.Delete(p)
-		}
-		return p
+// GetNextProxy returns the next healthy proxy of the unnamed pool using lock-free round-robin over the current snapshot.
+// If the list is empty or all proxies are currently marked as bad (not yet expired), it returns an empty string.
+// Proxies at their configured maxInFlight are skipped, and a pick counts against maxRps.
+func (c *Client) GetNextProxy() string {
+	e, release, _ := c.nextProxy(&c.pool, nil, nil, c.defaultProxy.limits())
+	// the caller's use of the proxy is not tracked, so do not hold the slot
+	release()
+	if e != nil {
+		return e.URL
 	}
 	return ""
 }
 
-func (c *Client) markBadProxy(p string) {
-	if p == "" {
-		return
+// nextProxy picks from pl according to its strategy over the entries matching
+// filter, skipping the proxies in exclude (already tried by the current request)
+// and those at their limits. release frees the in-flight slot of the pick once
+// the request is done; saturated reports that a healthy candidate was skipped
+// only because of its limits.
+func (c *Client) nextProxy(pl *proxyPool, filter *ProxyFilter, exclude []string, limits proxyLimits) (e *ProxyEntry, release func(), saturated bool) {
+	release = func() {}
+	l := pl.snapshot()
+	if l == nil {
+		return nil, release, false
+	}
+	l = l.view(filter)
+	if len(l.slots) == 0 {
+		return nil, release, false
 	}
-	c.badProxies.Store(p, time.Now().Add(c.badProxyTTL))
-}
 
-func (c *Client) unmarkBadProxy(p string) {
-	if p == "" {
-		return
+	n := len(l.slots)
+	start := c.pickStart(pl, l)
+	for i := range n {
+		e := l.entries[l.slots[(start+i)%n]]
+		if slices.Contains(exclude, e.URL) || !c.proxyAdmissible(pl, e.URL) {
+			continue // an ejected proxy does not make the pool saturated
+		}
+		lim := c.limiterFor(e.URL)
+		now := time.Now()
+		r, ok := lim.reserve(limits.forEntry(e), now)
+		if !ok {
+			saturated = true
+			continue
+		}
+		if c.proxyAvailable(pl, e.URL) {
+			return e, lim.release, false
+		}
+		lim.undo(r, now)
 	}
-	c.badProxies.Delete(p)
+	return nil, release, saturated
 }
@@ -53,7 +53,7 @@ socks5h://p3:1080
 func TestGetNextProxy_GivenRotation_WhenWrap_ThenFirstAgain(t *testing.T) {
 	c := &Client{}
 	dir := t.TempDir()
-	path := writeProxiesFile(t, dir, []string{"p1", "p2", "p3"})
+	path := writeProxiesFile(t, dir, []string{"socks5://p1:1080", "socks5://p2:1080", "socks5://p3:1080"})
 	if err := c.LoadProxyList(path); err != nil {
 		t.Fatalf("LoadProxyList: %v", err)
 	}
@@ -74,19 +74,19 @@ func TestGetNextProxy_GivenRotation_WhenWrap_ThenFirstAgain(t *testing.T) {
 // When markBadProxy called
 // Then GetNextProxy skips it during TTL
 func TestBadProxyCache_GivenTTL_WhenMarkedBad_ThenSkipped(t *testing.T) {
-	c := &Client{badProxyTTL: 150 * time.Millisecond}
+	c := healthClient(HealthPolicy{BaseTTL: "150ms"})
 	dir := t.TempDir()
-	path := writeProxiesFile(t, dir, []string{"a", "b", "c"})
+	path := writeProxiesFile(t, dir, []string{"socks5://a:1080", "socks5://b:1080", "socks5://c:1080"})
 	if err := c.LoadProxyList(path); err != nil {
 		t.Fatalf("LoadProxyList: %v", err)
 	}
 
-	c.markBadProxy("b")
+	c.markBadProxy(&c.pool, "socks5://b:1080")
 	seen := map[string]bool{}
 	for i := 0; i < 6; i++ {
 		seen[c.GetNextProxy()] = true
 	}
-	if seen["b"] {
+	if seen["socks5://b:1080"] {
 		t.Fatalf("bad proxy should be skipped while in TTL")
 	}
 }
@@ -95,21 +95,21 @@ func TestBadProxyCache_GivenTTL_WhenMarkedBad_ThenSkipped(t *testing.T) {
 // When TTL elapses
 // Then it is retried again
 func TestBadProxyCache_GivenTTLExpired_WhenNext_ThenRetried(t *testing.T) {
-	c := &Client{badProxyTTL: 50 * time.Millisecond}
+	c := healthClient(HealthPolicy{BaseTTL: "50ms"})
 	dir := t.TempDir()
-	path := writeProxiesFile(t, dir, []string{"a", "b", "c"})
+	path := writeProxiesFile(t, dir, []string{"socks5://a:1080", "socks5://b:1080", "socks5://c:1080"})
 	if err := c.LoadProxyList(path); err != nil {
 		t.Fatalf("LoadProxyList: %v", err)
 	}
 
-	c.markBadProxy("b")
-	time.Sleep(c.badProxyTTL + 10*time.Millisecond)
+	c.markBadProxy(&c.pool, "socks5://b:1080")
+	time.Sleep(c.healthFor(&c.pool).baseTTL + 10*time.Millisecond)
 
 	seen := map[string]bool{}
 	for i := 0; i < 6; i++ {
 		seen[c.GetNextProxy()] = true
 	}
-	if !seen["b"] {
+	if !seen["socks5://b:1080"] {
 		t.Fatalf("expected b to be retried after TTL")
 	}
 }
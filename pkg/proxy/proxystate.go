@@ -0,0 +1,379 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyStateVersion is bumped when the state file changes incompatibly.
+const proxyStateVersion = 1
+
+// proxyStateFile is what saveProxyState writes: the health of every proxy per
+// pool and its counters, so that the next run starts with the known-dead
+// proxies still ejected.
+type proxyStateFile struct {
+	Version int               `json:"version"`
+	SavedAt string            `json:"savedAt"`
+	Proxies []proxyStateEntry `json:"proxies"`
+}
+
+type proxyStateEntry struct {
+	Pool        string           `json:"pool"`
+	URL         string           `json:"url"`
+	BadUntil    string           `json:"badUntil,omitempty"` // RFC 3339
+	Ejections   int              `json:"ejections,omitempty"`
+	Consecutive int              `json:"consecutive,omitempty"`
+	Successes   int64            `json:"successes,omitempty"`
+	Failures    map[string]int64 `json:"failures,omitempty"`
+	LatencyEWMA float64          `json:"latencyEwma,omitempty"`
+	LastError   string           `json:"lastError,omitempty"`
+}
+
+// ProxyStateSummary reports what loadProxyState restored.
+type ProxyStateSummary struct {
+	Proxies int `json:"proxies"`
+	Ejected int `json:"ejected"` // still ejected after the load
+}
+
+// persisted returns the part of the circuit state worth carrying to the next run.
+func (h *proxyHealth) persisted() (consecutive, ejections int, badUntil time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.consecutive, h.ejections, h.badUntil
+}
+
+// restore sets the circuit state saved by a previous run.
+func (h *proxyHealth) restore(consecutive, ejections int, badUntil time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutive = consecutive
+	h.ejections = ejections
+	h.badUntil = badUntil
+}
+
+// seed adds the counters saved by a previous run.
+func (s *proxyStats) seed(e proxyStateEntry) {
+	s.successes.Add(e.Successes)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for class, n := range e.Failures {
+		if s.failures == nil {
+			s.failures = map[ErrorClass]int64{}
+		}
+		s.failures[ErrorClass(class)] += n
+	}
+	if s.lastError == "" {
+		s.lastError = e.LastError
+	}
+	if len(s.samples) == 0 {
+		s.ewma = e.LatencyEWMA
+	}
+}
+
+// SaveProxyState writes the health and counters of every proxy to path as
+// JSON. Proxies outside the lists (pinned URLs, expanded templates) are only
+// kept while they carry health state.
+func (c *Client) SaveProxyState(path string) error {
+	stats := map[string]ProxyStats{}
+	for _, ps := range c.GetProxyStats() {
+		stats[ps.URL] = ps
+	}
+
+	state := proxyStateFile{Version: proxyStateVersion, SavedAt: time.Now().UTC().Format(time.RFC3339), Proxies: []proxyStateEntry{}}
+	for _, pl := range c.allPools() {
+		seen := map[string]bool{}
+		var urls []string
+		if l := pl.snapshot(); l != nil {
+			for _, e := range l.entries {
+				urls = append(urls, e.URL)
+				seen[e.URL] = true
+			}
+		}
+		var extra []string
+		pl.health.Range(func(k, _ any) bool {
+			if u := k.(string); !seen[u] {
+				extra = append(extra, u)
+			}
+			return true
+		})
+		sort.Strings(extra)
+		urls = append(urls, extra...)
+
+		for _, u := range urls {
+			e := proxyStateEntry{Pool: pl.label(), URL: u}
+			if v, ok := pl.health.Load(u); ok {
+				var until time.Time
+				e.Consecutive, e.Ejections, until = v.(*proxyHealth).persisted()
+				if !until.IsZero() {
+					e.BadUntil = until.UTC().Format(time.RFC3339Nano)
+				}
+			} else if !seen[u] {
+				continue
+			}
+			if ps, ok := stats[u]; ok {
+				e.Successes, e.Failures, e.LatencyEWMA, e.LastError = ps.Successes, ps.Failures, ps.LatencyEWMA, ps.LastError
+				if len(e.Failures) == 0 {
+					e.Failures = nil
+				}
+			}
+			if !seen[u] && e.BadUntil == "" && e.Ejections == 0 && e.Consecutive == 0 {
+				continue
+			}
+			state.Proxies = append(state.Proxies, e)
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// stateLoad is one state file applied by LoadProxyState.
+type stateLoad struct {
+	once    sync.Once
+	summary ProxyStateSummary
+	err     error
+}
+
+// LoadProxyState restores a file written by SaveProxyState: proxies ejected
+// at save time stay ejected until their badUntil, repeated ejections keep
+// backing off, and counters continue from the saved values. Pools named in the
+// file are created when missing; their lists are loaded as usual. A file is
+// applied once per client, so every VU may call it from the init context.
+func (c *Client) LoadProxyState(path string) (ProxyStateSummary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ProxyStateSummary{}, fmt.Errorf("failed to read proxy state: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	v, _ := c.loadedStates.LoadOrStore(hex.EncodeToString(sum[:]), &stateLoad{})
+	ld := v.(*stateLoad)
+	ld.once.Do(func() { ld.summary, ld.err = c.applyProxyState(data) })
+	return ld.summary, ld.err
+}
+
+func (c *Client) applyProxyState(data []byte) (ProxyStateSummary, error) {
+	var state proxyStateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return ProxyStateSummary{}, fmt.Errorf("failed to parse proxy state: %w", err)
+	}
+	if state.Version != proxyStateVersion {
+		return ProxyStateSummary{}, fmt.Errorf("unsupported proxy state version %d", state.Version)
+	}
+
+	var summary ProxyStateSummary
+	now := time.Now()
+	seeded := map[string]bool{}
+	for _, e := range state.Proxies {
+		if e.URL == "" {
+			continue
+		}
+		var until time.Time
+		if e.BadUntil != "" {
+			var err error
+			if until, err = time.Parse(time.RFC3339Nano, e.BadUntil); err != nil {
+				return summary, fmt.Errorf("invalid badUntil %q for %s", e.BadUntil, e.URL)
+			}
+		}
+		pl := c.poolFor(e.Pool, true)
+		if !until.IsZero() || e.Ejections > 0 || e.Consecutive > 0 {
+			c.healthState(pl, e.URL).restore(e.Consecutive, e.Ejections, until)
+		}
+		if now.Before(until) {
+			summary.Ejected++
+		}
+		// counters are per proxy, even when it is in several pools
+		if !seeded[e.URL] {
+			seeded[e.URL] = true
+			c.statsFor(e.URL).seed(e)
+		}
+		summary.Proxies++
+	}
+	return summary, nil
+}
+
+// ExportProxiesOptions selects and orders the proxies written by ExportProxies.
+type ExportProxiesOptions struct {
+	Pool           string  `json:"pool" js:"pool"`
+	Sort           string  `json:"sort" js:"sort"`                     // "latency" (default) or "url"
+	MinSuccessRate float64 `json:"minSuccessRate" js:"minSuccessRate"` // 0..1; proxies below it are dropped once they were used
+	IncludeEjected bool    `json:"includeEjected" js:"includeEjected"` // keep proxies that are currently ejected
+}
+
+// ExportProxies writes the pool's proxies to path in the list format, metadata
+// included, leaving out ejected proxies and those below MinSuccessRate. It
+// returns the number of proxies written. Sorted by latency, unmeasured proxies
+// come last.
+func (c *Client) ExportProxies(path string, opts ExportProxiesOptions) (int, error) {
+	pl, err := c.existingPool(opts.Pool)
+	if err != nil {
+		return 0, err
+	}
+	switch opts.Sort {
+	case "", "latency", "url":
+	default:
+		return 0, fmt.Errorf("unsupported sort %q (want latency or url)", opts.Sort)
+	}
+
+	stats := map[string]ProxyStats{}
+	for _, ps := range c.GetProxyStats() {
+		if ps.Pool == pl.label() {
+			stats[ps.URL] = ps
+		}
+	}
+	var entries []*ProxyEntry
+	if l := pl.snapshot(); l != nil {
+		for _, e := range l.entries {
+			ps := stats[e.URL]
+			if ps.State == "ejected" && !opts.IncludeEjected {
+				continue
+			}
+			if rate, used := successRate(ps); used && rate < opts.MinSuccessRate {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	latency := func(e *ProxyEntry) float64 {
+		if l := stats[e.URL].LatencyEWMA; l > 0 {
+			return l
+		}
+		return math.Inf(1)
+	}
+	slices.SortStableFunc(entries, func(a, b *ProxyEntry) int {
+		if opts.Sort != "url" {
+			if la, lb := latency(a), latency(b); la != lb {
+				if la < lb {
+					return -1
+				}
+				return 1
+			}
+		}
+		return strings.Compare(a.URL, b.URL)
+	})
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		cp := c.entryCopy(e)
+		buf.WriteString(entryLine(&cp))
+		buf.WriteByte('\n')
+	}
+	return len(entries), writeFileAtomic(path, buf.Bytes())
+}
+
+// ExportProxyReport writes getProxyStats() as CSV to path, one row per proxy and pool.
+func (c *Client) ExportProxyReport(path string) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{
+		"pool", "url", "state", "successes", "failures", "success_rate", "failures_by_class",
+		"latency_ewma_ms", "latency_p50_ms", "latency_p95_ms", "latency_p99_ms",
+		"bytes_up", "bytes_down", "in_flight", "bad_until", "last_error",
+	})
+	ms := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+	for _, ps := range c.GetProxyStats() {
+		var total int64
+		classes := make([]string, 0, len(ps.Failures))
+		for class, n := range ps.Failures {
+			total += n
+			classes = append(classes, class+"="+strconv.FormatInt(n, 10))
+		}
+		sort.Strings(classes)
+		rate := ""
+		if r, used := successRate(ps); used {
+			rate = strconv.FormatFloat(r, 'f', 3, 64)
+		}
+		_ = w.Write([]string{
+			ps.Pool, ps.URL, ps.State, strconv.FormatInt(ps.Successes, 10), strconv.FormatInt(total, 10), rate, strings.Join(classes, ";"),
+			ms(ps.LatencyEWMA), ms(ps.LatencyP50), ms(ps.LatencyP95), ms(ps.LatencyP99),
+			strconv.FormatInt(ps.BytesUp, 10), strconv.FormatInt(ps.BytesDown, 10), strconv.FormatInt(ps.InFlight, 10), ps.BadUntil, ps.LastError,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// successRate returns successes / (successes + failures); used is false for a proxy never used.
+func successRate(ps ProxyStats) (rate float64, used bool) {
+	total := ps.Successes
+	for _, n := range ps.Failures {
+		total += n
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(ps.Successes) / float64(total), true
+}
+
+// entryLine formats e in the text list format, "url#country=de&tag=residential".
+func entryLine(e *ProxyEntry) string {
+	q := url.Values{}
+	for k, v := range e.Meta {
+		q.Set(k, v)
+	}
+	if e.Weight > 0 {
+		q.Set("weight", strconv.Itoa(e.Weight))
+	}
+	if e.Country != "" {
+		q.Set("country", e.Country)
+	}
+	if e.Provider != "" {
+		q.Set("provider", e.Provider)
+	}
+	for _, t := range e.Tags {
+		q.Add("tag", t)
+	}
+	if e.MaxInFlight > 0 {
+		q.Set("maxInFlight", strconv.Itoa(e.MaxInFlight))
+	}
+	if e.MaxRps > 0 {
+		q.Set("maxRps", strconv.FormatFloat(e.MaxRps, 'f', -1, 64))
+	}
+	if len(q) == 0 {
+		return e.URL
+	}
+	return e.URL + "#" + q.Encode()
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so a
+// reader (or the next run) never sees a half-written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
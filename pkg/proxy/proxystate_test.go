@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Given a run where one proxy was ejected twice and another was used
+// When its state is saved and loaded by a new client
+// Then the ejected proxy stays out of rotation with its backoff and counters restored, once
+func TestProxyState_GivenEjectedProxy_WhenSavedAndLoaded_ThenStillEjected(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.json")
+	c := newHealthClient()
+	if _, err := c.SetProxies("eu", []ProxyEntry{{URL: "socks5://dead:1080"}, {URL: "socks5://good:1080"}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	pl := c.poolFor("eu", false)
+	c.markBadProxy(pl, "socks5://dead:1080")
+	c.markBadProxy(pl, "socks5://dead:1080")
+	c.statsFor("socks5://dead:1080").failure(ErrClassProxyDial, errors.New("refused"))
+	c.statsFor("socks5://good:1080").success()
+	c.statsFor("socks5://good:1080").latency(40 * time.Millisecond)
+	if err := c.SaveProxyState(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	next := newHealthClient()
+	summary, err := next.LoadProxyState(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if summary.Proxies != 2 || summary.Ejected != 1 {
+		t.Fatalf("summary=%+v", summary)
+	}
+	// every VU loads it from the init context: the file is applied once
+	if again, err := next.LoadProxyState(path); err != nil || again != summary {
+		t.Fatalf("second load: %+v %v", again, err)
+	}
+	npl := next.poolFor("eu", false)
+	if npl == nil || next.proxyAvailable(npl, "socks5://dead:1080") || !next.proxyAvailable(npl, "socks5://good:1080") {
+		t.Fatalf("restored availability is wrong")
+	}
+	if _, ejections, _ := next.healthState(npl, "socks5://dead:1080").persisted(); ejections != 2 {
+		t.Fatalf("ejections=%d", ejections)
+	}
+
+	dead, good := ProxyStats{Failures: map[string]int64{}}, ProxyStats{Failures: map[string]int64{}}
+	next.statsFor("socks5://dead:1080").snapshot(&dead)
+	next.statsFor("socks5://good:1080").snapshot(&good)
+	if dead.Failures[string(ErrClassProxyDial)] != 1 || dead.LastError != "refused" || good.Successes != 1 || good.LatencyEWMA != 40 {
+		t.Fatalf("dead=%+v good=%+v", dead, good)
+	}
+}
+
+// Given a state file that is missing or of another version
+// When it is loaded
+// Then an error is returned
+func TestProxyState_GivenBadFile_WhenLoaded_ThenError(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	c := newHealthClient()
+	if _, err := c.LoadProxyState(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatalf("missing file should fail")
+	}
+	path := filepath.Join(dir, "v2.json")
+	if err := os.WriteFile(path, []byte(`{"version":2,"proxies":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LoadProxyState(path); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+// Given a pool with an ejected, a failing, a fast and a slow proxy
+// When the proxies are exported
+// Then the file keeps the usable ones fastest first, with their metadata, and loads back
+func TestExportProxies_GivenMixedHealth_WhenExported_ThenCleanedAndSorted(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "clean.txt")
+	c := newHealthClient()
+	entries := []ProxyEntry{
+		{URL: "socks5://slow:1080", Country: "de", Tags: []string{"residential", "eu"}},
+		{URL: "socks5://dead:1080"},
+		{URL: "socks5://flaky:1080"},
+		{URL: "socks5://fast:1080", Weight: 3, MaxRps: 2.5, Meta: map[string]string{"asn": "3320"}},
+		{URL: "socks5://unused:1080"},
+	}
+	if _, err := c.SetProxies("", entries); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	c.markBadProxy(&c.pool, "socks5://dead:1080")
+	c.statsFor("socks5://slow:1080").latency(300 * time.Millisecond)
+	c.statsFor("socks5://fast:1080").latency(20 * time.Millisecond)
+	c.statsFor("socks5://flaky:1080").success()
+	for range 3 {
+		c.statsFor("socks5://flaky:1080").failure(ErrClassProxyDial, errors.New("timeout"))
+	}
+
+	n, err := c.ExportProxies(path, ExportProxiesOptions{MinSuccessRate: 0.5})
+	if err != nil || n != 3 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "socks5://fast:1080#asn=3320&maxRps=2.5&weight=3\n" +
+		"socks5://slow:1080#country=de&tag=residential&tag=eu\n" +
+		"socks5://unused:1080\n"
+	if string(data) != want {
+		t.Fatalf("got\n%s", data)
+	}
+
+	reloaded := newHealthClient()
+	if _, err := reloaded.LoadProxyListWithOptions(path, ProxyListOptions{}); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	got, _ := reloaded.ListProxies("", false)
+	if len(got) != 3 || got[0].Weight != 3 || got[0].Meta["asn"] != "3320" || got[1].Country != "de" || len(got[1].Tags) != 2 {
+		t.Fatalf("reloaded=%+v", got)
+	}
+
+	if _, err := c.ExportProxies(path, ExportProxiesOptions{Sort: "speed"}); err == nil {
+		t.Fatalf("unknown sort should fail")
+	}
+}
+
+// Given proxies with traffic
+// When the report is exported
+// Then it is a CSV with one row per proxy and its counters
+func TestExportProxyReport_GivenStats_WhenExported_ThenCSVRows(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "report.csv")
+	c := newHealthClient()
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: "socks5://a:1080"}, {URL: "socks5://b:1080"}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	c.statsFor("socks5://a:1080").success()
+	c.statsFor("socks5://a:1080").failure(ErrClassProxyDial, errors.New("refused"))
+	c.markBadProxy(&c.pool, "socks5://b:1080")
+
+	if err := c.ExportProxyReport(path); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "pool" || rows[0][1] != "url" {
+		t.Fatalf("rows=%v", rows)
+	}
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		col[h] = i
+	}
+	a, b := rows[1], rows[2]
+	if a[col["url"]] != "socks5://a:1080" || a[col["success_rate"]] != "0.500" || a[col["failures_by_class"]] != "proxy_dial=1" {
+		t.Fatalf("a=%v", a)
+	}
+	if b[col["state"]] != "ejected" || b[col["bad_until"]] == "" || b[col["success_rate"]] != "" {
+		t.Fatalf("b=%v", b)
+	}
+}
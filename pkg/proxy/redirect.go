@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Redirects are followed by net/http on a per-request copy of the cached
+// client, like the cookie jar: CheckRedirect applies the policy and records
+// the chain, and with redirectProxy "rotate" the transport sends every hop
+// after the first through another proxy of the pool.
+
+// defaultMaxRedirects matches the limit of net/http.
+const defaultMaxRedirects = 10
+
+const (
+	redirectAllow = "allow"
+	redirectDeny  = "deny"
+
+	redirectProxySame   = "same"   // every hop through the proxy of the request (default)
+	redirectProxyRotate = "rotate" // every hop through the next proxy of the pool
+
+	redirectRefererKeep = "keep" // the Referer of the request on every hop (default)
+	redirectRefererHop  = "hop"  // the URL of the previous hop, as browsers do
+	redirectRefererNone = "none" // no Referer on hops
+)
+
+// Reasons a redirect was not followed, in RedirectHop.Stopped.
+const (
+	stoppedNotFollowed = "followRedirects"
+	stoppedMax         = "maxRedirects"
+	stoppedCrossHost   = "crossHost"
+	stoppedDowngrade   = "downgrade"
+)
+
+// RedirectHop is one redirect response of a request.
+type RedirectHop struct {
+	URL      string `json:"url"` // URL that answered with the redirect
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+	Proxy    string `json:"proxy,omitempty"`   // proxy the hop went through
+	Stopped  string `json:"stopped,omitempty"` // why the redirect was not followed
+}
+
+// redirectPolicy is the resolved form of the http redirect options.
+type redirectPolicy struct {
+	follow        bool
+	max           int
+	denyCrossHost bool
+	denyDowngrade bool
+	rotate        bool
+	referer       string
+}
+
+func (o HTTPOptions) redirectPolicy() (redirectPolicy, error) {
+	p := redirectPolicy{follow: o.FollowRedirects, max: o.MaxRedirects, referer: redirectRefererKeep}
+	if p.max <= 0 {
+		p.max = defaultMaxRedirects
+	}
+	for _, opt := range []struct {
+		name, value string
+		dst         *bool
+	}{
+		{"redirectCrossHost", o.RedirectCrossHost, &p.denyCrossHost},
+		{"redirectDowngrade", o.RedirectDowngrade, &p.denyDowngrade},
+	} {
+		switch strings.ToLower(opt.value) {
+		case "", redirectAllow:
+		case redirectDeny:
+			*opt.dst = true
+		default:
+			return p, fmt.Errorf("unsupported http.%s %q (want allow or deny)", opt.name, opt.value)
+		}
+	}
+	switch strings.ToLower(o.RedirectProxy) {
+	case "", redirectProxySame:
+	case redirectProxyRotate:
+		p.rotate = true
+	default:
+		return p, fmt.Errorf("unsupported http.redirectProxy %q (want same or rotate)", o.RedirectProxy)
+	}
+	if r := strings.ToLower(o.RedirectReferer); r != "" {
+		if !slices.Contains([]string{redirectRefererKeep, redirectRefererHop, redirectRefererNone}, r) {
+			return p, fmt.Errorf("unsupported http.redirectReferer %q (want keep, hop or none)", o.RedirectReferer)
+		}
+		p.referer = r
+	}
+	return p, nil
+}
+
+// redirectChain records the redirects of one attempt. CheckRedirect and the
+// hop transport run on the goroutine of client.Do, but the chain is read
+// after it returned, so the mutex only guards against misuse.
+type redirectChain struct {
+	proxy   string // of the request, for hops not recorded in proxies
+	mu      sync.Mutex
+	hops    []RedirectHop
+	proxies []string // proxy of each round trip, in order, with redirectProxy "rotate"
+}
+
+func (rc *redirectChain) roundTrip(proxy string) {
+	rc.mu.Lock()
+	rc.proxies = append(rc.proxies, proxy)
+	rc.mu.Unlock()
+}
+
+func (rc *redirectChain) result() []RedirectHop {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.hops
+}
+
+// check returns the CheckRedirect of an attempt: it records every redirect
+// response in rc and stops at the first one the policy does not follow,
+// returning that response as the result of the request.
+func (p redirectPolicy) check(rc *redirectChain) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		prev := via[len(via)-1]
+		hop := RedirectHop{URL: prev.URL.String(), Location: req.URL.String()}
+		if req.Response != nil {
+			hop.Status = req.Response.StatusCode
+			hop.Location = req.Response.Header.Get("Location")
+		}
+		downgrade := prev.URL.Scheme == "https" && req.URL.Scheme == "http"
+		switch {
+		case !p.follow:
+			hop.Stopped = stoppedNotFollowed
+		case len(via) > p.max:
+			hop.Stopped = stoppedMax
+		case p.denyCrossHost && !strings.EqualFold(prev.URL.Hostname(), req.URL.Hostname()):
+			hop.Stopped = stoppedCrossHost
+		case p.denyDowngrade && downgrade:
+			hop.Stopped = stoppedDowngrade
+		}
+
+		rc.mu.Lock()
+		hop.Proxy = rc.proxy
+		if n := len(via) - 1; n < len(rc.proxies) {
+			hop.Proxy = rc.proxies[n]
+		}
+		rc.hops = append(rc.hops, hop)
+		rc.mu.Unlock()
+		if hop.Stopped != "" {
+			return http.ErrUseLastResponse
+		}
+
+		switch p.referer {
+		case redirectRefererHop:
+			if downgrade {
+				req.Header.Del("Referer")
+			} else {
+				req.Header.Set("Referer", prev.URL.String())
+			}
+		case redirectRefererNone:
+			req.Header.Del("Referer")
+		}
+		return nil
+	}
+}
+
+// hopPicker picks the proxy of a redirect hop, avoiding the used ones where
+// possible. entry is nil when the pool has no other proxy to offer; release
+// must be called once the attempt is over.
+type hopPicker func(used []string) (entry *ProxyEntry, identity string, release func())
+
+// hopTransport sends the first request of an attempt through first and every
+// redirect hop through a proxy picked by pick, recording the outcome of each
+// hop against the health of its proxy.
+type hopTransport struct {
+	c        *Client
+	pl       *proxyPool
+	first    http.RoundTripper
+	identity string // of first
+	pick     hopPicker
+	newHop   func(identity, statsURL string) (*http.Client, error)
+	chain    *redirectChain
+
+	mu       sync.Mutex
+	used     []string
+	releases []func()
+	hopErr   bool // a hop failed: the error is not the first proxy's
+}
+
+func (t *hopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Response == nil {
+		t.chain.roundTrip(t.identity)
+		return t.first.RoundTrip(req)
+	}
+	t.mu.Lock()
+	used := slices.Clone(t.used)
+	t.mu.Unlock()
+	entry, identity, release := t.pick(used)
+	t.mu.Lock()
+	t.releases = append(t.releases, release)
+	t.mu.Unlock()
+	if entry == nil {
+		t.chain.roundTrip(t.identity)
+		return t.first.RoundTrip(req)
+	}
+	t.mu.Lock()
+	t.used = append(t.used, entry.URL)
+	t.mu.Unlock()
+
+	t.chain.roundTrip(identity)
+	client, err := t.newHop(identity, entry.URL)
+	if err != nil {
+		t.failed(identity, entry.URL, ErrClassProxyConfig, err)
+		return nil, err
+	}
+	resp, err := client.Transport.RoundTrip(req)
+	if err != nil {
+		t.failed(identity, entry.URL, classifyError(err), err)
+		return nil, err
+	}
+	t.c.recordProxySuccess(t.pl, identity)
+	t.c.statsFor(entry.URL).success()
+	return resp, nil
+}
+
+func (t *hopTransport) failed(identity, statsURL string, class ErrorClass, err error) {
+	t.c.recordProxyFailure(t.pl, identity, class)
+	t.c.statsFor(statsURL).failure(class, err)
+	t.mu.Lock()
+	t.hopErr = true
+	t.mu.Unlock()
+}
+
+// done releases the proxies picked for hops and reports whether a hop failed.
+func (t *hopTransport) done() (hopFailed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, release := range t.releases {
+		release()
+	}
+	return t.hopErr
+}
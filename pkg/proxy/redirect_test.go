@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// redirectServer redirects /hop/N to /hop/N-1 down to /end, which echoes the
+// Referer; /away redirects to the same server under another host name.
+func redirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/end":
+			io.WriteString(w, r.Header.Get("Referer"))
+		case r.URL.Path == "/away":
+			http.Redirect(w, r, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)+"/end", http.StatusFound)
+		case r.URL.Path == "/hop/1":
+			http.Redirect(w, r, "/end", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, "/hop/"):
+			n := strings.TrimPrefix(r.URL.Path, "/hop/")
+			http.Redirect(w, r, "/hop/"+string(n[0]-1), http.StatusFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Given a target redirecting three times
+// When it is followed, limited to two hops, and not followed
+// Then the chain records every redirect and why the last one was not followed
+func TestRedirects_GivenChain_WhenFollowedOrLimited_ThenRecorded(t *testing.T) {
+	t.Parallel()
+	ts := redirectServer(t)
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL + "/hop/3", "http": map[string]any{"followRedirects": true}})
+	if resp.Status != http.StatusOK || len(resp.Redirects) != 3 {
+		t.Fatalf("status=%d redirects=%+v err=%s", resp.Status, resp.Redirects, resp.Error)
+	}
+	first, last := resp.Redirects[0], resp.Redirects[2]
+	if first.URL != ts.URL+"/hop/3" || first.Status != http.StatusFound || first.Location != "/hop/2" || first.Stopped != "" {
+		t.Fatalf("first hop: %+v", first)
+	}
+	if last.Status != http.StatusMovedPermanently || last.Location != "/end" {
+		t.Fatalf("last hop: %+v", last)
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/hop/3", "http": map[string]any{"followRedirects": true, "maxRedirects": int64(2)}})
+	if resp.Status != http.StatusMovedPermanently || len(resp.Redirects) != 3 || resp.Redirects[2].Stopped != stoppedMax || resp.Error != "" {
+		t.Fatalf("status=%d redirects=%+v err=%s", resp.Status, resp.Redirects, resp.Error)
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/hop/3"})
+	if resp.Status != http.StatusFound || len(resp.Redirects) != 1 || resp.Redirects[0].Stopped != stoppedNotFollowed {
+		t.Fatalf("status=%d redirects=%+v", resp.Status, resp.Redirects)
+	}
+}
+
+// Given a redirect to another host name
+// When cross-host redirects are denied
+// Then the redirect is returned instead of followed
+func TestRedirects_GivenCrossHost_WhenDenied_ThenStopped(t *testing.T) {
+	t.Parallel()
+	ts := redirectServer(t)
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL + "/away", "http": map[string]any{"followRedirects": true}})
+	if resp.Status != http.StatusOK || len(resp.Redirects) != 1 {
+		t.Fatalf("allowed: status=%d redirects=%+v err=%s", resp.Status, resp.Redirects, resp.Error)
+	}
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/away", "http": map[string]any{"followRedirects": true, "redirectCrossHost": "deny"}})
+	if resp.Status != http.StatusFound || len(resp.Redirects) != 1 || resp.Redirects[0].Stopped != stoppedCrossHost {
+		t.Fatalf("denied: status=%d redirects=%+v", resp.Status, resp.Redirects)
+	}
+}
+
+// Given a request with a Referer redirected twice
+// When redirectReferer is keep, hop and none
+// Then the last hop sends the original Referer, the previous URL, or nothing
+func TestRedirects_GivenReferer_WhenModes_ThenSentPerHop(t *testing.T) {
+	t.Parallel()
+	ts := redirectServer(t)
+	c := newHealthClient()
+	for mode, want := range map[string]string{
+		"keep": "https://origin.example/",
+		"hop":  ts.URL + "/hop/1",
+		"none": "",
+	} {
+		resp := doRequest(t, c, map[string]any{
+			"url":  ts.URL + "/hop/2",
+			"http": map[string]any{"followRedirects": true, "redirectReferer": mode, "headers": map[string]any{"Referer": "https://origin.example/"}},
+		})
+		if string(resp.Body) != want {
+			t.Fatalf("%s: referer=%q want %q", mode, resp.Body, want)
+		}
+	}
+}
+
+// Given a list of two proxies
+// When a redirected request rotates the proxy per hop
+// Then each hop goes through another proxy and reports it
+func TestRedirects_GivenPool_WhenRotate_ThenHopsThroughOtherProxy(t *testing.T) {
+	t.Parallel()
+	ts := redirectServer(t)
+	p1 := startFakeSOCKS5(t, nil)
+	p2 := startFakeSOCKS5(t, nil)
+	path := writeProxiesFile(t, t.TempDir(), []string{p1.URL(), p2.URL()})
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{
+		"url":   ts.URL + "/hop/1",
+		"proxy": map[string]any{"listPath": path},
+		"http":  map[string]any{"followRedirects": true, "redirectProxy": "rotate"},
+	})
+	if resp.Status != http.StatusOK || len(resp.Redirects) != 1 || resp.Proxy == nil {
+		t.Fatalf("status=%d redirects=%+v err=%s", resp.Status, resp.Redirects, resp.Error)
+	}
+	if hop := resp.Redirects[0]; hop.Proxy != resp.Proxy.URL {
+		t.Fatalf("redirect answered through %q, request went through %q", hop.Proxy, resp.Proxy.URL)
+	}
+	if p1.accepted.Load() != 1 || p2.accepted.Load() != 1 {
+		t.Fatalf("accepted: %d and %d", p1.accepted.Load(), p2.accepted.Load())
+	}
+}
+
+// Given unsupported redirect options
+// When a request is sent
+// Then it fails before anything is sent
+func TestRedirects_GivenInvalidOptions_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	for want, opts := range map[string]map[string]any{
+		"unsupported http.redirectCrossHost": {"redirectCrossHost": "maybe"},
+		"unsupported http.redirectDowngrade": {"redirectDowngrade": "never"},
+		"unsupported http.redirectProxy":     {"redirectProxy": "random"},
+		"unsupported http.redirectReferer":   {"redirectReferer": "origin"},
+	} {
+		if resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "http": opts}); !strings.Contains(resp.Error, want) {
+			t.Fatalf("%v: error=%q", opts, resp.Error)
+		}
+	}
+}
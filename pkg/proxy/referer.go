@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"math/rand"
+	"os"
+	"slices"
 	"time"
 )
 
@@ -19,30 +21,49 @@ func (c *Client) getRefererSlice() []string {
 
 // LoadReferers loads referers from a file into an atomic snapshot.
 // It ignores empty lines and lines starting with '#'. If the file path is empty,
-// it clears the referer list. It also compares mtime to avoid unnecessary reloads.
+// it clears the referer list. It also compares mtime and content to avoid unnecessary reloads.
 func (c *Client) LoadReferers(path string) error {
+	c.listMu.Lock()
+	defer c.listMu.Unlock()
+	return c.loadReferers(path)
+}
+
+// loadReferers is LoadReferers for callers holding listMu. The file is only read when its
+// mtime moved, and the snapshot is only swapped when the lines changed.
+func (c *Client) loadReferers(path string) error {
 	if path == "" {
 		// clear list
 		c.refererListVal.Store([]string{})
 		c.refererListPath = ""
 		c.refererListMTime = time.Time{}
+		c.recordListReload(listKindReferer)
 		return nil
 	}
 
-	lines, mtime, err := readLines(path)
+	fi, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-
 	// If same file and not modified, skip reload
-	if c.refererListPath == path && !mtime.After(c.refererListMTime) {
+	if c.refererListPath == path && !fi.ModTime().After(c.refererListMTime) {
+		return nil
+	}
+
+	lines, mtime, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	if c.refererListPath == path && slices.Equal(lines, c.getRefererSlice()) {
+		c.refererListMTime = mtime
 		return nil
 	}
 
-	// store snapshot atomically
+	// store snapshot atomically (can be empty)
 	c.refererListVal.Store(lines)
 	c.refererListPath = path
 	c.refererListMTime = mtime
+	c.recordListReload(listKindReferer)
+	c.startListWatcher()
 
 	return nil
 }
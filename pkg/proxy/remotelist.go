@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	remoteListTimeout  = 30 * time.Second
+	remoteListMaxBytes = 32 << 20
+)
+
+// isRemoteList reports whether a list path is an http(s) URL rather than a file.
+func isRemoteList(path string) bool {
+	p := strings.ToLower(path)
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://")
+}
+
+func (o ProxyListOptions) equal(p ProxyListOptions) bool {
+	return o.DefaultScheme == p.DefaultScheme &&
+		o.Name == p.Name &&
+		o.Strategy == p.Strategy &&
+		reflect.DeepEqual(o.Health, p.Health) &&
+		o.RefreshInterval == p.RefreshInterval &&
+		o.FieldPath == p.FieldPath &&
+		maps.Equal(o.Headers, p.Headers)
+}
+
+// refreshInterval parses RefreshInterval; zero disables background refresh.
+func (o ProxyListOptions) refreshInterval() (time.Duration, error) {
+	if o.RefreshInterval == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(o.RefreshInterval)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid refreshInterval %q", o.RefreshInterval)
+	}
+	return d, nil
+}
+
+// fetchProxyList downloads a remote list and returns its format and payload.
+// With FieldPath the response is decoded as JSON and the addressed value (an
+// array of entries or a newline-separated string) becomes the list.
+func fetchProxyList(ctx context.Context, src string, opts ProxyListOptions) (string, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteListTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid proxy list URL: %w", err)
+	}
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch proxy list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", nil, fmt.Errorf("failed to fetch proxy list: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, remoteListMaxBytes+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read proxy list: %w", err)
+	}
+	if len(data) > remoteListMaxBytes {
+		return "", nil, fmt.Errorf("proxy list exceeds %d bytes", remoteListMaxBytes)
+	}
+
+	if opts.FieldPath != "" {
+		return extractListField(data, opts.FieldPath)
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case strings.HasSuffix(mt, "json"):
+		return "json", data, nil
+	case strings.HasSuffix(mt, "yaml"):
+		return "yaml", data, nil
+	case mt == "text/csv":
+		return "csv", data, nil
+	}
+	u, _ := url.Parse(src)
+	return proxyListFormat(u.Path, data), data, nil
+}
+
+// extractListField walks a dotted path ("data.proxies", "result.0.items") in a JSON document.
+func extractListField(data []byte, path string) (string, []byte, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", nil, fmt.Errorf("failed to parse proxy list response: %w", err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]any:
+			next, ok := x[key]
+			if !ok {
+				return "", nil, fmt.Errorf("field %q not found in proxy list response", path)
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return "", nil, fmt.Errorf("field %q not found in proxy list response", path)
+			}
+			v = x[i]
+		default:
+			return "", nil, fmt.Errorf("field %q not found in proxy list response", path)
+		}
+	}
+	switch x := v.(type) {
+	case []any:
+		out, err := json.Marshal(x)
+		return "json", out, err
+	case string:
+		return "text", []byte(x), nil
+	default:
+		return "", nil, errors.New("field " + strconv.Quote(path) + " is neither a list nor a string")
+	}
+}
+
+// remoteLoad is a fetch of a remote list in progress; requests that need the
+// same list meanwhile wait for it instead of fetching it again.
+type remoteLoad struct {
+	src     string
+	opts    ProxyListOptions
+	done    chan struct{}
+	summary ProxyListSummary
+	err     error
+}
+
+// loadRemoteProxyList fetches a list from an http(s) source into pl and, with a
+// refresh interval, keeps re-fetching it in the background. The fetch runs
+// without pl.mu, which is only taken to swap the snapshot in.
+func (c *Client) loadRemoteProxyList(pl *proxyPool, src string, opts ProxyListOptions) (ProxyListSummary, error) {
+	pl.mu.Lock()
+	if pl.currentPath() == src && pl.opts.equal(opts) {
+		defer pl.mu.Unlock()
+		return pl.summary, nil
+	}
+	if l := pl.loading; l != nil && l.src == src && l.opts.equal(opts) {
+		pl.mu.Unlock()
+		<-l.done
+		return l.summary, l.err
+	}
+	l := &remoteLoad{src: src, opts: opts, done: make(chan struct{})}
+	pl.loading = l
+	pl.mu.Unlock()
+
+	l.summary, l.err = c.fetchRemoteProxyList(pl, l)
+	close(l.done)
+	return l.summary, l.err
+}
+
+// fetchRemoteProxyList fetches and parses the list of l, then swaps it into pl
+// unless another load replaced l as the load of pl meanwhile.
+func (c *Client) fetchRemoteProxyList(pl *proxyPool, l *remoteLoad) (ProxyListSummary, error) {
+	defer func() {
+		pl.mu.Lock()
+		if pl.loading == l {
+			pl.loading = nil
+		}
+		pl.mu.Unlock()
+	}()
+	src, opts := l.src, l.opts
+	interval, err := opts.refreshInterval()
+	if err != nil {
+		return ProxyListSummary{}, err
+	}
+	format, data, err := fetchProxyList(context.Background(), src, opts)
+	if err != nil {
+		return ProxyListSummary{}, err
+	}
+	parsed, err := parseProxyEntries(format, data)
+	if err != nil {
+		return ProxyListSummary{}, fmt.Errorf("%s: %w", src, err)
+	}
+	entries, summary := normalizeEntries(src, format, parsed, opts.DefaultScheme)
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if pl.loading != l {
+		// another list was loaded while fetching; it stays
+		return ProxyListSummary{}, fmt.Errorf("%s: superseded by a list loaded while fetching", src)
+	}
+	pl.stopRefresh()
+	pl.applySettings(opts)
+	pl.listVal.Store(newProxyList(entries))
+	pl.path.Store(src)
+	pl.mtime = time.Time{}
+	pl.sum = [32]byte{}
+	pl.opts = opts
+	pl.summary = summary
+	c.recordListReload(listKindProxy)
+	if interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		pl.stop = cancel
+		go c.refreshProxyList(ctx, pl, src, opts, interval, data)
+	}
+	return summary, nil
+}
+
+// refreshProxyList re-fetches a remote list every interval and swaps the
+// snapshot when the payload changed. A failed refresh keeps the current pool
+// and is reported as RefreshError in the summary. It stops once another list is loaded.
+func (c *Client) refreshProxyList(ctx context.Context, pl *proxyPool, src string, opts ProxyListOptions, interval time.Duration, last []byte) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		format, data, err := fetchProxyList(ctx, src, opts)
+		var parsed []*ProxyEntry
+		if err == nil && !bytes.Equal(data, last) {
+			parsed, err = parseProxyEntries(format, data)
+		}
+
+		pl.mu.Lock()
+		switch {
+		case ctx.Err() != nil:
+			// superseded by another load while fetching
+		case err != nil:
+			pl.summary.RefreshError = err.Error()
+		case bytes.Equal(data, last):
+			pl.summary.RefreshError = ""
+		default:
+			entries, summary := normalizeEntries(src, format, parsed, opts.DefaultScheme)
+			pl.listVal.Store(newProxyList(entries))
+			pl.summary = summary
+			c.recordListReload(listKindProxy)
+			last = data
+		}
+		pl.mu.Unlock()
+	}
+}
+
+// stopRefresh cancels the background refresh of the pool's remote list, if any. Callers hold pl.mu.
+func (pl *proxyPool) stopRefresh() {
+	if pl.stop != nil {
+		pl.stop()
+		pl.stop = nil
+	}
+}
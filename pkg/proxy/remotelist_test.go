@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Given a provider API that requires a token and nests the list in its JSON response
+// When loadProxyList is called with headers, fieldPath and refreshInterval
+// Then the pool is loaded and replaced in the background when the provider rotates it
+func TestLoadProxyList_GivenRemoteJSON_WhenRefreshed_ThenSnapshotSwapped(t *testing.T) {
+	t.Parallel()
+	var version atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if version.Load() == 0 {
+			io.WriteString(w, `{"data":{"proxies":["h1:1080:u:p", {"url":"socks5://h2:1080","country":"de"}]}}`)
+			return
+		}
+		io.WriteString(w, `{"data":{"proxies":["socks5://h3:1080"]}}`)
+	}))
+	defer ts.Close()
+
+	c := NewClient()
+	opts := ProxyListOptions{
+		DefaultScheme:   "socks5",
+		RefreshInterval: "20ms",
+		Headers:         map[string]string{"Authorization": "Bearer t0k"},
+		FieldPath:       "data.proxies",
+	}
+	s, err := c.LoadProxyListWithOptions(ts.URL+"/api/list", opts)
+	if err != nil {
+		t.Fatalf("LoadProxyListWithOptions: %v", err)
+	}
+	defer c.LoadProxyList("")
+	if s.Loaded != 2 || c.proxySnapshot().entries[0].URL != "socks5://u:p@h1:1080" || c.proxySnapshot().entries[1].Country != "de" {
+		t.Fatalf("summary=%+v entries=%+v", s, c.proxySnapshot().entries)
+	}
+
+	version.Store(1)
+	deadline := time.Now().Add(2 * time.Second)
+	for c.GetNextProxy() != "socks5://h3:1080" {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool was not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Given a remote list endpoint that rejects the request
+// When loadProxyList is called
+// Then an error is returned and the current pool is kept
+func TestLoadProxyList_GivenRemoteUnauthorized_WhenLoad_ThenErrorAndPoolKept(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	c := &Client{}
+	if err := c.LoadProxyList(writeProxiesFile(t, t.TempDir(), []string{"socks5://a:1080"})); err != nil {
+		t.Fatalf("LoadProxyList: %v", err)
+	}
+	err := c.LoadProxyList(ts.URL)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("err=%v", err)
+	}
+	if c.GetNextProxy() != "socks5://a:1080" {
+		t.Fatalf("pool was replaced")
+	}
+}
+
+// Given a plain-text remote list used as proxy.listPath
+// When several requests run
+// Then the list is fetched once instead of per request
+func TestRequest_GivenRemoteListPath_WhenRequests_ThenFetchedOnce(t *testing.T) {
+	t.Parallel()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	defer target.Close()
+	socks := startFakeSOCKS5(t, nil)
+	var fetches atomic.Int64
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, socks.URL()+"\n")
+	}))
+	defer list.Close()
+
+	c := newHealthClient()
+	for i := 0; i < 3; i++ {
+		resp := doRequest(t, c, map[string]any{"url": target.URL, "proxy": map[string]any{"listPath": list.URL + "/proxies.txt"}})
+		if resp.Status != http.StatusOK || resp.Proxy == nil || resp.Proxy.URL != socks.URL() {
+			t.Fatalf("status=%d proxy=%+v err=%s", resp.Status, resp.Proxy, resp.Error)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("list fetched %d times, want 1", n)
+	}
+}
+
+// Given a remote list endpoint that is slow to answer
+// When two loads of it run concurrently
+// Then the pool lock is free during the fetch and the list is fetched once for both
+func TestLoadProxyList_GivenSlowRemote_WhenConcurrentLoads_ThenFetchedOnceWithoutLock(t *testing.T) {
+	t.Parallel()
+	var fetches atomic.Int64
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		io.WriteString(w, "socks5://h1:1080\n")
+	}))
+	defer ts.Close()
+
+	c := &Client{}
+	src := ts.URL + "/proxies.txt"
+	done := make(chan ProxyListSummary, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			s, _ := c.LoadProxyListWithOptions(src, ProxyListOptions{})
+			done <- s
+		}()
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if !c.pool.mu.TryLock() {
+		t.Fatalf("pool lock held while fetching")
+	}
+	c.pool.mu.Unlock()
+	if c.proxyListLoaded(&c.pool, src) {
+		t.Fatalf("list reported loaded before the fetch finished")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if s := <-done; s.Loaded != 1 {
+			t.Fatalf("summary=%+v", s)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("list fetched %d times, want 1", n)
+	}
+	if !c.proxyListLoaded(&c.pool, src) {
+		t.Fatalf("list not reported loaded")
+	}
+}
+
+// Given a slow remote list with a refresh interval
+// When a list file is loaded into the pool while the fetch is in flight
+// Then the file stays loaded, the fetch fails as superseded and nothing refreshes
+func TestLoadProxyList_GivenSlowRemote_WhenFileLoadedMeanwhile_ThenFileKept(t *testing.T) {
+	t.Parallel()
+	var fetches atomic.Int64
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		io.WriteString(w, "socks5://remote:1080\n")
+	}))
+	defer ts.Close()
+
+	c := &Client{}
+	src := ts.URL + "/proxies.txt"
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.LoadProxyListWithOptions(src, ProxyListOptions{RefreshInterval: "10ms"})
+		errc <- err
+	}()
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	file := writeProxiesFile(t, t.TempDir(), []string{"socks5://file:1080"})
+	if err := c.LoadProxyList(file); err != nil {
+		t.Fatalf("load file: %v", err)
+	}
+
+	close(release)
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "superseded") {
+		t.Fatalf("remote load err=%v", err)
+	}
+	if !c.proxyListLoaded(&c.pool, file) {
+		t.Fatalf("file list replaced by the stale fetch")
+	}
+	if l := c.pool.snapshot(); len(l.entries) != 1 || l.entries[0].URL != "socks5://file:1080" {
+		t.Fatalf("entries=%+v", l.entries)
+	}
+	c.pool.mu.Lock()
+	refreshing := c.pool.stop != nil
+	c.pool.mu.Unlock()
+	if refreshing {
+		t.Fatalf("refresh of the superseded source is running")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("superseded source fetched %d times", n)
+	}
+}
+
+// Given a JSON document and dotted field paths
+// When extractListField is called
+// Then arrays become JSON lists, strings become text lists and missing paths fail
+func TestExtractListField_GivenPaths_WhenExtract_ThenFormat(t *testing.T) {
+	t.Parallel()
+	doc := []byte(`{"result":[{"items":["h:1"]}],"raw":"h:1\nh:2","n":3}`)
+	if f, data, err := extractListField(doc, "result.0.items"); err != nil || f != "json" || string(data) != `["h:1"]` {
+		t.Fatalf("format=%q data=%s err=%v", f, data, err)
+	}
+	if f, data, err := extractListField(doc, "raw"); err != nil || f != "text" || string(data) != "h:1\nh:2" {
+		t.Fatalf("format=%q data=%s err=%v", f, data, err)
+	}
+	for _, p := range []string{"missing", "result.1.items", "n"} {
+		if _, _, err := extractListField(doc, p); err == nil {
+			t.Fatalf("%q: expected error", p)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
+	"time"
 )
 
 func (c *Client) buildRequest(params RequestParams) (*http.Request, error) {
@@ -19,7 +21,8 @@ func (c *Client) buildRequest(params RequestParams) (*http.Request, error) {
 	}
 
 	var body io.Reader
-	if !(method == http.MethodGet || method == http.MethodHead) && params.Body != "" {
+	withBody := !(method == http.MethodGet || method == http.MethodHead)
+	if withBody && params.body == nil && params.Body != "" {
 		body = strings.NewReader(params.Body)
 	}
 
@@ -27,6 +30,22 @@ func (c *Client) buildRequest(params RequestParams) (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
+	if withBody && params.body != nil {
+		// set after NewRequest: files are opened and multipart streams start writing here
+		if req.Body, err = params.body.open(); err != nil {
+			return nil, err
+		}
+		req.GetBody = params.body.open
+		req.ContentLength = params.body.length()
+		if params.body.contentType != "" {
+			req.Header.Set("Content-Type", params.body.contentType)
+		}
+	}
+	if params.HTTP.Chunked && req.Body != nil {
+		// HTTP/1.1 only; HTTP/2 streams bodies in DATA frames either way
+		req.ContentLength = -1
+		req.TransferEncoding = []string{"chunked"}
+	}
 
 	// Respect user-provided Referer only if it has a non-empty value; otherwise we may auto-fill.
 	hasRef := false
@@ -36,6 +55,10 @@ func (c *Client) buildRequest(params RequestParams) (*http.Request, error) {
 		}
 		req.Header.Set(k, v)
 	}
+	if withBody && params.body != nil && params.body.parts != nil {
+		// the boundary is generated, so it cannot come from the headers
+		req.Header.Set("Content-Type", params.body.contentType)
+	}
 
 	// Referer (flat decision):
 	// - If user didn't set a non-empty Referer:
@@ -63,17 +86,22 @@ func (c *Client) buildRequest(params RequestParams) (*http.Request, error) {
 	}
 
 	// Compression strategy:
+	// - If AcceptEncoding is set: advertise those codings here; the Transport
+	//   then leaves the response alone and readBody decodes it (see encoding.go).
 	// - If AcceptGzip is true: do NOT set the header here. Let net/http Transport
 	//   add "Accept-Encoding: gzip" automatically and transparently decompress
 	//   the response (DisableCompression=false). This avoids manual gzip handling
 	//   and reduces allocations.
 	// - If AcceptGzip is false: explicitly request identity to avoid compressed
 	//   payloads and save CPU on decompression.
+	// An Accept-Encoding of the user's headers wins; its response is decoded
+	// by readBody too.
 	if _, ok := req.Header["Accept-Encoding"]; !ok {
-		// Compression strategy:
-		// - If AcceptGzip is true: rely on Transport to set gzip and auto-decompress
-		// - If AcceptGzip is false: explicitly request identity to avoid decompression
-		if !params.HTTP.AcceptGzip {
+		accept, _ := params.HTTP.acceptEncoding() // validated by request
+		switch {
+		case accept != "":
+			req.Header.Set("Accept-Encoding", accept)
+		case !params.HTTP.AcceptGzip:
 			req.Header.Set("Accept-Encoding", "identity")
 		}
 	}
@@ -82,27 +110,44 @@ func (c *Client) buildRequest(params RequestParams) (*http.Request, error) {
 }
 
 // executeRequestWithOpts performs the HTTP request and uses HTTPOptions to control behavior.
-// If httpOpts.DiscardBody is true, it will not read the response body and only return the status code.
+// How much of the response body is read and returned follows readBody.
 func (c *Client) executeRequestWithOpts(client *http.Client, req *http.Request, proxy string, httpOpts HTTPOptions) (*Response, error) {
+	stats := c.statsFor(proxy)
+	stats.begin()
+	defer stats.end()
+	var reused bool
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			// once per hop of followed redirects; the response reports the last one
+			stats.conn(info.Reused)
+			reused = info.Reused
+		},
+	}))
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		c.markBadProxy(proxy)
+		class := classifyError(err)
+		stats.failure(class, err)
 		return &Response{
-			Error: fmt.Sprintf("request error: %v, proxy: %s, url: %s", err, proxy, req.URL.String()),
+			Error:      fmt.Sprintf("request error: %v, proxy: %s, url: %s", err, proxy, req.URL.String()),
+			ErrorClass: class,
 		}, nil
 	}
 
-	// success path
-	c.unmarkBadProxy(proxy)
-
-	if httpOpts.DiscardBody {
-		resp.Body.Close()
-		return &Response{Status: resp.StatusCode}, nil
+	// A plain-HTTP request through an HTTP proxy gets 407 as a regular response.
+	stats.latency(time.Since(start))
+	var class ErrorClass
+	if resp.StatusCode == http.StatusProxyAuthRequired && proxy != "" {
+		class = ErrClassProxyAuth
+		stats.failure(class, fmt.Errorf("proxy: %s", resp.Status))
+	} else {
+		stats.success()
 	}
 
 	defer resp.Body.Close()
-	b, _ := io.ReadAll(resp.Body)
-	return &Response{Status: resp.StatusCode, Body: b}, nil
+	out := &Response{Status: resp.StatusCode, ErrorClass: class, ConnReused: reused, retryAfter: resp.Header.Get("Retry-After")}
+	readBody(resp, httpOpts, out)
+	return out, nil
 }
 
 func (c *Client) executeRequest(client *http.Client, req *http.Request, proxy string) (*Response, error) {
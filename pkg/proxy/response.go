@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Response types of http.responseType: how much of the body is kept and in
+// which Response field it is returned.
+const (
+	responseBinary = "binary" // Body, raw bytes (default)
+	responseText   = "text"   // Text, decoded to UTF-8 from the charset of the response
+	responseJSON   = "json"   // JSON, parsed
+	responseNone   = "none"   // nothing, the body is drained unread
+)
+
+var responseTypes = []string{responseBinary, responseText, responseJSON, responseNone}
+
+// bodyHashes are the digests of http.bodyHash.
+var bodyHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// responseMode returns the validated http.responseType. With bodyHash and no
+// responseType, only the digest is returned; discardBody is responseType none.
+func (o HTTPOptions) responseMode() (string, error) {
+	if o.BodyHash != "" {
+		if _, ok := bodyHashes[strings.ToLower(o.BodyHash)]; !ok {
+			return "", fmt.Errorf("unsupported http.bodyHash %q (want md5, sha1, sha256 or sha512)", o.BodyHash)
+		}
+	}
+	if o.MaxBodyBytes < 0 {
+		return "", fmt.Errorf("invalid http.maxBodyBytes %d", o.MaxBodyBytes)
+	}
+	mode := strings.ToLower(o.ResponseType)
+	switch {
+	case o.DiscardBody:
+		return responseNone, nil
+	case mode == "" && o.BodyHash != "":
+		return responseNone, nil
+	case mode == "":
+		return responseBinary, nil
+	case !slices.Contains(responseTypes, mode):
+		return "", fmt.Errorf("unsupported http.responseType %q (want one of %s)", o.ResponseType, strings.Join(responseTypes, ", "))
+	}
+	return mode, nil
+}
+
+// defaultDrainLimit is how much of an unwanted body is read to keep its
+// connection reusable, when http.drainLimit is not set.
+const defaultDrainLimit = 1 << 20
+
+// drainBufs are the buffers bodies are drained with.
+var drainBufs = sync.Pool{New: func() any {
+	b := make([]byte, 32<<10)
+	return &b
+}}
+
+// readBody reads the body of resp into out as the options ask, and sets
+// out.BodySize to the bytes read after decoding. What is not kept is drained, up to
+// drainLimit bytes, so that HTTP/1.1 connections go back to the pool instead
+// of being closed with unread data; hashed bodies are always read in full.
+// Discarded bodies are drained as they came over the wire, without decoding:
+// the bytes of one with a content coding are reported in out.EncodedSize only.
+func readBody(resp *http.Response, opts HTTPOptions, out *Response) {
+	mode, _ := opts.responseMode() // validated by request
+	var h hash.Hash
+	if opts.BodyHash != "" {
+		h = bodyHashes[strings.ToLower(opts.BodyHash)]()
+	}
+
+	wire := &countingReader{r: resp.Body}
+	if mode == responseNone && h == nil {
+		if opts.DrainLimit >= 0 && !drain(wire, drainLimit(opts)) {
+			out.Truncated = true
+		}
+		if bodyCoding(resp, opts.SkipDecompress) != "" {
+			out.EncodedSize = wire.n // nothing was decoded, so no decoded size
+		} else {
+			out.BodySize = wire.n
+		}
+		if resp.Uncompressed {
+			out.ContentEncoding = encodingGzip
+		}
+		if wire.err != nil {
+			out.Error = wire.err.Error()
+		}
+		return
+	}
+
+	body, enc := decoder(resp, wire, opts.SkipDecompress)
+	defer body.Close()
+	counted := &countingReader{r: body}
+	var r io.Reader = counted
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+	defer func() {
+		out.BodySize = counted.n
+		switch {
+		case enc != "":
+			out.ContentEncoding, out.EncodedSize = enc, wire.n
+		case resp.Uncompressed:
+			out.ContentEncoding = encodingGzip
+		}
+		if counted.err != nil && out.Error == "" {
+			out.Error = counted.err.Error()
+		}
+	}()
+
+	var data []byte
+	if mode != responseNone {
+		limited := r
+		if opts.MaxBodyBytes > 0 {
+			// one byte more than allowed tells a truncated body from one of exactly the limit
+			limited = io.LimitReader(r, opts.MaxBodyBytes+1)
+		}
+		data, _ = io.ReadAll(limited)
+		if opts.MaxBodyBytes > 0 && int64(len(data)) > opts.MaxBodyBytes {
+			data, out.Truncated = data[:opts.MaxBodyBytes], true
+		}
+	}
+	switch {
+	case h != nil:
+		drain(r, -1)
+		out.BodyHash = hex.EncodeToString(h.Sum(nil))
+	case opts.DrainLimit >= 0:
+		if !drain(r, drainLimit(opts)) {
+			out.Truncated = true
+		}
+	}
+
+	switch mode {
+	case responseBinary:
+		out.Body = data
+	case responseText:
+		out.Text = decodeText(data, resp.Header.Get("Content-Type"))
+	case responseJSON:
+		if out.Truncated {
+			out.Error = fmt.Sprintf("response json: body truncated at %d bytes", opts.MaxBodyBytes)
+			return
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &out.JSON); err != nil {
+				out.Error = fmt.Sprintf("response json: %v", err)
+			}
+		}
+	}
+}
+
+// decodeText converts data to UTF-8 from the charset of contentType, or the
+// one sniffed from the content, falling back to the bytes as they are.
+func decodeText(data []byte, contentType string) string {
+	r, err := charset.NewReader(bytes.NewReader(data), contentType)
+	if err != nil {
+		return string(data)
+	}
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return string(data)
+	}
+	return string(text)
+}
+
+// drainLimit returns how much of a body not kept is drained; defaultDrainLimit
+// when drainLimit is 0.
+func drainLimit(opts HTTPOptions) int64 {
+	if opts.DrainLimit == 0 {
+		return defaultDrainLimit
+	}
+	return opts.DrainLimit
+}
+
+// drain reads r into a pooled buffer until EOF or limit bytes (no limit when
+// negative), and reports whether EOF was reached.
+func drain(r io.Reader, limit int64) bool {
+	bp := drainBufs.Get().(*[]byte)
+	defer drainBufs.Put(bp)
+	var n int64
+	for {
+		buf := *bp
+		if limit >= 0 && int64(len(buf)) > limit-n+1 {
+			// one byte more than the limit tells whether the body ends there
+			buf = buf[:limit-n+1]
+		}
+		k, err := r.Read(buf)
+		n += int64(k)
+		switch {
+		case err == io.EOF:
+			return true
+		case err != nil:
+			return false
+		case limit >= 0 && n > limit:
+			return false
+		}
+	}
+}
+
+// countingReader counts the bytes read through it, and keeps the first error
+// other than EOF.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// contentServer answers /latin1 in ISO-8859-1, /json with a JSON document,
+// /big with 1 MiB of data and /short with a body cut off before its Content-Length.
+func contentServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latin1":
+			w.Header().Set("Content-Type", "text/plain; charset=iso-8859-1")
+			w.Write([]byte("caf\xe9"))
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"items":[1,2],"next":null}`))
+		case "/big":
+			w.Write(big)
+		case "/short":
+			// the connection is closed before the announced length
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("only ten b"))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Given a Latin-1 text response and a JSON response
+// When they are requested as text and as json
+// Then the text is decoded to UTF-8 and the JSON is parsed
+func TestResponseType_GivenTextAndJSON_WhenRequested_ThenDecoded(t *testing.T) {
+	t.Parallel()
+	ts := contentServer(t)
+	c := newHealthClient()
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL + "/latin1", "http": map[string]any{"responseType": "text"}})
+	if resp.Text != "café" || resp.Body != nil {
+		t.Fatalf("text=%q body=%q", resp.Text, resp.Body)
+	}
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/json", "http": map[string]any{"responseType": "json"}})
+	doc, ok := resp.JSON.(map[string]any)
+	if !ok || len(doc["items"].([]any)) != 2 || resp.Error != "" {
+		t.Fatalf("json=%#v err=%s", resp.JSON, resp.Error)
+	}
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/latin1", "http": map[string]any{"responseType": "json"}})
+	if !strings.Contains(resp.Error, "response json") || resp.Status != http.StatusOK {
+		t.Fatalf("status=%d err=%q", resp.Status, resp.Error)
+	}
+}
+
+// Given a 1 MiB response
+// When it is requested with maxBodyBytes, with bodyHash alone and with both
+// Then the kept body is cut and flagged, and the digest covers the whole body
+func TestResponseBody_GivenLimitAndHash_WhenRequested_ThenTruncatedAndDigested(t *testing.T) {
+	t.Parallel()
+	ts := contentServer(t)
+	c := newHealthClient()
+	want := digest(bytes.Repeat([]byte("x"), 1<<20))
+
+	resp := doRequest(t, c, map[string]any{"url": ts.URL + "/big", "http": map[string]any{"maxBodyBytes": int64(10)}})
+	if len(resp.Body) != 10 || !resp.Truncated || resp.BodyHash != "" {
+		t.Fatalf("len=%d truncated=%v hash=%q", len(resp.Body), resp.Truncated, resp.BodyHash)
+	}
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/json", "http": map[string]any{"maxBodyBytes": int64(1 << 10)}})
+	if resp.Truncated {
+		t.Fatalf("short body flagged as truncated")
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/big", "http": map[string]any{"bodyHash": "SHA256"}})
+	if resp.BodyHash != want || resp.Body != nil {
+		t.Fatalf("hash=%q len=%d", resp.BodyHash, len(resp.Body))
+	}
+	resp = doRequest(t, c, map[string]any{"url": ts.URL + "/big", "http": map[string]any{"bodyHash": "sha256", "responseType": "text", "maxBodyBytes": int64(3)}})
+	if resp.BodyHash != want || resp.Text != "xxx" || !resp.Truncated {
+		t.Fatalf("hash=%q text=%q truncated=%v", resp.BodyHash, resp.Text, resp.Truncated)
+	}
+}
+
+// Given a body cut off before its Content-Length
+// When it is kept and when it is discarded
+// Then the read error is reported either way
+func TestResponseBody_GivenShortBody_WhenKeptOrDiscarded_ThenReadError(t *testing.T) {
+	t.Parallel()
+	ts := contentServer(t)
+	c := newHealthClient()
+	for _, discard := range []bool{false, true} {
+		resp := doRequest(t, c, map[string]any{"url": ts.URL + "/short", "http": map[string]any{"discardBody": discard}})
+		if !strings.Contains(resp.Error, "unexpected EOF") || resp.BodySize != 10 {
+			t.Fatalf("discard=%v: size=%d err=%q", discard, resp.BodySize, resp.Error)
+		}
+	}
+}
+
+// Given unsupported response options
+// When a request is sent
+// Then it fails before anything is sent
+func TestResponseBody_GivenInvalidOptions_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	for want, opts := range map[string]map[string]any{
+		"unsupported http.responseType": {"responseType": "xml"},
+		"unsupported http.bodyHash":     {"bodyHash": "crc32"},
+		"invalid http.maxBodyBytes":     {"maxBodyBytes": int64(-1)},
+	} {
+		if resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "http": opts}); !strings.Contains(resp.Error, want) {
+			t.Fatalf("%v: error=%q", opts, resp.Error)
+		}
+	}
+}
+
+// Given a proxy and a target answering 512 KiB bodies over HTTP/1.1, more than
+// recent net/http versions drain on their own when a body is closed early
+// When bodies are discarded with draining, without it, and with a drain limit below the body size
+// Then drained connections are reused, the others are not, and bodySize counts what was read
+func TestDiscardBody_GivenDrain_WhenRepeated_ThenConnectionReused(t *testing.T) {
+	t.Parallel()
+	body := bytes.Repeat([]byte("z"), 512<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	t.Cleanup(ts.Close)
+	c := newHealthClient()
+
+	send := func(s *fakeSOCKS5, drainLimit int64) Response {
+		t.Helper()
+		return doRequest(t, c, map[string]any{
+			"url":   ts.URL,
+			"proxy": map[string]any{"url": s.URL()},
+			"http":  map[string]any{"discardBody": true, "drainLimit": drainLimit},
+		})
+	}
+	stats := func(s *fakeSOCKS5) ProxyStats {
+		for _, ps := range c.GetProxyStats() {
+			if ps.URL == s.URL() {
+				return ps
+			}
+		}
+		return ProxyStats{}
+	}
+
+	drained := startFakeSOCKS5(t, nil)
+	for i := range 3 {
+		resp := send(drained, 0)
+		if resp.BodySize != int64(len(body)) || resp.Body != nil || resp.Truncated || resp.ConnReused != (i > 0) {
+			t.Fatalf("request %d: body=%d size=%d truncated=%v reused=%v err=%s", i, len(resp.Body), resp.BodySize, resp.Truncated, resp.ConnReused, resp.Error)
+		}
+	}
+	if ps := stats(drained); ps.ConnsNew != 1 || ps.ConnsReused != 2 || drained.accepted.Load() != 1 {
+		t.Fatalf("drained: new=%d reused=%d accepted=%d", ps.ConnsNew, ps.ConnsReused, drained.accepted.Load())
+	}
+
+	closed := startFakeSOCKS5(t, nil)
+	for range 2 {
+		if resp := send(closed, -1); resp.BodySize != 0 {
+			t.Fatalf("undrained body read: %d", resp.BodySize)
+		}
+	}
+	limited := startFakeSOCKS5(t, nil)
+	for range 2 {
+		if resp := send(limited, 1024); !resp.Truncated || resp.BodySize != 1025 {
+			t.Fatalf("limited: size=%d truncated=%v", resp.BodySize, resp.Truncated)
+		}
+	}
+	for i, s := range []*fakeSOCKS5{closed, limited} {
+		if ps := stats(s); ps.ConnsNew != 2 || ps.ConnsReused != 0 {
+			t.Fatalf("%d: new=%d reused=%d accepted=%d", i, ps.ConnsNew, ps.ConnsReused, s.accepted.Load())
+		}
+	}
+}
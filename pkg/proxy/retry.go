@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target retries repeat a request through the same proxy when the target
+// answered with a transient status or the exchange was cut off. They are
+// separate from proxy failover (proxy.maxRetries), which moves to another
+// proxy when the proxy itself failed.
+
+var (
+	defaultRetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	defaultRetryErrors   = []ErrorClass{ErrClassTargetReset, ErrClassTargetTimeout}
+
+	// idempotentMethods may be retried without http.retryNonIdempotent (RFC 9110 9.2.2).
+	idempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+)
+
+const defaultMaxRetryAfter = 30 * time.Second
+
+// AttemptInfo describes one attempt of a request that was attempted more than once.
+type AttemptInfo struct {
+	Status     int        `json:"status,omitempty"`
+	ErrorClass ErrorClass `json:"errorClass,omitempty" js:"errorClass"`
+	Error      string     `json:"error,omitempty"`
+	Proxy      string     `json:"proxy,omitempty"`
+	Duration   float64    `json:"duration"` // ms
+	// Next is what followed: "retry" (same proxy) or "failover" (another
+	// proxy), for the Reason (status code or error class), after Wait ms.
+	Next   string  `json:"next,omitempty"`
+	Reason string  `json:"reason,omitempty"`
+	Wait   float64 `json:"wait,omitempty"`
+}
+
+// retryPolicy is the resolved form of the http retry options.
+type retryPolicy struct {
+	retries       int
+	statuses      []int
+	classes       []ErrorClass
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	maxRetryAfter time.Duration
+	anyMethod     bool
+}
+
+func (o HTTPOptions) retryPolicy() (retryPolicy, error) {
+	p := retryPolicy{
+		retries:       o.Retries,
+		statuses:      defaultRetryStatuses,
+		classes:       defaultRetryErrors,
+		backoff:       defaultRetryBackoff,
+		maxBackoff:    defaultRetryMaxBackoff,
+		maxRetryAfter: defaultMaxRetryAfter,
+		anyMethod:     o.RetryNonIdempotent,
+	}
+	if len(o.RetryStatuses) > 0 {
+		p.statuses = o.RetryStatuses
+	}
+	if len(o.RetryErrors) > 0 {
+		p.classes = make([]ErrorClass, len(o.RetryErrors))
+		for i, c := range o.RetryErrors {
+			p.classes[i] = ErrorClass(strings.TrimSpace(c))
+		}
+	}
+	for _, opt := range []struct {
+		name, value string
+		dst         *time.Duration
+	}{
+		{"retryBackoff", o.RetryBackoff, &p.backoff},
+		{"retryMaxBackoff", o.RetryMaxBackoff, &p.maxBackoff},
+		{"maxRetryAfter", o.MaxRetryAfter, &p.maxRetryAfter},
+	} {
+		if opt.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(opt.value)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid http.%s %q", opt.name, opt.value)
+		}
+		*opt.dst = d
+	}
+	p.maxBackoff = max(p.maxBackoff, p.backoff)
+	return p, nil
+}
+
+// reason returns why resp of a method request should be retried, "" when it
+// should not. An empty method is GET, as in executeRequestWithOpts.
+func (p retryPolicy) reason(method string, resp *Response) string {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !p.anyMethod && !slices.Contains(idempotentMethods, method) {
+		return ""
+	}
+	if resp.ErrorClass != "" {
+		if slices.Contains(p.classes, resp.ErrorClass) {
+			return string(resp.ErrorClass)
+		}
+		return ""
+	}
+	if resp.Error == "" && slices.Contains(p.statuses, resp.Status) {
+		return strconv.Itoa(resp.Status)
+	}
+	return ""
+}
+
+// wait returns the delay before retry number retry (0-based): the backoff, or
+// the longer Retry-After of the response. ok is false when Retry-After asks
+// for more than maxRetryAfter, or the wait would not fit the deadline of ctx.
+func (p retryPolicy) wait(ctx context.Context, retry int, resp *Response, now time.Time) (d time.Duration, ok bool) {
+	d = backoffDelay(p.backoff, p.maxBackoff, retry)
+	if after, found := parseRetryAfter(resp.retryAfter, now); found {
+		if after > p.maxRetryAfter {
+			return 0, false
+		}
+		d = max(d, after)
+	}
+	if deadline, has := ctx.Deadline(); has && now.Add(d).After(deadline) {
+		return 0, false
+	}
+	return d, true
+}
+
+// parseRetryAfter parses a Retry-After value, delay-seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(n, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// backoffDelay returns the wait before retry number attempt (0-based): full
+// jitter over a window doubling from base, capped at maxBackoff.
+func backoffDelay(base, maxBackoff time.Duration, attempt int) time.Duration {
+	window := base
+	for i := 0; i < attempt && window < maxBackoff; i++ {
+		window *= 2
+	}
+	if window > maxBackoff {
+		window = maxBackoff
+	}
+	if window <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(window) + 1))
+}
+
+// sleepCtx waits for d or until ctx is done, and reports whether the full wait elapsed.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func attemptInfo(resp *Response, proxy string, took time.Duration) AttemptInfo {
+	return AttemptInfo{
+		Status:     resp.Status,
+		ErrorClass: resp.ErrorClass,
+		Error:      resp.Error,
+		Proxy:      proxy,
+		Duration:   float64(took) / float64(time.Millisecond),
+	}
+}
+
+// followedBy records on the attempt what came next, after waiting d.
+func (a *AttemptInfo) followedBy(next, reason string, d time.Duration) {
+	a.Next, a.Reason, a.Wait = next, reason, float64(d)/float64(time.Millisecond)
+}
+
+// failoverReason names the failure that made a request fail over.
+func failoverReason(resp *Response) string {
+	if resp.ErrorClass != "" {
+		return string(resp.ErrorClass)
+	}
+	return strconv.Itoa(resp.Status)
+}
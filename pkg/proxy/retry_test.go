@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyTarget answers the first n requests with status and Retry-After, then 200.
+func flakyTarget(t *testing.T, n int64, status int, retryAfter string) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= n {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		io.WriteString(w, "OK")
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &hits
+}
+
+// Given Retry-After values as seconds, HTTP dates and garbage
+// When they are parsed
+// Then seconds and future dates give a delay, past dates none, garbage is ignored
+func TestParseRetryAfter_GivenFormats_WhenParsed_ThenDelay(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in    string
+		want  time.Duration
+		found bool
+	}{
+		{"3", 3 * time.Second, true},
+		{" 0 ", 0, true},
+		{"Wed, 01 May 2024 12:00:05 GMT", 5 * time.Second, true},
+		{"Wed, 01 May 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range cases {
+		if got, found := parseRetryAfter(tc.in, now); got != tc.want || found != tc.found {
+			t.Fatalf("%q: got %v,%v want %v,%v", tc.in, got, found, tc.want, tc.found)
+		}
+	}
+}
+
+// Given a target that answers 503 with Retry-After: 0 twice
+// When a GET allows two retries
+// Then it succeeds on the third attempt and every attempt is reported
+func TestRetry_GivenTransientStatus_WhenRetried_ThenSucceedsAndTriesReported(t *testing.T) {
+	t.Parallel()
+	ts, hits := flakyTarget(t, 2, http.StatusServiceUnavailable, "0")
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "http": map[string]any{"retries": int64(2), "retryBackoff": "1ms"}})
+	if resp.Status != http.StatusOK || hits.Load() != 3 || resp.Attempts != 3 {
+		t.Fatalf("status=%d hits=%d attempts=%d err=%s", resp.Status, hits.Load(), resp.Attempts, resp.Error)
+	}
+	if len(resp.Tries) != 3 || resp.Tries[0].Status != 503 || resp.Tries[0].Next != "retry" || resp.Tries[0].Reason != "503" || resp.Tries[2].Next != "" {
+		t.Fatalf("tries=%+v", resp.Tries)
+	}
+}
+
+// Given a target that keeps answering 429
+// When a POST is sent with retries, with and without retryNonIdempotent
+// Then it is only retried when the request opts in
+func TestRetry_GivenPost_WhenNotOptedIn_ThenNotRetried(t *testing.T) {
+	t.Parallel()
+	ts, hits := flakyTarget(t, 100, http.StatusTooManyRequests, "")
+	c := newHealthClient()
+	params := map[string]any{"url": ts.URL, "method": "POST", "body": "x", "http": map[string]any{"retries": int64(1), "retryBackoff": "1ms"}}
+	if resp := doRequest(t, c, params); resp.Status != http.StatusTooManyRequests || hits.Load() != 1 || resp.Tries != nil {
+		t.Fatalf("status=%d hits=%d tries=%+v", resp.Status, hits.Load(), resp.Tries)
+	}
+
+	params["http"] = map[string]any{"retries": int64(1), "retryBackoff": "1ms", "retryNonIdempotent": true}
+	if resp := doRequest(t, c, params); resp.Attempts != 2 || hits.Load() != 3 {
+		t.Fatalf("attempts=%d hits=%d", resp.Attempts, hits.Load())
+	}
+}
+
+// Given a target asking to come back after a minute, and retries limited by maxRetryAfter
+// When the request is sent
+// Then it is not retried and the 503 is returned right away
+func TestRetry_GivenLongRetryAfter_WhenOverMax_ThenNotRetried(t *testing.T) {
+	t.Parallel()
+	ts, hits := flakyTarget(t, 1, http.StatusServiceUnavailable, "60")
+	c := newHealthClient()
+	start := time.Now()
+	resp := doRequest(t, c, map[string]any{"url": ts.URL, "http": map[string]any{"retries": int64(3), "maxRetryAfter": "5s"}})
+	if resp.Status != http.StatusServiceUnavailable || hits.Load() != 1 || time.Since(start) > 5*time.Second {
+		t.Fatalf("status=%d hits=%d", resp.Status, hits.Load())
+	}
+
+	resp = doRequest(t, c, map[string]any{"url": ts.URL, "http": map[string]any{"retries": int64(1), "retryBackoff": "soon"}})
+	if !strings.Contains(resp.Error, "invalid http.retryBackoff") {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
+
+// Given a VU whose request is retried once
+// When the request completes
+// Then the retry is pushed as proxy_request_retries tagged with kind and reason
+func TestRequest_GivenRetry_WhenVURequests_ThenRetryMetricPushed(t *testing.T) {
+	t.Parallel()
+	ts, _ := flakyTarget(t, 1, http.StatusBadGateway, "")
+	mi, vu, _ := newTestModule(t)
+	samples := vu.enterVU()
+
+	if _, err := mi.request(map[string]any{"url": ts.URL, "http": map[string]any{"retries": int64(1), "retryBackoff": "1ms"}}); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	retries := drainSamples(samples)["proxy_request_retries"]
+	if len(retries) != 1 || retries[0].Value != 1 {
+		t.Fatalf("samples=%v", retries)
+	}
+	kind, _ := retries[0].Tags.Get("kind")
+	reason, _ := retries[0].Tags.Get("reason")
+	if kind != "retry" || reason != "502" {
+		t.Fatalf("kind=%q reason=%q", kind, reason)
+	}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Proxy URL templates. Rotating residential gateways encode the session and
+// geo in the username (user-country-{country}-session-{session}:pass@gw:7777);
+// the placeholders are expanded for every request:
+//
+//	{session}  sticky session id, rotated per sessionRotation
+//	{vu}       VU id (0 outside a VU)
+//	{rand:N}   N random characters, fresh per request (N defaults to 8)
+//	{country}  the entry's country, or the country of proxy.filter
+//
+// Each expansion is a separate identity for health and transports, while
+// stats and limits stay with the template (the gateway).
+
+const (
+	sessionIDLength = 8
+	maxRandLength   = 64
+)
+
+// Session rotation policies of templated proxy URLs. Whatever the policy, a
+// session whose identity fails for a reason counted by the health policy is rotated.
+const (
+	sessionRotateIteration = "iteration" // new session every VU iteration (default)
+	sessionRotateRequest   = "request"   // new session every request
+	sessionRotateCount     = "count"     // new session every sessionRequests requests
+	sessionRotateFailure   = "failure"   // keep the session until it fails
+)
+
+var sessionRotations = []string{sessionRotateIteration, sessionRotateRequest, sessionRotateCount, sessionRotateFailure}
+
+var placeholderRe = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// isProxyTemplate reports whether u has placeholders to expand.
+func isProxyTemplate(u string) bool {
+	return placeholderRe.MatchString(u)
+}
+
+// checkPlaceholders rejects unknown placeholders so typos surface at load time.
+func checkPlaceholders(u string) error {
+	for _, m := range placeholderRe.FindAllStringSubmatch(u, -1) {
+		name, arg, _ := strings.Cut(m[1], ":")
+		switch name {
+		case "session", "vu", "country":
+			if arg == "" {
+				continue
+			}
+		case "rand":
+			if n, err := strconv.Atoi(arg); arg == "" || (err == nil && n > 0 && n <= maxRandLength) {
+				continue
+			}
+		}
+		return fmt.Errorf("unknown placeholder %s", m[0])
+	}
+	return nil
+}
+
+// maskPlaceholders replaces placeholders with tokens that are valid in every
+// part of a URL, so templates can be validated like plain URLs; unmask restores them.
+func maskPlaceholders(u string) (masked string, unmask func(string) string) {
+	var found []string
+	masked = placeholderRe.ReplaceAllStringFunc(u, func(p string) string {
+		found = append(found, p)
+		return fmt.Sprintf("xk6tpl%dx", len(found)-1)
+	})
+	return masked, func(s string) string {
+		for i, p := range found {
+			s = strings.Replace(s, fmt.Sprintf("xk6tpl%dx", i), p, 1)
+		}
+		return s
+	}
+}
+
+// sessionPolicy is the parsed rotation policy of a request.
+type sessionPolicy struct {
+	rotate string
+	every  int
+}
+
+func (o ProxyOptions) sessionPolicy() (sessionPolicy, error) {
+	p := sessionPolicy{rotate: strings.ToLower(o.SessionRotation), every: o.SessionRequests}
+	if p.rotate == "" {
+		p.rotate = sessionRotateIteration
+	}
+	if !slices.Contains(sessionRotations, p.rotate) {
+		return p, fmt.Errorf("unsupported sessionRotation %q (want one of %s)", o.SessionRotation, strings.Join(sessionRotations, ", "))
+	}
+	if p.rotate == sessionRotateCount && p.every <= 0 {
+		return p, fmt.Errorf("sessionRotation %q needs sessionRequests > 0", sessionRotateCount)
+	}
+	return p, nil
+}
+
+// vuContext identifies the caller of a request for template expansion.
+type vuContext struct {
+	id        uint64
+	iteration int64 // -1 outside a VU iteration: sessions then only rotate by count or failure
+	sessions  *sessionStore
+	cookies   *cookieJar // "vu" cookie jar
+}
+
+// sessionStore holds the sticky sessions of one VU (or of Go callers), keyed by pool and template.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*proxySession
+}
+
+type proxySession struct {
+	id        string
+	iteration int64
+	requests  int
+	identity  string // URL of the last expansion
+}
+
+func newSessionID() string {
+	rnd := pathRandPool.Get().(*rand.Rand)
+	defer pathRandPool.Put(rnd)
+	return randomString(rnd, sessionIDLength)
+}
+
+// expired reports whether the session must be replaced before the next request.
+func (s *proxySession) expired(p sessionPolicy, iteration int64) bool {
+	switch p.rotate {
+	case sessionRotateRequest:
+		return true
+	case sessionRotateCount:
+		return s.requests >= p.every
+	case sessionRotateIteration:
+		return s.iteration != iteration
+	}
+	return false
+}
+
+// expand returns the URL of template e for this request. A session whose
+// identity is ejected is rotated once. done must be called with whether the
+// request failed in a way the health policy counts; it rotates failed sessions
+// and drops the transports of identities that will not be used again.
+func (vc *vuContext) expand(c *Client, pl *proxyPool, e *ProxyEntry, o ProxyOptions, p sessionPolicy) (identity string, done func(failed bool)) {
+	country := e.Country
+	if country == "" && o.Filter != nil {
+		country = o.Filter.Country
+	}
+	key := pl.label() + "|" + e.URL
+
+	st := vc.sessions
+	st.mu.Lock()
+	if st.sessions == nil {
+		st.sessions = map[string]*proxySession{}
+	}
+	var retired []string
+	s := st.sessions[key]
+	rotate := func() {
+		if s != nil {
+			retired = append(retired, s.identity)
+		}
+		s = &proxySession{id: newSessionID(), iteration: vc.iteration}
+		st.sessions[key] = s
+	}
+	if s == nil || s.expired(p, vc.iteration) {
+		rotate()
+	}
+	identity = expandProxyTemplate(e.URL, s.id, vc.id, country)
+	if !c.proxyAvailable(pl, identity) {
+		rotate()
+		identity = expandProxyTemplate(e.URL, s.id, vc.id, country)
+	}
+	s.requests++
+	s.identity = identity
+	st.mu.Unlock()
+
+	for _, old := range retired {
+		if old != identity {
+			c.retireIdentity(pl, old)
+		}
+	}
+	perRequest := strings.Contains(e.URL, "{rand")
+	return identity, func(failed bool) {
+		if failed {
+			st.mu.Lock()
+			if cur := st.sessions[key]; cur == s {
+				delete(st.sessions, key)
+			}
+			st.mu.Unlock()
+		}
+		if failed || perRequest || p.rotate == sessionRotateRequest {
+			c.retireIdentity(pl, identity)
+		}
+	}
+}
+
+// retireIdentity releases what was kept for an expanded proxy URL of pl that
+// will not be used again: its transports, "proxy" cookie jar, health, exit IP
+// and stats.
+func (c *Client) retireIdentity(pl *proxyPool, identity string) {
+	c.dropClients(identity)
+	c.proxyCookies.Delete(identity)
+	pl.health.Delete(identity)
+	c.exitIPs.Delete(identity)
+	c.proxyStats.Delete(identity)
+}
+
+// expandProxyTemplate substitutes the placeholders of tpl.
+func expandProxyTemplate(tpl, session string, vu uint64, country string) string {
+	rnd := pathRandPool.Get().(*rand.Rand)
+	defer pathRandPool.Put(rnd)
+	return placeholderRe.ReplaceAllStringFunc(tpl, func(m string) string {
+		name, arg, _ := strings.Cut(m[1:len(m)-1], ":")
+		switch name {
+		case "session":
+			return session
+		case "vu":
+			return strconv.FormatUint(vu, 10)
+		case "country":
+			return country
+		case "rand":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				n = sessionIDLength
+			}
+			return randomString(rnd, min(n, maxRandLength))
+		}
+		return m
+	})
+}
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Given proxy URL templates in URL and vendor formats
+// When they are normalized
+// Then the placeholders survive and unknown ones are rejected
+func TestNormalizeProxyURL_GivenTemplate_WhenNormalized_ThenPlaceholdersKept(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"user-country-{country}-session-{session}:pass@gw:7777": "socks5://user-country-{country}-session-{session}:pass@gw:7777",
+		"gw:7777:user-{vu}-{rand:6}:pass":                       "socks5://user-%7Bvu%7D-%7Brand:6%7D:pass@gw:7777",
+		"http://u-{session}:p@gw:8000":                          "http://u-{session}:p@gw:8000",
+	}
+	for in, want := range cases {
+		got, err := normalizeProxyURL(in, "socks5")
+		if err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		// vendor formats are rebuilt with url.UserPassword, which escapes the braces
+		want = strings.NewReplacer("%7B", "{", "%7D", "}").Replace(want)
+		if got != want {
+			t.Fatalf("%s: got %q want %q", in, got, want)
+		}
+	}
+	for _, bad := range []string{"socks5://u-{sesion}:p@gw:1080", "socks5://u-{rand:0}:p@gw:1080", "socks5://u-{vu:2}:p@gw:1080"} {
+		if _, err := normalizeProxyURL(bad, "socks5"); err == nil || !strings.Contains(err.Error(), "unknown placeholder") {
+			t.Fatalf("%s: err=%v", bad, err)
+		}
+	}
+}
+
+// Given a template with every placeholder
+// When expanded
+// Then each is replaced by its value
+func TestExpandProxyTemplate_GivenPlaceholders_WhenExpanded_ThenSubstituted(t *testing.T) {
+	t.Parallel()
+	got := expandProxyTemplate("socks5://u-{country}-{session}-{vu}-{rand:5}-{rand}:p@gw:1080", "abcdefgh", 7, "de")
+	if !regexp.MustCompile(`^socks5://u-de-abcdefgh-7-[a-z]{5}-[a-z]{8}:p@gw:1080$`).MatchString(got) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func expandTimes(c *Client, vc *vuContext, e *ProxyEntry, p sessionPolicy, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		var done func(bool)
+		out[i], done = vc.expand(c, &c.pool, e, ProxyOptions{}, p)
+		done(false)
+	}
+	return out
+}
+
+// Given each session rotation policy
+// When a VU sends requests
+// Then sessions are renewed per iteration, per request or every N requests
+func TestSession_GivenRotationPolicies_WhenExpanded_ThenRotatedAccordingly(t *testing.T) {
+	t.Parallel()
+	e := &ProxyEntry{URL: "socks5://u-{session}:p@gw:1080"}
+
+	c := newHealthClient()
+	vc := &vuContext{id: 1, iteration: 0, sessions: &sessionStore{}}
+	it := expandTimes(c, vc, e, sessionPolicy{rotate: sessionRotateIteration}, 2)
+	vc.iteration = 1
+	next := expandTimes(c, vc, e, sessionPolicy{rotate: sessionRotateIteration}, 1)
+	if it[0] != it[1] || next[0] == it[0] {
+		t.Fatalf("iteration: %v then %v", it, next)
+	}
+
+	vc = &vuContext{id: 1, sessions: &sessionStore{}}
+	req := expandTimes(c, vc, e, sessionPolicy{rotate: sessionRotateRequest}, 2)
+	if req[0] == req[1] {
+		t.Fatalf("request: %v", req)
+	}
+
+	vc = &vuContext{id: 1, sessions: &sessionStore{}}
+	cnt := expandTimes(c, vc, e, sessionPolicy{rotate: sessionRotateCount, every: 2}, 3)
+	if cnt[0] != cnt[1] || cnt[1] == cnt[2] {
+		t.Fatalf("count: %v", cnt)
+	}
+}
+
+// Given the failure rotation policy
+// When a session fails or its identity is ejected
+// Then the next request uses a new session
+func TestSession_GivenFailurePolicy_WhenFailedOrEjected_ThenRotated(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	e := &ProxyEntry{URL: "socks5://u-{session}:p@gw:1080"}
+	p := sessionPolicy{rotate: sessionRotateFailure}
+	vc := &vuContext{id: 1, sessions: &sessionStore{}}
+
+	first, done := vc.expand(c, &c.pool, e, ProxyOptions{}, p)
+	done(true)
+	second := expandTimes(c, vc, e, p, 2)
+	if second[0] == first || second[0] != second[1] {
+		t.Fatalf("first=%s then %v", first, second)
+	}
+
+	c.markBadProxy(&c.pool, second[1])
+	third := expandTimes(c, vc, e, p, 1)
+	if third[0] == second[1] {
+		t.Fatalf("ejected identity %s was reused", third[0])
+	}
+}
+
+// Given a templated SOCKS proxy that rejects the first session
+// When two requests are sent
+// Then the failed session is rotated, health is per identity and stats per template
+func TestSession_GivenTemplatedProxy_WhenSessionRejected_ThenNewSessionUsed(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+	var (
+		mu    sync.Mutex
+		users []string
+	)
+	s := startFakeSOCKS5(t, func(s *fakeSOCKS5) {
+		s.Auth = func(user, pass string) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			users = append(users, user)
+			return len(users) > 1 && pass == "pw"
+		}
+	})
+	tpl := "socks5://cust-{session}:pw@" + s.Addr()
+
+	c := newHealthClient()
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: tpl}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	params := map[string]any{"url": ts.URL, "proxy": map[string]any{"pool": "default", "sessionRotation": "failure"}}
+	first := doRequest(t, c, params)
+	if first.ErrorClass == "" || first.Proxy == nil || first.Proxy.URL != tpl || first.Proxy.Identity == "" {
+		t.Fatalf("first: class=%q err=%s proxy=%+v", first.ErrorClass, first.Error, first.Proxy)
+	}
+	second := doRequest(t, c, params)
+	if second.Status != http.StatusOK || second.Proxy.Identity == first.Proxy.Identity {
+		t.Fatalf("second: status=%d err=%s proxy=%+v", second.Status, second.Error, second.Proxy)
+	}
+
+	mu.Lock()
+	if len(users) != 2 || users[0] == users[1] || !strings.HasPrefix(users[1], "cust-") {
+		t.Fatalf("users=%v", users)
+	}
+	mu.Unlock()
+	if _, ok := c.pool.health.Load(first.Proxy.Identity); ok {
+		t.Fatalf("retired identity %s is still in the health cache", first.Proxy.Identity)
+	}
+	if stats := c.GetProxyStats(); len(stats) != 1 || stats[0].URL != tpl || stats[0].Successes != 1 {
+		t.Fatalf("stats=%+v", stats)
+	}
+}
+
+// Given a templated proxy rotated per request under a rate-based health policy
+// When many requests are sent
+// Then the health entries, clients and exit IPs of retired identities are dropped
+func TestSession_GivenPerRequestRotation_WhenRotated_ThenRetiredIdentitiesForgotten(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+	s := startFakeSOCKS5(t, nil)
+	tpl := "socks5://cust-{session}:pw@" + s.Addr()
+
+	c := healthClient(HealthPolicy{BaseTTL: "1m", FailureWindow: "1m"})
+	if _, err := c.SetProxies("", []ProxyEntry{{URL: tpl}}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	params := map[string]any{"url": ts.URL, "proxy": map[string]any{"pool": "default", "sessionRotation": "request"}}
+	var identities []string
+	for range 5 {
+		resp := doRequest(t, c, params)
+		if resp.Status != http.StatusOK {
+			t.Fatalf("status=%d err=%s", resp.Status, resp.Error)
+		}
+		identities = append(identities, resp.Proxy.Identity)
+		c.exitIPs.Store(resp.Proxy.Identity, "192.0.2.1")
+	}
+	for _, id := range identities[:len(identities)-1] {
+		if _, ok := c.pool.health.Load(id); ok {
+			t.Fatalf("retired identity %s is still in the health cache", id)
+		}
+		if _, ok := c.exitIPs.Load(id); ok {
+			t.Fatalf("retired identity %s still has an exit IP", id)
+		}
+		if _, ok := c.clientKeys.Load(id); ok {
+			t.Fatalf("retired identity %s still has clients", id)
+		}
+	}
+	clients := 0
+	c.clients.Range(func(_, _ any) bool { clients++; return true })
+	if clients > 1 {
+		t.Fatalf("clients=%d, want at most the last identity's", clients)
+	}
+}
+
+// Given a plain proxy URL with a brace in its password
+// When checked for placeholders
+// Then it is not a template
+func TestIsProxyTemplate_GivenBraceInPassword_WhenChecked_ThenNotTemplate(t *testing.T) {
+	t.Parallel()
+	if isProxyTemplate("socks5://u:p{ss@gw:1080") {
+		t.Fatal("lone brace taken for a placeholder")
+	}
+	if !isProxyTemplate("socks5://u-{session}:p@gw:1080") {
+		t.Fatal("placeholder not detected")
+	}
+}
+
+// Given an unknown sessionRotation
+// When a request is sent
+// Then it fails before anything is sent
+func TestSession_GivenUnknownRotation_WhenRequest_ThenError(t *testing.T) {
+	t.Parallel()
+	c := newHealthClient()
+	resp := doRequest(t, c, map[string]any{"url": "http://example.invalid/", "proxy": map[string]any{"sessionRotation": "hourly"}})
+	if !strings.Contains(resp.Error, "unsupported sessionRotation") {
+		t.Fatalf("error=%q", resp.Error)
+	}
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeSOCKS5 is a minimal SOCKS5 server for tests. It supports the no-auth and
+// username/password methods, CONNECT and UDP ASSOCIATE; Reply forces a failure reply code.
+// Auth, when set, checks the credentials instead of User/Pass.
+type fakeSOCKS5 struct {
+	ln       net.Listener
+	User     string
+	Pass     string
+	Auth     func(user, pass string) bool
+	Reply    byte
+	Source   string // local IP for upstream connections, e.g. "127.0.0.2"
+	Relay    string // host name to answer UDP ASSOCIATE with instead of the IP
+	accepted atomic.Int64
+	wg       sync.WaitGroup
+}
+
+func startFakeSOCKS5(t *testing.T, configure func(*fakeSOCKS5)) *fakeSOCKS5 {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeSOCKS5{ln: ln}
+	if configure != nil {
+		configure(s)
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *fakeSOCKS5) Addr() string { return s.ln.Addr().String() }
+
+// URL returns the proxy URL, including credentials when the server requires them.
+func (s *fakeSOCKS5) URL() string {
+	if s.User != "" || s.Auth != nil {
+		return "socks5://" + s.User + ":" + s.Pass + "@" + s.Addr()
+	}
+	return "socks5://" + s.Addr()
+}
+
+func (s *fakeSOCKS5) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSOCKS5) handle(conn net.Conn) {
+	defer conn.Close()
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil || hdr[0] != 5 {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	if s.User != "" || s.Auth != nil {
+		_, _ = conn.Write([]byte{5, 2})
+		if !s.authenticate(conn) {
+			return
+		}
+	} else {
+		_, _ = conn.Write([]byte{5, 0})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	host, err := readSOCKSAddr(conn, req[3])
+	if err != nil {
+		return
+	}
+	if s.Reply != 0 {
+		_, _ = conn.Write([]byte{5, s.Reply, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	if req[1] == 3 {
+		s.associate(conn)
+		return
+	}
+	d := net.Dialer{}
+	if s.Source != "" {
+		d.LocalAddr = &net.TCPAddr{IP: net.ParseIP(s.Source)}
+	}
+	upstream, err := d.Dial("tcp", host)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(upstream, conn); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(conn, upstream); done <- struct{}{} }()
+	<-done
+}
+
+// associate relays datagrams between the first client that sends one and the
+// targets named in their headers, until the control connection closes.
+func (s *fakeSOCKS5) associate(conn net.Conn) {
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer relay.Close()
+	addr := relay.LocalAddr().(*net.UDPAddr)
+	reply := append([]byte{5, 0, 0, 1}, addr.IP.To4()...)
+	if s.Relay != "" {
+		reply = append([]byte{5, 0, 0, 3, byte(len(s.Relay))}, s.Relay...)
+	}
+	_, _ = conn.Write(binary.BigEndian.AppendUint16(reply, uint16(addr.Port)))
+
+	go func() {
+		var client net.Addr
+		buf := make([]byte, 64<<10)
+		for {
+			n, from, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if client == nil {
+				client = from
+			}
+			if from.String() == client.String() {
+				off := socksUDPPayload(buf[:n])
+				if off < 0 {
+					continue
+				}
+				target, err := readSOCKSAddr(bytes.NewReader(buf[4:off]), buf[3])
+				if err != nil {
+					continue
+				}
+				if ua, err := net.ResolveUDPAddr("udp", target); err == nil {
+					_, _ = relay.WriteTo(buf[off:n], ua)
+				}
+				continue
+			}
+			if header, err := socksUDPHeader(from); err == nil {
+				_, _ = relay.WriteTo(append(header, buf[:n]...), client)
+			}
+		}
+	}()
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+func (s *fakeSOCKS5) authenticate(conn net.Conn) bool {
+	ver := make([]byte, 2)
+	if _, err := io.ReadFull(conn, ver); err != nil {
+		return false
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return false
+	}
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return false
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return false
+	}
+	ok := string(user) == s.User && string(pass) == s.Pass
+	if s.Auth != nil {
+		ok = s.Auth(string(user), string(pass))
+	}
+	if !ok {
+		_, _ = conn.Write([]byte{1, 1})
+		return false
+	}
+	_, _ = conn.Write([]byte{1, 0})
+	return true
+}
+
+func readSOCKSAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case 1:
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		host = net.IP(b).String()
+	case 4:
+		b := make([]byte, 16)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		host = net.IP(b).String()
+	default:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", err
+		}
+		b := make([]byte, l[0])
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		host = string(b)
+	}
+	p := make([]byte, 2)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(p)))), nil
+}
+
+// closedAddr returns a loopback address nothing is listening on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
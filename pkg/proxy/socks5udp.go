@@ -0,0 +1,319 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SOCKS5 UDP ASSOCIATE (RFC 1928, section 7) for HTTP/3 through socks5
+// proxies: a TCP control connection asks the proxy for a UDP relay, and every
+// datagram to and from the relay carries the address of the target in a
+// small header. The association ends when the control connection closes.
+
+// socksUDPHandshakeTimeout bounds the control handshake when ctx has no deadline.
+const socksUDPHandshakeTimeout = 10 * time.Second
+
+// socksUDPAddr is a target of a relay given by host name, resolved by the
+// proxy as socks5h does for CONNECT.
+type socksUDPAddr string
+
+func (a socksUDPAddr) Network() string { return "udp" }
+func (a socksUDPAddr) String() string  { return string(a) }
+
+// udpTarget returns the address of hostport, an IP one when the host is an IP.
+func udpTarget(hostport string) (net.Addr, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: p}, nil
+	}
+	return socksUDPAddr(hostport), nil
+}
+
+// socksAssociate performs a UDP ASSOCIATE through the SOCKS5 proxy at
+// proxyAddr, authenticating with user and pass when user is set, and returns
+// the packet conn relaying datagrams to target. Failures are proxyErrors, so
+// they are classified like the ones of CONNECT.
+func socksAssociate(ctx context.Context, dialer *proxyDialer, proxyAddr, user, pass string, target net.Addr) (*socksPacketConn, error) {
+	ctrl, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(socksUDPHandshakeTimeout)
+	}
+	_ = ctrl.SetDeadline(deadline)
+	relay, err := socksUDPHandshake(ctx, ctrl, user, pass)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	_ = ctrl.SetDeadline(time.Time{})
+	if relay.IP.IsUnspecified() {
+		// the relay listens on the address the proxy was reached at
+		if host, _, err := net.SplitHostPort(ctrl.RemoteAddr().String()); err == nil {
+			relay.IP = net.ParseIP(host)
+		}
+	}
+
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		ctrl.Close()
+		return nil, &proxyError{class: ErrClassProxyDial, err: err}
+	}
+	c := &socksPacketConn{PacketConn: pc, ctrl: ctrl, relay: relay, target: target, stats: dialer.stats}
+	if c.header, err = socksUDPHeader(target); err != nil {
+		c.Close()
+		return nil, &proxyError{class: ErrClassProxyConfig, err: err}
+	}
+	go func() {
+		// the proxy closes the control connection to end the association
+		_, _ = io.Copy(io.Discard, ctrl)
+		c.Close()
+	}()
+	return c, nil
+}
+
+// socksUDPHandshake negotiates the method of ctrl, authenticates, and sends
+// UDP ASSOCIATE, returning the address of the relay. A relay given by host
+// name is resolved here.
+func socksUDPHandshake(ctx context.Context, ctrl net.Conn, user, pass string) (*net.UDPAddr, error) {
+	methods := []byte{5, 1, 0}
+	if user != "" {
+		methods = []byte{5, 2, 0, 2}
+	}
+	if _, err := ctrl.Write(methods); err != nil {
+		return nil, &proxyError{class: ErrClassProxyReply, err: err}
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(ctrl, resp[:]); err != nil {
+		return nil, &proxyError{class: ErrClassProxyReply, err: err}
+	}
+	switch {
+	case resp[0] != 5:
+		return nil, &proxyError{class: ErrClassProxyReply, err: fmt.Errorf("socks5: unexpected version %d", resp[0])}
+	case resp[1] == 2 && user != "":
+		if len(user) > 255 || len(pass) > 255 {
+			return nil, &proxyError{class: ErrClassProxyConfig, err: errors.New("socks5: username or password too long")}
+		}
+		auth := append([]byte{1, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(pass))), pass...)
+		if _, err := ctrl.Write(auth); err != nil {
+			return nil, &proxyError{class: ErrClassProxyReply, err: err}
+		}
+		if _, err := io.ReadFull(ctrl, resp[:]); err != nil {
+			return nil, &proxyError{class: ErrClassProxyReply, err: err}
+		}
+		if resp[1] != 0 {
+			return nil, &proxyError{class: ErrClassProxyAuth, err: errors.New("socks5: username/password authentication failed")}
+		}
+	case resp[1] != 0:
+		return nil, &proxyError{class: ErrClassProxyAuth, err: errors.New("socks5: no acceptable authentication methods")}
+	}
+
+	// the address datagrams will come from is not known before they are sent
+	if _, err := ctrl.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, &proxyError{class: ErrClassProxyReply, err: err}
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(ctrl, head[:]); err != nil {
+		return nil, &proxyError{class: ErrClassProxyReply, err: err}
+	}
+	if head[1] != 0 {
+		return nil, &proxyError{class: ErrClassProxyReply, err: fmt.Errorf("socks5: UDP ASSOCIATE failed with reply %d", head[1])}
+	}
+	var addr []byte
+	switch head[3] {
+	case 1:
+		addr = make([]byte, 4)
+	case 4:
+		addr = make([]byte, 16)
+	case 3:
+		var n [1]byte
+		if _, err := io.ReadFull(ctrl, n[:]); err != nil {
+			return nil, &proxyError{class: ErrClassProxyReply, err: err}
+		}
+		addr = make([]byte, n[0])
+	default:
+		return nil, &proxyError{class: ErrClassProxyReply, err: fmt.Errorf("socks5: unsupported relay address type %d", head[3])}
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(ctrl, addr); err != nil {
+		return nil, &proxyError{class: ErrClassProxyReply, err: err}
+	}
+	if _, err := io.ReadFull(ctrl, port[:]); err != nil {
+		return nil, &proxyError{class: ErrClassProxyReply, err: err}
+	}
+	relay := &net.UDPAddr{IP: net.IP(addr), Port: int(binary.BigEndian.Uint16(port[:]))}
+	if head[3] == 3 {
+		ip, err := resolveRelay(ctx, string(addr), ctrl.RemoteAddr())
+		if err != nil {
+			return nil, &proxyError{class: ErrClassProxyDial, err: fmt.Errorf("socks5: relay %s: %w", addr, err)}
+		}
+		relay.IP = ip
+	}
+	return relay, nil
+}
+
+// resolveRelay resolves the host name of a relay, preferring an address of the
+// family the proxy was reached over, where the relay most likely listens too.
+func resolveRelay(ctx context.Context, host string, proxy net.Addr) (net.IP, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	v4 := true
+	if a, ok := proxy.(*net.TCPAddr); ok {
+		v4 = a.IP.To4() != nil
+	}
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			return ip, nil
+		}
+	}
+	return ips[0], nil
+}
+
+// socksUDPHeader returns the header of the datagrams sent to target.
+func socksUDPHeader(target net.Addr) ([]byte, error) {
+	host, port, err := net.SplitHostPort(target.String())
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	h := []byte{0, 0, 0}
+	switch ip := net.ParseIP(host); {
+	case ip.To4() != nil:
+		h = append(append(h, 1), ip.To4()...)
+	case ip != nil:
+		h = append(append(h, 4), ip.To16()...)
+	case len(host) > 255:
+		return nil, fmt.Errorf("socks5: host name too long: %s", host)
+	default:
+		h = append(append(h, 3, byte(len(host))), host...)
+	}
+	return binary.BigEndian.AppendUint16(h, uint16(p)), nil
+}
+
+// socksPacketConn exchanges the datagrams of one target through a relay. It
+// is used by a single QUIC connection, which reads from one goroutine.
+type socksPacketConn struct {
+	net.PacketConn
+	ctrl   net.Conn
+	relay  *net.UDPAddr
+	target net.Addr
+	header []byte
+	stats  *proxyStats
+
+	wmu  sync.Mutex
+	wbuf []byte
+	rbuf []byte
+	once sync.Once
+}
+
+func (c *socksPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.wbuf = append(append(c.wbuf[:0], c.header...), p...)
+	n, err := c.PacketConn.WriteTo(c.wbuf, c.relay)
+	if c.stats != nil {
+		c.stats.bytesUp.Add(int64(n))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *socksPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if c.rbuf == nil {
+		c.rbuf = make([]byte, 64<<10)
+	}
+	for {
+		n, from, err := c.PacketConn.ReadFrom(c.rbuf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if c.stats != nil {
+			c.stats.bytesDown.Add(int64(n))
+		}
+		if a, ok := from.(*net.UDPAddr); !ok || !a.IP.Equal(c.relay.IP) || a.Port != c.relay.Port {
+			continue
+		}
+		off := socksUDPPayload(c.rbuf[:n])
+		if off < 0 {
+			continue // fragmented or malformed
+		}
+		return copy(p, c.rbuf[off:n]), c.target, nil
+	}
+}
+
+// socksUDPPayload returns the offset of the payload of a relayed datagram, or
+// -1 for fragments and malformed ones.
+func socksUDPPayload(b []byte) int {
+	if len(b) < 4 || b[2] != 0 {
+		return -1
+	}
+	off := 4
+	switch b[3] {
+	case 1:
+		off += 4
+	case 4:
+		off += 16
+	case 3:
+		if len(b) < 5 {
+			return -1
+		}
+		off += 1 + int(b[4])
+	default:
+		return -1
+	}
+	off += 2
+	if off > len(b) {
+		return -1
+	}
+	return off
+}
+
+func (c *socksPacketConn) Close() error {
+	c.once.Do(func() {
+		c.ctrl.Close()
+		c.PacketConn.Close()
+	})
+	return nil
+}
+
+// SetReadBuffer and SetWriteBuffer let quic-go size the buffers of the socket
+// to the relay, as it does for its own.
+func (c *socksPacketConn) SetReadBuffer(n int) error {
+	u, ok := c.PacketConn.(*net.UDPConn)
+	if !ok {
+		return fmt.Errorf("socks5: cannot set the read buffer of a %T", c.PacketConn)
+	}
+	return u.SetReadBuffer(n)
+}
+
+func (c *socksPacketConn) SetWriteBuffer(n int) error {
+	u, ok := c.PacketConn.(*net.UDPConn)
+	if !ok {
+		return fmt.Errorf("socks5: cannot set the write buffer of a %T", c.PacketConn)
+	}
+	return u.SetWriteBuffer(n)
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencyWindow is the number of recent samples kept for the percentiles.
+	latencyWindow = 1024
+	// latencyAlpha weights the newest sample in the EWMA.
+	latencyAlpha = 0.2
+)
+
+// ProxyStats is a point-in-time view of one proxy's counters. Latencies are in
+// milliseconds, measured until the response headers arrived.
+type ProxyStats struct {
+	URL         string           `json:"url"`
+	Pool        string           `json:"pool"`
+	State       string           `json:"state"` // "healthy", "ejected" or "half_open"
+	Successes   int64            `json:"successes"`
+	Failures    map[string]int64 `json:"failures"` // by error class
+	InFlight    int64            `json:"inFlight" js:"inFlight"`
+	LatencyEWMA float64          `json:"latencyEwma" js:"latencyEwma"`
+	LatencyP50  float64          `json:"latencyP50" js:"latencyP50"`
+	LatencyP95  float64          `json:"latencyP95" js:"latencyP95"`
+	LatencyP99  float64          `json:"latencyP99" js:"latencyP99"`
+	BytesUp     int64            `json:"bytesUp" js:"bytesUp"`
+	BytesDown   int64            `json:"bytesDown" js:"bytesDown"`
+	ConnsNew    int64            `json:"connsNew" js:"connsNew"`       // requests that opened a connection
+	ConnsReused int64            `json:"connsReused" js:"connsReused"` // requests that reused a pooled one
+	LastError   string           `json:"lastError,omitempty" js:"lastError"`
+	BadUntil    string           `json:"badUntil,omitempty" js:"badUntil"`     // RFC 3339, set while ejected
+	ExitIP      string           `json:"exitIp,omitempty" js:"exitIp"`         // set once probeExitIPs found it
+	SharedExit  int              `json:"sharedExit,omitempty" js:"sharedExit"` // proxies leaving through ExitIP, when more than one
+}
+
+// proxyStats holds the counters of one proxy. Methods are no-ops on a nil
+// receiver so direct (proxy-less) requests need no special casing.
+type proxyStats struct {
+	successes   atomic.Int64
+	inFlight    atomic.Int64
+	bytesUp     atomic.Int64
+	bytesDown   atomic.Int64
+	connsNew    atomic.Int64
+	connsReused atomic.Int64
+
+	mu        sync.Mutex
+	failures  map[ErrorClass]int64
+	lastError string
+	ewma      float64
+	samples   []float64 // ring buffer of recent latencies (ms)
+	next      int
+}
+
+// statsFor returns the counters of proxy p, creating them on first use; nil for direct requests.
+func (c *Client) statsFor(p string) *proxyStats {
+	if p == "" {
+		return nil
+	}
+	if v, ok := c.proxyStats.Load(p); ok {
+		return v.(*proxyStats)
+	}
+	v, _ := c.proxyStats.LoadOrStore(p, &proxyStats{})
+	return v.(*proxyStats)
+}
+
+func (s *proxyStats) begin() {
+	if s != nil {
+		s.inFlight.Add(1)
+	}
+}
+
+func (s *proxyStats) end() {
+	if s != nil {
+		s.inFlight.Add(-1)
+	}
+}
+
+func (s *proxyStats) success() {
+	if s != nil {
+		s.successes.Add(1)
+	}
+}
+
+func (s *proxyStats) conn(reused bool) {
+	switch {
+	case s == nil:
+	case reused:
+		s.connsReused.Add(1)
+	default:
+		s.connsNew.Add(1)
+	}
+}
+
+func (s *proxyStats) failure(class ErrorClass, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures == nil {
+		s.failures = map[ErrorClass]int64{}
+	}
+	s.failures[class]++
+	if err != nil {
+		s.lastError = err.Error()
+	}
+}
+
+func (s *proxyStats) latency(d time.Duration) {
+	if s == nil {
+		return
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) == 0 {
+		s.ewma = ms
+	} else {
+		s.ewma += latencyAlpha * (ms - s.ewma)
+	}
+	if len(s.samples) < latencyWindow {
+		s.samples = append(s.samples, ms)
+	} else {
+		s.samples[s.next] = ms
+		s.next = (s.next + 1) % latencyWindow
+	}
+}
+
+func (s *proxyStats) snapshot(out *ProxyStats) {
+	if s == nil {
+		return
+	}
+	out.Successes = s.successes.Load()
+	out.InFlight = s.inFlight.Load()
+	out.BytesUp = s.bytesUp.Load()
+	out.BytesDown = s.bytesDown.Load()
+	out.ConnsNew = s.connsNew.Load()
+	out.ConnsReused = s.connsReused.Load()
+
+	s.mu.Lock()
+	for class, n := range s.failures {
+		out.Failures[string(class)] = n
+	}
+	out.LastError = s.lastError
+	out.LatencyEWMA = s.ewma
+	sorted := slices.Clone(s.samples)
+	s.mu.Unlock()
+
+	sort.Float64s(sorted)
+	out.LatencyP50 = percentile(sorted, 0.50)
+	out.LatencyP95 = percentile(sorted, 0.95)
+	out.LatencyP99 = percentile(sorted, 0.99)
+}
+
+// percentile returns the nearest-rank percentile of sorted values, 0 when empty.
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// GetProxyStats returns the counters of every proxy, pool by pool (the
+// unnamed pool first) in list order, followed by any other proxy used since
+// start (e.g. pinned URLs). Health state is per pool; counters are per proxy.
+func (c *Client) GetProxyStats() []ProxyStats {
+	type row struct {
+		pl  *proxyPool
+		url string
+	}
+	var rows []row
+	seen := map[string]bool{}
+	for _, pl := range c.allPools() {
+		if l := pl.snapshot(); l != nil {
+			for _, e := range l.entries {
+				rows = append(rows, row{pl, e.URL})
+				seen[e.URL] = true
+			}
+		}
+	}
+	var extra []string
+	c.proxyStats.Range(func(k, _ any) bool {
+		if u := k.(string); !seen[u] {
+			extra = append(extra, u)
+		}
+		return true
+	})
+	sort.Strings(extra)
+	for _, u := range extra {
+		rows = append(rows, row{&c.pool, u})
+	}
+
+	now, exits := time.Now(), c.exitIPCounts()
+	out := make([]ProxyStats, 0, len(rows))
+	for _, r := range rows {
+		ps := ProxyStats{URL: r.url, Pool: r.pl.label(), State: "healthy", Failures: map[string]int64{}}
+		if v, ok := c.proxyStats.Load(r.url); ok {
+			v.(*proxyStats).snapshot(&ps)
+		}
+		if ps.ExitIP = c.exitIP(r.url); exits[ps.ExitIP] > 1 {
+			ps.SharedExit = exits[ps.ExitIP]
+		}
+		if v, ok := r.pl.health.Load(r.url); ok {
+			var until time.Time
+			ps.State, until = v.(*proxyHealth).status(c.healthFor(r.pl), now)
+			if !until.IsZero() {
+				ps.BadUntil = until.Format(time.RFC3339Nano)
+			}
+		}
+		out = append(out, ps)
+	}
+	return out
+}
+
+// countingConn adds the bytes written to and read from a proxy connection to its stats.
+type countingConn struct {
+	net.Conn
+	stats *proxyStats
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.bytesDown.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.bytesUp.Add(int64(n))
+	return n, err
+}
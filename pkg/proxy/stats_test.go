@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Given a pool with a working SOCKS proxy and a dead one
+// When requests fail over from the dead proxy to the working one
+// Then GetProxyStats reports successes, failures by class, traffic, latency and ejection
+func TestGetProxyStats_GivenTraffic_WhenQueried_ThenCountersReported(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer ts.Close()
+	good := startFakeSOCKS5(t, nil)
+	dead := "socks5://" + closedAddr(t)
+	path := writeProxiesFile(t, t.TempDir(), []string{dead, good.URL()})
+
+	c := newHealthClient()
+	for i := 0; i < 3; i++ {
+		resp := doRequest(t, c, map[string]any{
+			"url":   ts.URL,
+			"proxy": map[string]any{"listPath": path, "maxRetries": int64(1), "retryBackoff": "1ms"},
+		})
+		if resp.Status != http.StatusOK {
+			t.Fatalf("status=%d err=%s", resp.Status, resp.Error)
+		}
+	}
+
+	stats := c.GetProxyStats()
+	if len(stats) != 2 || stats[0].URL != dead || stats[1].URL != good.URL() {
+		t.Fatalf("unexpected stats order: %+v", stats)
+	}
+	d, g := stats[0], stats[1]
+	if d.Failures[string(ErrClassProxyDial)] != 1 || d.Successes != 0 || d.State != "ejected" || d.BadUntil == "" || d.LastError == "" {
+		t.Fatalf("dead proxy stats: %+v", d)
+	}
+	if g.Successes != 3 || len(g.Failures) != 0 || g.State != "healthy" || g.InFlight != 0 {
+		t.Fatalf("good proxy stats: %+v", g)
+	}
+	if g.BytesDown < 3000 || g.BytesUp == 0 {
+		t.Fatalf("traffic not counted: up=%d down=%d", g.BytesUp, g.BytesDown)
+	}
+	if g.LatencyEWMA <= 0 || g.LatencyP50 <= 0 || g.LatencyP99 < g.LatencyP50 {
+		t.Fatalf("latency: ewma=%v p50=%v p99=%v", g.LatencyEWMA, g.LatencyP50, g.LatencyP99)
+	}
+}
+
+// Given latencies of 1..100ms
+// When the stats snapshot is taken
+// Then nearest-rank percentiles are reported
+func TestProxyStats_GivenLatencies_WhenSnapshot_ThenPercentiles(t *testing.T) {
+	t.Parallel()
+	s := &proxyStats{}
+	for i := 100; i >= 1; i-- {
+		s.latency(time.Duration(i) * time.Millisecond)
+	}
+	ps := ProxyStats{Failures: map[string]int64{}}
+	s.snapshot(&ps)
+	if ps.LatencyP50 != 50 || ps.LatencyP95 != 95 || ps.LatencyP99 != 99 {
+		t.Fatalf("p50=%v p95=%v p99=%v", ps.LatencyP50, ps.LatencyP95, ps.LatencyP99)
+	}
+	if ps.LatencyEWMA <= 1 || ps.LatencyEWMA >= 10 {
+		t.Fatalf("ewma=%v should follow the recent (small) samples", ps.LatencyEWMA)
+	}
+}
+
+// Given more samples than the latency window
+// When the oldest ones were slow
+// Then they no longer affect the percentiles
+func TestProxyStats_GivenWindowOverflow_WhenSnapshot_ThenOldSamplesDropped(t *testing.T) {
+	t.Parallel()
+	s := &proxyStats{}
+	for i := 0; i < latencyWindow; i++ {
+		s.latency(time.Second)
+	}
+	for i := 0; i < latencyWindow; i++ {
+		s.latency(time.Millisecond)
+	}
+	ps := ProxyStats{Failures: map[string]int64{}}
+	s.snapshot(&ps)
+	if ps.LatencyP99 != 1 {
+		t.Fatalf("p99=%v want 1", ps.LatencyP99)
+	}
+}
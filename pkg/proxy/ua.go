@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"math/rand"
+	"os"
+	"slices"
 	"time"
 )
 
@@ -20,30 +22,51 @@ func (c *Client) getUASlice() []string {
 
 // LoadUserAgents loads user agents from a file into an atomic snapshot.
 // It ignores empty lines and lines starting with '#'. If the file path is empty,
-// it clears the UA list. It also compares mtime to avoid unnecessary reloads.
+// it clears the UA list. It also compares mtime and content to avoid unnecessary reloads.
 func (c *Client) LoadUserAgents(path string) error {
+	c.listMu.Lock()
+	defer c.listMu.Unlock()
+	return c.loadUserAgents(path)
+}
+
+// loadUserAgents is LoadUserAgents for callers holding listMu. The file is only read when its
+// mtime moved, and the snapshot is only swapped when the lines changed.
+func (c *Client) loadUserAgents(path string) error {
 	if path == "" {
 		// clear list
 		c.uaListVal.Store([]string{})
+		c.uaLoadedPath.Store("")
 		c.uaListPath = ""
 		c.uaListMTime = time.Time{}
+		c.recordListReload(listKindUserAgent)
 		return nil
 	}
 
-	lines, mtime, err := readLines(path)
+	fi, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-
 	// If same file and not modified, skip reload
-	if c.uaListPath == path && !mtime.After(c.uaListMTime) {
+	if c.uaListPath == path && !fi.ModTime().After(c.uaListMTime) {
+		return nil
+	}
+
+	lines, mtime, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	if c.uaListPath == path && slices.Equal(lines, c.getUASlice()) {
+		c.uaListMTime = mtime
 		return nil
 	}
 
 	// store snapshot atomically (can be empty)
 	c.uaListVal.Store(lines)
+	c.uaLoadedPath.Store(path)
 	c.uaListPath = path
 	c.uaListMTime = mtime
+	c.recordListReload(listKindUserAgent)
+	c.startListWatcher()
 
 	return nil
 }